@@ -0,0 +1,242 @@
+package dbdiff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultBisectionSegments = 16
+	DefaultBisectionMinRows  = 1000
+)
+
+// Options for BisectionDiff
+type BisectionOptions struct {
+	// Number of segments a differing range is split into. 0 means DefaultBisectionSegments.
+	Segments int
+	// Ranges having at most this number of rows are compared row by row. 0 means DefaultBisectionMinRows.
+	MinRows int
+}
+
+// Statistics of BisectionDiff
+type BisectionStats struct {
+	ChecksumQueries int    // Number of checksum queries on each database
+	FetchedRows     uint64 // Number of rows fetched from both databases
+	SkippedTables   int    // Number of tables whose checksums are equal
+}
+
+type bisection struct {
+	source       DbHolder
+	sourceConfig *Configuration
+	target       DbHolder
+	targetConfig *Configuration
+	options      BisectionOptions
+	stats        *BisectionStats
+	comparer     *ValueComparer
+}
+
+// Compare tables of two databases without transferring all rows.
+//
+// Each table's primary key range is split into segments, and checksums of each segment are computed on both
+// databases. Only differing segments are split recursively, and small enough segments are fetched and compared row
+// by row. source is treated as before data and target as after data of AllTableStore#ExtractChangedData().
+//
+// Bisection is used for tables with a single integer primary key on the databases of the same dbtype.
+// Other tables are compared by a checksum of the whole table, then row by row if it differs.
+// Snapshot.Timeout and Snapshot.QueryTimeout of sourceConfig limit the whole comparison and each query, and rows are
+// compared by the comparison rules of sourceConfig.
+func BisectionDiff(ctx context.Context, source DbHolder, sourceConfig *Configuration, target DbHolder, targetConfig *Configuration, tablePks map[string][]string, options BisectionOptions) (map[string][]*RowObject, *BisectionStats, error) {
+	if options.Segments < 2 {
+		options.Segments = DefaultBisectionSegments
+	}
+	if options.MinRows <= 0 {
+		options.MinRows = DefaultBisectionMinRows
+	}
+	for tableName := range tablePks {
+		if sourceConfig.isSampled(tableName) || targetConfig.isSampled(tableName) {
+			// 両方のデータベースで同じ行が選ばれるとは限らない
+			return nil, nil, fmt.Errorf("%s: sampling is not supported by bisection", tableName)
+		}
+	}
+	comparer, err := sourceConfig.ValueComparer()
+	if err != nil {
+		return nil, nil, err
+	}
+	b := &bisection{source: source, sourceConfig: sourceConfig, target: target, targetConfig: targetConfig, options: options, stats: &BisectionStats{}, comparer: comparer}
+	ctx, cancel := withTimeout(ctx, sourceConfig.Snapshot.Timeout)
+	defer cancel()
+	if err := ResolveTableFilters(ctx, source, sourceConfig, tablePks); err != nil {
+		return nil, nil, err
+	}
+	if err := ResolveTableFilters(ctx, target, targetConfig, tablePks); err != nil {
+		return nil, nil, err
+	}
+
+	var output = map[string][]*RowObject{}
+	for tableName, pkColumns := range tablePks {
+		outputTableData, err := b.compareTable(ctx, tableName, pkColumns)
+		if err != nil {
+			return nil, nil, &ErrCollectTable{Table: tableName, Err: err}
+		}
+		output[tableName] = outputTableData
+	}
+	return output, b.stats, nil
+}
+
+func (b *bisection) compareTable(ctx context.Context, tableName string, pkColumns []string) ([]*RowObject, error) {
+	if b.sourceConfig.Db.DbType != b.targetConfig.Db.DbType {
+		// チェックサムが比較できない
+		return b.compareRows(ctx, tableName, pkColumns, "")
+	}
+	columns, err := GetColumnNames(ctx, b.source, tableName, b.sourceConfig.Db.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pkColumns) == 1 && len(pkColumns) < len(columns) {
+		lo, hi, ok, err := b.keyRange(ctx, tableName, pkColumns[0])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return b.bisect(ctx, tableName, pkColumns, columns, lo, hi)
+		}
+	}
+
+	equal, _, err := b.compareChecksums(ctx, tableName, columns, "")
+	if err != nil {
+		return nil, err
+	}
+	if equal {
+		b.stats.SkippedTables++
+		return nil, nil
+	}
+	return b.compareRows(ctx, tableName, pkColumns, "")
+}
+
+// Range [lo, hi) of the integer primary key on both databases. ok is false if the key is not an integer.
+// Errors of the queries(including timeouts and cancellation) are returned as err.
+func (b *bisection) keyRange(ctx context.Context, tableName string, pk string) (lo int64, hi int64, ok bool, err error) {
+	// 整数以外のキーをエラーと区別するため、文字列で取得してから変換する
+	var min, max sql.NullString
+	lo, hi = math.MaxInt64, math.MinInt64
+	for _, side := range []struct {
+		db     DbHolder
+		config *Configuration
+	}{{b.source, b.sourceConfig}, {b.target, b.targetConfig}} {
+		query := fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s", pk, pk, side.config.Db.Schema+tableName) + whereClause(side.config.tableFilter(tableName))
+		queryCtx, cancel := queryContext(ctx, side.config)
+		err := side.db.QueryRowContext(queryCtx, query).Scan(&min, &max)
+		cancel()
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if !min.Valid || !max.Valid {
+			continue
+		}
+		minValue, errMin := strconv.ParseInt(strings.TrimSpace(min.String), 10, 64)
+		maxValue, errMax := strconv.ParseInt(strings.TrimSpace(max.String), 10, 64)
+		if errMin != nil || errMax != nil {
+			return 0, 0, false, nil
+		}
+		if minValue < lo {
+			lo = minValue
+		}
+		if maxValue > hi {
+			hi = maxValue
+		}
+	}
+	if lo > hi {
+		// 両方とも空
+		return 0, 0, true, nil
+	}
+	// hi+1やhi-loがオーバーフローする範囲は扱わない
+	if lo < math.MinInt64/2 || hi > math.MaxInt64/2 {
+		return 0, 0, false, nil
+	}
+	return lo, hi + 1, true, nil
+}
+
+func (b *bisection) bisect(ctx context.Context, tableName string, pkColumns []string, columns []string, lo int64, hi int64) ([]*RowObject, error) {
+	if lo >= hi {
+		return nil, nil
+	}
+	pk := pkColumns[0]
+	where := func(config *Configuration) string {
+		dbType := config.Db.DbType
+		return fmt.Sprintf("%s >= %s AND %s < %s", pk, placeholder(dbType, 1), pk, placeholder(dbType, 2))
+	}
+
+	equal, count, err := b.compareChecksums(ctx, tableName, columns, where(b.sourceConfig), lo, hi)
+	if err != nil {
+		return nil, err
+	}
+	if equal {
+		return nil, nil
+	}
+	if count <= int64(b.options.MinRows) || hi-lo <= int64(b.options.Segments) {
+		return b.compareRows(ctx, tableName, pkColumns, where(b.sourceConfig), lo, hi)
+	}
+
+	var outputTableData []*RowObject
+	step := (hi - lo + int64(b.options.Segments) - 1) / int64(b.options.Segments)
+	for start := lo; start < hi; start += step {
+		end := start + step
+		if end > hi {
+			end = hi
+		}
+		rows, err := b.bisect(ctx, tableName, pkColumns, columns, start, end)
+		if err != nil {
+			return nil, err
+		}
+		outputTableData = append(outputTableData, rows...)
+	}
+	return outputTableData, nil
+}
+
+// Whether checksums of both databases are equal, and the larger row count
+func (b *bisection) compareChecksums(ctx context.Context, tableName string, columns []string, where string, args ...interface{}) (bool, int64, error) {
+	b.stats.ChecksumQueries++
+	sourceChecksum, err := getRangeChecksum(ctx, b.source, b.sourceConfig, tableName, columns, where, args...)
+	if err != nil {
+		return false, 0, err
+	}
+	targetChecksum, err := getRangeChecksum(ctx, b.target, b.targetConfig, tableName, columns, where, args...)
+	if err != nil {
+		return false, 0, err
+	}
+	count := checksumRowCount(sourceChecksum)
+	if targetCount := checksumRowCount(targetChecksum); targetCount > count {
+		count = targetCount
+	}
+	return sourceChecksum == targetChecksum, count, nil
+}
+
+func checksumRowCount(checksum string) int64 {
+	count, _ := strconv.ParseInt(strings.SplitN(checksum, ":", 2)[0], 10, 64)
+	return count
+}
+
+// Fetch the rows matching where from both databases and compare them
+func (b *bisection) compareRows(ctx context.Context, tableName string, pkColumns []string, where string, args ...interface{}) ([]*RowObject, error) {
+	queryCtx, cancel := queryContext(ctx, b.sourceConfig)
+	defer cancel()
+	sourceColumns, sourceRows, err := collectTableRows(queryCtx, b.source, b.sourceConfig, tableQuery(b.sourceConfig, tableName, pkColumns, where), pkColumns, args...)
+	if err != nil {
+		return nil, err
+	}
+	// 値の持ち方(バイナリの要約、長い文字列の切り詰め)を揃えるため、比較先も比較元の設定で読む
+	targetColumns, targetRows, err := collectTableRows(queryCtx, b.target, b.sourceConfig, tableQuery(b.targetConfig, tableName, pkColumns, where), pkColumns, args...)
+	if err != nil {
+		return nil, err
+	}
+	b.stats.FetchedRows += uint64(len(sourceRows) + len(targetRows))
+
+	before := &AllTableStore{AllData: map[string]map[string]*RowObject{tableName: sourceRows}, AllColumn: map[string][]string{tableName: sourceColumns}}
+	after := &AllTableStore{AllData: map[string]map[string]*RowObject{tableName: targetRows}, AllColumn: map[string][]string{tableName: targetColumns}, comparer: b.comparer}
+	return after.ExtractChangedData(before)[tableName], nil
+}
@@ -0,0 +1,225 @@
+package dbdiff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Table "items"(id, name) of fakeDB answering the queries of bisection on MySQL
+type fakeBisectionTable struct {
+	rows      map[int64]string
+	keys      []string // string keys instead of the integer ids, if not nil
+	rangeErr  error    // error of the key range query
+	checksums [][2]int64
+}
+
+func newFakeBisectionTable(count int64, names map[int64]string) *fakeBisectionTable {
+	table := &fakeBisectionTable{rows: map[int64]string{}}
+	for id := int64(0); id < count; id++ {
+		table.rows[id] = fmt.Sprintf("item%d", id)
+		if name, ok := names[id]; ok {
+			table.rows[id] = name
+		}
+	}
+	return table
+}
+
+func (ft *fakeBisectionTable) query(query string, args []interface{}) (*fakeResult, error) {
+	// [lo, hi)の範囲の行
+	ids := ft.sortedIds()
+	if len(args) == 2 {
+		lo, hi := args[0].(int64), args[1].(int64)
+		var inRange []int64
+		for _, id := range ids {
+			if lo <= id && id < hi {
+				inRange = append(inRange, id)
+			}
+		}
+		ids = inRange
+	}
+
+	switch {
+	case strings.HasSuffix(query, "WHERE 1 = 0"):
+		return &fakeResult{columns: []string{"id", "name"}}, nil
+	case strings.HasPrefix(query, "SELECT MIN(id), MAX(id)"):
+		if ft.rangeErr != nil {
+			return nil, ft.rangeErr
+		}
+		if ft.keys != nil {
+			return &fakeResult{columns: []string{"min", "max"}, rows: [][]interface{}{{ft.keys[0], ft.keys[len(ft.keys)-1]}}}, nil
+		}
+		if len(ids) == 0 {
+			return &fakeResult{columns: []string{"min", "max"}, rows: [][]interface{}{{nil, nil}}}, nil
+		}
+		return &fakeResult{columns: []string{"min", "max"}, rows: [][]interface{}{{ids[0], ids[len(ids)-1]}}}, nil
+	case strings.HasPrefix(query, "SELECT COUNT(*)"):
+		if len(args) == 2 {
+			ft.checksums = append(ft.checksums, [2]int64{args[0].(int64), args[1].(int64)})
+		}
+		var values []string
+		for _, id := range ids {
+			values = append(values, fmt.Sprintf("%d|%s", id, ft.rows[id]))
+		}
+		return &fakeResult{columns: []string{"count", "checksum"},
+			rows: [][]interface{}{{int64(len(ids)), strings.Join(values, ",")}}}, nil
+	case strings.HasPrefix(query, "SELECT * FROM"):
+		result := &fakeResult{columns: []string{"id", "name"}}
+		for _, id := range ids {
+			result.rows = append(result.rows, []interface{}{id, ft.rows[id]})
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unexpected query: %s", query)
+}
+
+func (ft *fakeBisectionTable) sortedIds() []int64 {
+	var ids []int64
+	for id := range ft.rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestBisectionDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		source        *fakeBisectionTable
+		target        *fakeBisectionTable
+		options       BisectionOptions
+		wantKeys      []string
+		wantChecksums [][2]int64 // ranges of the checksum queries
+		wantStats     BisectionStats
+	}{
+		{
+			name:          "Equal",
+			source:        newFakeBisectionTable(100, nil),
+			target:        newFakeBisectionTable(100, nil),
+			options:       BisectionOptions{Segments: 4, MinRows: 10},
+			wantChecksums: [][2]int64{{0, 100}},
+			wantStats:     BisectionStats{ChecksumQueries: 1},
+		},
+		{
+			// 範囲の両端(lo、hi-1)の変更も見つかる
+			name:     "Edges",
+			source:   newFakeBisectionTable(100, nil),
+			target:   newFakeBisectionTable(100, map[int64]string{0: "changed", 99: "changed"}),
+			options:  BisectionOptions{Segments: 4, MinRows: 10},
+			wantKeys: []string{"0", "99"},
+			wantChecksums: [][2]int64{{0, 100},
+				{0, 25}, {0, 7}, {7, 14}, {14, 21}, {21, 25}, {25, 50}, {50, 75},
+				{75, 100}, {75, 82}, {82, 89}, {89, 96}, {96, 100}},
+			wantStats: BisectionStats{ChecksumQueries: 13, FetchedRows: 22},
+		},
+		{
+			// 行数がMinRows以下なら分割せずに行を比較する
+			name:          "MinRows",
+			source:        newFakeBisectionTable(100, nil),
+			target:        newFakeBisectionTable(100, map[int64]string{50: "changed"}),
+			options:       BisectionOptions{Segments: 4, MinRows: 100},
+			wantKeys:      []string{"50"},
+			wantChecksums: [][2]int64{{0, 100}},
+			wantStats:     BisectionStats{ChecksumQueries: 1, FetchedRows: 200},
+		},
+		{
+			// キーの範囲がSegments以下なら分割しない
+			name:          "Segments",
+			source:        newFakeBisectionTable(10, nil),
+			target:        newFakeBisectionTable(10, map[int64]string{5: "changed"}),
+			options:       BisectionOptions{Segments: 10, MinRows: 1},
+			wantKeys:      []string{"5"},
+			wantChecksums: [][2]int64{{0, 10}},
+			wantStats:     BisectionStats{ChecksumQueries: 1, FetchedRows: 20},
+		},
+		{
+			// 追加された行で範囲が広がる
+			name:          "Inserted",
+			source:        newFakeBisectionTable(4, nil),
+			target:        newFakeBisectionTable(5, nil),
+			options:       BisectionOptions{Segments: 2, MinRows: 1},
+			wantKeys:      []string{"4"},
+			wantChecksums: [][2]int64{{0, 5}, {0, 3}, {3, 5}},
+			wantStats:     BisectionStats{ChecksumQueries: 3, FetchedRows: 3},
+		},
+		{
+			name:      "Empty",
+			source:    newFakeBisectionTable(0, nil),
+			target:    newFakeBisectionTable(0, nil),
+			options:   BisectionOptions{Segments: 4, MinRows: 10},
+			wantStats: BisectionStats{},
+		},
+		{
+			// 整数以外のキーはテーブル全体のチェックサムで比較する
+			name:      "NotInteger",
+			source:    &fakeBisectionTable{rows: map[int64]string{1: "a"}, keys: []string{"a", "b"}},
+			target:    &fakeBisectionTable{rows: map[int64]string{1: "b"}, keys: []string{"a", "b"}},
+			options:   BisectionOptions{Segments: 4, MinRows: 10},
+			wantKeys:  []string{"1"},
+			wantStats: BisectionStats{ChecksumQueries: 1, FetchedRows: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Configuration{Db: Db{DbType: "mysql"}}
+			got, stats, err := BisectionDiff(context.Background(), openFakeDB(t, tt.source.query), config,
+				openFakeDB(t, tt.target.query), config, map[string][]string{"items": {"id"}}, tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, row := range got["items"] {
+				if !row.IsBeforeData {
+					keys = append(keys, row.GetKey([]string{"id"}))
+				}
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("BisectionDiff() keys = %v, want %v", keys, tt.wantKeys)
+			}
+			if !reflect.DeepEqual(tt.source.checksums, tt.wantChecksums) {
+				t.Errorf("BisectionDiff() checksum ranges = %v, want %v", tt.source.checksums, tt.wantChecksums)
+			}
+			if *stats != tt.wantStats {
+				t.Errorf("BisectionDiff() stats = %+v, want %+v", *stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestBisectionDiff_keyRangeError(t *testing.T) {
+	source := newFakeBisectionTable(10, nil)
+	target := newFakeBisectionTable(10, nil)
+	target.rangeErr = errors.New("connection reset")
+	config := &Configuration{Db: Db{DbType: "mysql"}}
+	_, _, err := BisectionDiff(context.Background(), openFakeDB(t, source.query), config,
+		openFakeDB(t, target.query), config, map[string][]string{"items": {"id"}}, BisectionOptions{})
+	var collectErr *ErrCollectTable
+	if !errors.As(err, &collectErr) || collectErr.Table != "items" || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("BisectionDiff() error = %v, want ErrCollectTable of the key range query", err)
+	}
+	if len(source.checksums) > 0 {
+		t.Errorf("BisectionDiff() compared checksums %v after the error", source.checksums)
+	}
+}
+
+func Test_checksumRowCount(t *testing.T) {
+	tests := []struct {
+		checksum string
+		want     int64
+	}{
+		{"12:3f9a0c2e", 12},
+		{"0:", 0},
+		{"3:a:b", 3},
+		{"invalid", 0},
+	}
+	for _, tt := range tests {
+		if got := checksumRowCount(tt.checksum); got != tt.want {
+			t.Errorf("checksumRowCount(%v) = %v, want %v", tt.checksum, got, tt.want)
+		}
+	}
+}
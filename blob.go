@@ -0,0 +1,196 @@
+package dbdiff
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Database type names(sql.ColumnType#DatabaseTypeName()) of binary columns
+var binaryTypeNames = map[string]bool{
+	"BYTEA":      true, // PostgreSQL
+	"BLOB":       true, // MySQL(TEXT columns are reported as TEXT)
+	"TINYBLOB":   true,
+	"MEDIUMBLOB": true,
+	"LONGBLOB":   true,
+	"BINARY":     true, // MySQL, SQL Server
+	"VARBINARY":  true,
+	"IMAGE":      true, // SQL Server
+}
+
+// Length of the hash shown in the summary of binary values and the truncation marker of text values
+const cellHashLength = 16
+
+// How the scanned values are held(see ColumnScan#Scan())
+type cellOptions struct {
+	maxLength    int  // text values longer than this(in characters) are truncated, disabled if 0
+	previewBytes int  // leading bytes of binary values shown in hex
+	keepBlobs    bool // keep binary values to export them(see ExportBlobs())
+}
+
+func (c *Configuration) cellOptions() *cellOptions {
+	return &cellOptions{
+		maxLength:    c.Snapshot.MaxCellLength,
+		previewBytes: c.Snapshot.BlobPreviewBytes,
+		keepBlobs:    c.Snapshot.BlobExportDir != "",
+	}
+}
+
+// Value held by the column instead of the scanned value.
+// Binary values are replaced with their summary, so that they are compared by the length and the hash.
+func (o *cellOptions) convert(col *ColumnScan, value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		if col.binary || !utf8.Valid(v) {
+			if o.keepBlobs {
+				col.blob = append([]byte{}, v...)
+			}
+			return SummarizeBlob(v, o.previewBytes)
+		}
+		if o.maxLength > 0 {
+			return TruncateCellValue(string(v), o.maxLength)
+		}
+	case string:
+		if o.maxLength > 0 {
+			return TruncateCellValue(v, o.maxLength)
+		}
+	}
+	return value
+}
+
+// Summary of a binary value like "<blob 12KB sha256:3f9a0c2e81b4d7a6>".
+// With previewBytes, the leading bytes are added in hex like "<blob 12KB sha256:3f9a0c2e81b4d7a6 0x89504e47…>".
+func SummarizeBlob(b []byte, previewBytes int) string {
+	sum := sha256.Sum256(b)
+	summary := fmt.Sprintf("<blob %s sha256:%s", formatByteSize(len(b)), hex.EncodeToString(sum[:])[:cellHashLength])
+	if previewBytes > 0 {
+		if len(b) > previewBytes {
+			summary += " 0x" + hex.EncodeToString(b[:previewBytes]) + "…"
+		} else {
+			summary += " 0x" + hex.EncodeToString(b)
+		}
+	}
+	return summary + ">"
+}
+
+// Truncate a text longer than maxLength(in characters) like "abc…<+1234 chars sha256:3f9a0c2e81b4d7a6>".
+// The marker has the hash of the whole value, so that truncated values are different if the values are different.
+func TruncateCellValue(s string, maxLength int) string {
+	if maxLength <= 0 || utf8.RuneCountInString(s) <= maxLength {
+		return s
+	}
+	r := []rune(s)
+	sum := sha256.Sum256([]byte(s))
+	return fmt.Sprintf("%s…<+%d chars sha256:%s>", string(r[:maxLength]), len(r)-maxLength, hex.EncodeToString(sum[:])[:cellHashLength])
+}
+
+// 512B, 1.5KB, 12KB, 3.2MB
+func formatByteSize(size int) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 || value >= 10 {
+		return fmt.Sprintf("%.0f%s", value, units[unit])
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
+
+// Binary value of the column, only if it was kept on scan(see Snapshot.BlobExportDir).
+func (rs *ColumnScan) BlobValue() []byte {
+	return rs.blob
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9=._-]+`)
+
+// Write the binary values of the changed rows to files like "dir/table/id=1.image.before.bin", and return the paths.
+//
+// Modified columns of updated rows(and rows whose key is changed) and all binary columns of inserted and deleted rows
+// are written. Only the values kept on scan are written, so masked columns and the values read back from temporary files
+// (CompactTableStore, SpillingTableStore) are not. File names have the keys of the rows, so tables whose primary key
+// is masked by masking are skipped and returned as skippedTables.
+func ExportBlobs(dir string, changedData map[string][]*RowObject, tablePks map[string][]string, masking MaskingConfig) (files []string, skippedTables []string, err error) {
+	for _, tableName := range SortedTableNames(changedData) {
+		pkColumns := tablePks[tableName]
+		if isMaskedKey(masking, tableName, pkColumns) {
+			// マスクされたキーではファイル名が重複し、マスク前のキーでは値が漏れるので出力しない
+			if hasBlobs(changedData[tableName]) {
+				skippedTables = append(skippedTables, tableName)
+			}
+			continue
+		}
+		for _, row := range changedData[tableName] {
+			var label string
+			switch row.DiffStatus {
+			case DiffStatusAdd:
+				label = "inserted"
+			case DiffStatusDel:
+				label = "deleted"
+			case DiffStatusMod, DiffStatusKeyChanged:
+				label = "after"
+				if row.IsBeforeData {
+					label = "before"
+				}
+			default:
+				continue
+			}
+			key := blobFileKey(row.KeyString(pkColumns))
+			for index, col := range row.ColScans {
+				if col.blob == nil || (isPairedStatus(row.DiffStatus) && !row.IsModifiedColumn(index)) {
+					continue
+				}
+				tableDir := filepath.Join(dir, unsafeFileNameChars.ReplaceAllString(tableName, "_"))
+				if err := os.MkdirAll(tableDir, 0755); err != nil {
+					return files, skippedTables, err
+				}
+				fileName := filepath.Join(tableDir,
+					key+"."+unsafeFileNameChars.ReplaceAllString(row.ColumnNames[index], "_")+"."+label+".bin")
+				if err := ioutil.WriteFile(fileName, col.blob, 0644); err != nil {
+					return files, skippedTables, err
+				}
+				files = append(files, fileName)
+			}
+		}
+	}
+	return files, skippedTables, nil
+}
+
+func isMaskedKey(masking MaskingConfig, tableName string, pkColumns []string) bool {
+	for _, strategy := range masking.columnStrategies(tableName, pkColumns) {
+		if strategy != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasBlobs(rows []*RowObject) bool {
+	for _, row := range rows {
+		for _, col := range row.ColScans {
+			if col.blob != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Part of the file name for the key of the row. Long keys(e.g. tables without a primary key) are hashed.
+func blobFileKey(key string) string {
+	const maxKeyLength = 100
+	name := strings.Trim(unsafeFileNameChars.ReplaceAllString(key, "_"), "_")
+	if name == "" || len(name) > maxKeyLength {
+		sum := sha256.Sum256([]byte(key))
+		return "row-" + hex.EncodeToString(sum[:])[:cellHashLength]
+	}
+	return name
+}
@@ -0,0 +1,142 @@
+package dbdiff
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestColumnScan_Scan_cellOptions(t *testing.T) {
+	png := []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a}
+	long := strings.Repeat("あ", 5) + "いう"
+	tests := []struct {
+		name   string
+		cells  *cellOptions
+		binary bool
+		value  interface{}
+		want   string
+	}{
+		{"BinaryColumn", &cellOptions{}, true, []byte("abc"), "<blob 3B sha256:ba7816bf8f01cfea>"},
+		{"InvalidUTF8", &cellOptions{}, false, png, "<blob 8B sha256:4c4b6a3be1314ab8>"},
+		{"Preview", &cellOptions{previewBytes: 4}, false, png, "<blob 8B sha256:4c4b6a3be1314ab8 0x89504e47…>"},
+		{"PreviewWhole", &cellOptions{previewBytes: 8}, false, png, "<blob 8B sha256:4c4b6a3be1314ab8 0x89504e470d0a1a0a>"},
+		{"Text", &cellOptions{}, false, []byte(long), long},
+		{"TruncatedBytes", &cellOptions{maxLength: 5}, false, []byte(long), "あああああ…<+2 chars sha256:4d7744b6bd1436a1>"},
+		{"TruncatedString", &cellOptions{maxLength: 6}, false, long, "あああああい…<+1 chars sha256:4d7744b6bd1436a1>"},
+		{"NotTruncated", &cellOptions{maxLength: 7}, false, long, long},
+		{"NoOptions", nil, true, []byte("abc"), "abc"},
+		{"Null", &cellOptions{}, true, nil, "<NULL>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			col := &ColumnScan{Value: new(sql.NullString), cells: tt.cells, binary: tt.binary}
+			if err := col.Scan(tt.value); err != nil {
+				t.Fatal(err)
+			}
+			if got := col.GetValueString(); got != tt.want {
+				t.Errorf("GetValueString() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if a, b := TruncateCellValue(long+"x", 5), TruncateCellValue(long+"y", 5); a == b {
+		t.Errorf("truncated values of different values are equal: %v", a)
+	}
+}
+
+func Test_formatByteSize(t *testing.T) {
+	tests := []struct {
+		size int
+		want string
+	}{
+		{512, "512B"},
+		{1536, "1.5KB"},
+		{12 * 1024, "12KB"},
+		{3*1024*1024 + 300*1024, "3.3MB"},
+	}
+	for _, tt := range tests {
+		if got := formatByteSize(tt.size); got != tt.want {
+			t.Errorf("formatByteSize(%d) = %v, want %v", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestExportBlobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbdiff-blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cells := &cellOptions{keepBlobs: true}
+	row := func(status int8, before bool, id string, image []byte, thumbnail interface{}) *RowObject {
+		var colScans []*ColumnScan
+		for i, value := range []interface{}{[]byte(id), image, thumbnail} {
+			col := &ColumnScan{Value: new(sql.NullString), cells: cells, binary: i > 0}
+			if err := col.Scan(value); err != nil {
+				t.Fatal(err)
+			}
+			colScans = append(colScans, col)
+		}
+		return &RowObject{DiffStatus: status, IsBeforeData: before, ColumnNames: []string{"id", "image", "thumbnail"}, ColScans: colScans}
+	}
+	before := row(DiffStatusMod, true, "1", []byte("old"), []byte("same"))
+	after := row(DiffStatusMod, false, "1", []byte("new"), []byte("same"))
+	if before.EqualColumns(after) {
+		t.Fatal("EqualColumns() = true, want false")
+	}
+	keyBefore := row(DiffStatusKeyChanged, true, "3", []byte("same"), []byte("thumb"))
+	keyAfter := row(DiffStatusKeyChanged, false, "4", []byte("same"), []byte("thumb2"))
+	keyBefore.EqualColumns(keyAfter)
+	changedData := map[string][]*RowObject{
+		"public.photos": {before, after, row(DiffStatusAdd, false, "2", []byte("added"), nil), keyBefore, keyAfter},
+	}
+	tablePks := map[string][]string{"public.photos": {"id"}}
+
+	files, skippedTables, err := ExportBlobs(dir, changedData, tablePks, MaskingConfig{})
+	if err != nil || skippedTables != nil {
+		t.Fatalf("ExportBlobs() skippedTables = %v, err = %v", skippedTables, err)
+	}
+	want := map[string]string{
+		"public.photos/id=1.image.before.bin":     "old",
+		"public.photos/id=1.image.after.bin":      "new",
+		"public.photos/id=2.image.inserted.bin":   "added",
+		"public.photos/id=3.thumbnail.before.bin": "thumb",
+		"public.photos/id=4.thumbnail.after.bin":  "thumb2",
+	}
+	got := map[string]string{}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(dir, file)
+		got[filepath.ToSlash(rel)] = string(b)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExportBlobs() = %v, want %v", got, want)
+	}
+
+	// マスクされたキーのファイル名は重複するので出力しない
+	masking := MaskingConfig{Rules: []MaskRule{{Table: "public.photos", Column: "id", Strategy: MaskRedact}}}
+	changedData["public.other"] = []*RowObject{row(DiffStatusAdd, false, "5", []byte("other"), nil)}
+	tablePks["public.other"] = []string{"id"}
+	maskedData, err := MaskChanges(changedData, masking)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, skippedTables, err = ExportBlobs(dir, maskedData, tablePks, masking)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"public.photos"}; !reflect.DeepEqual(skippedTables, want) {
+		t.Errorf("ExportBlobs() skippedTables = %v, want %v", skippedTables, want)
+	}
+	if len(files) != 1 || filepath.Base(files[0]) != "id=5.image.inserted.bin" {
+		t.Errorf("ExportBlobs() = %v, want only the file of public.other", files)
+	}
+}
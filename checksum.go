@@ -0,0 +1,160 @@
+package dbdiff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Row count and aggregate checksum of the table computed by the database, like "123:5d41402abc4b2a76b9719d911017c592"
+func getTableChecksum(ctx context.Context, db DbHolder, config *Configuration, tableName string) (string, error) {
+	table := config.Db.Schema + tableName
+	if config.tableFilter(tableName) != "" {
+		// CHECKSUM TABLEは条件を指定できない
+		columns, err := GetColumnNames(ctx, db, tableName, config.Db.Schema)
+		if err != nil {
+			return "", err
+		}
+		return getRangeChecksum(ctx, db, config, tableName, columns, "")
+	}
+	ctx, cancel := queryContext(ctx, config)
+	defer cancel()
+	var count int64
+	var checksum sql.NullString
+	var err error
+	switch config.Db.DbType {
+	case "postgresql":
+		// 行の順序に依存しないよう各行のmd5でソートして連結する
+		err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*), md5(string_agg(md5(t::text), '' ORDER BY md5(t::text))) FROM %s t", table)).Scan(&count, &checksum)
+	case "mysql":
+		var name string
+		if err = db.QueryRowContext(ctx, "CHECKSUM TABLE "+table).Scan(&name, &checksum); err == nil {
+			err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&count)
+		}
+	case "mssql":
+		var hashedColumns []string
+		if hashedColumns, err = getMssqlHashedColumns(ctx, db, config, tableName); err == nil {
+			err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT_BIG(*), %s FROM %s", mssqlChecksumExpression(hashedColumns), table)).Scan(&count, &checksum)
+		}
+	default:
+		err = &ErrUnsupportedDialect{DbType: config.Db.DbType}
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%s", count, checksum.String), nil
+}
+
+// Reuse the rows of previous if the checksum of the table is not changed. Returns false if the table must be fetched.
+//
+// If the checksum can not be computed(e.g. unsupported column types), the table is always fetched.
+func (ats *AllTableStore) reuseUnchangedTable(ctx context.Context, db DbHolder, config *Configuration, tableName string, previous *AllTableStore) bool {
+	checksum, err := getTableChecksum(ctx, db, config, tableName)
+	if err != nil {
+		return false
+	}
+	ats.checksums[tableName] = checksum
+
+	if previous == nil {
+		return false
+	}
+	previousChecksum, ok := previous.checksums[tableName]
+	previousRows, ok2 := previous.AllData[tableName]
+	if !ok || !ok2 || previousChecksum != checksum {
+		return false
+	}
+
+	tableRows := make(map[string]*RowObject, len(previousRows))
+	for key, row := range previousRows {
+		tableRows[key] = row.copyForCollection()
+	}
+	ats.AllColumn[tableName] = previous.AllColumn[tableName]
+	ats.AllData[tableName] = tableRows
+	ats.TotalDataCount += uint64(len(tableRows))
+	if hwm, ok := previous.highWaterMarks[tableName]; ok {
+		ats.highWaterMarks[tableName] = hwm
+	}
+	ats.SkippedTableCount++
+	return true
+}
+
+// Row count and aggregate checksum of the rows matching where, comparable between two databases of the same dbtype.
+//
+// Unlike getTableChecksum(), MySQL uses BIT_XOR(CRC32()) of the columns because CHECKSUM TABLE can not be restricted.
+func getRangeChecksum(ctx context.Context, db DbHolder, config *Configuration, tableName string, columns []string, where string, args ...interface{}) (string, error) {
+	ctx, cancel := queryContext(ctx, config)
+	defer cancel()
+	table := config.Db.Schema + tableName
+	// サンプリングは行の取得時のみ適用する
+	where = whereClause(config.tableFilter(tableName), where)
+	var count int64
+	var checksum sql.NullString
+	var err error
+	switch config.Db.DbType {
+	case "postgresql":
+		err = db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*), md5(string_agg(md5(t::text), '' ORDER BY md5(t::text))) FROM %s t%s", table, where), args...).Scan(&count, &checksum)
+	case "mysql":
+		var values []string
+		for _, col := range columns {
+			values = append(values, fmt.Sprintf("IFNULL(%s, '<NULL>')", col))
+		}
+		query := fmt.Sprintf("SELECT COUNT(*), CAST(BIT_XOR(CRC32(CONCAT_WS('|', %s))) AS CHAR) FROM %s%s", strings.Join(values, ", "), table, where)
+		err = db.QueryRowContext(ctx, query, args...).Scan(&count, &checksum)
+	case "mssql":
+		var hashedColumns []string
+		if hashedColumns, err = getMssqlHashedColumns(ctx, db, config, tableName); err == nil {
+			query := fmt.Sprintf("SELECT COUNT_BIG(*), %s FROM %s%s", mssqlChecksumExpression(hashedColumns), table, where)
+			err = db.QueryRowContext(ctx, query, args...).Scan(&count, &checksum)
+		}
+	default:
+		err = &ErrUnsupportedDialect{DbType: config.Db.DbType}
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%s", count, checksum.String), nil
+}
+
+// Types of SQL Server ignored by BINARY_CHECKSUM(*)
+var mssqlNonComparableTypes = []string{"text", "ntext", "image", "xml", "geometry", "geography"}
+
+// Columns of the table whose types are ignored by BINARY_CHECKSUM(*), to be hashed explicitly
+func getMssqlHashedColumns(ctx context.Context, db DbHolder, config *Configuration, tableName string) ([]string, error) {
+	query := fmt.Sprintf("SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_NAME = @p1 AND DATA_TYPE IN ('%s')",
+		strings.Join(mssqlNonComparableTypes, "', '"))
+	args := []interface{}{tableName}
+	if schema := strings.TrimSuffix(config.Db.Schema, "."); schema != "" {
+		query += " AND TABLE_SCHEMA = @p2"
+		args = append(args, schema)
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY ORDINAL_POSITION", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return columns, rows.Err()
+}
+
+// Aggregate checksum of SQL Server. Columns ignored by BINARY_CHECKSUM(*) are added by their SHA-256 hashes,
+// so that a change only in those columns changes the checksum.
+func mssqlChecksumExpression(hashedColumns []string) string {
+	expression := "BINARY_CHECKSUM(*)"
+	if len(hashedColumns) > 0 {
+		var hashes []string
+		for _, col := range hashedColumns {
+			hashes = append(hashes, fmt.Sprintf("HASHBYTES('SHA2_256', CAST(%s AS varbinary(max)))", col))
+		}
+		expression += " ^ BINARY_CHECKSUM(" + strings.Join(hashes, ", ") + ")"
+	}
+	return "CAST(CHECKSUM_AGG(" + expression + ") AS VARCHAR(20))"
+}
@@ -0,0 +1,23 @@
+package dbdiff
+
+import "testing"
+
+func Test_mssqlChecksumExpression(t *testing.T) {
+	tests := []struct {
+		name          string
+		hashedColumns []string
+		want          string
+	}{
+		{"NoHashedColumns", nil, "CAST(CHECKSUM_AGG(BINARY_CHECKSUM(*)) AS VARCHAR(20))"},
+		{"HashedColumns", []string{"note", "settings"},
+			"CAST(CHECKSUM_AGG(BINARY_CHECKSUM(*) ^ BINARY_CHECKSUM(" +
+				"HASHBYTES('SHA2_256', CAST(note AS varbinary(max))), HASHBYTES('SHA2_256', CAST(settings AS varbinary(max))))) AS VARCHAR(20))"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mssqlChecksumExpression(tt.hashedColumns); got != tt.want {
+				t.Errorf("mssqlChecksumExpression() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
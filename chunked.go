@@ -0,0 +1,129 @@
+package dbdiff
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultChunkRetries = 3
+	chunkRetryInterval  = time.Second
+)
+
+// Read all rows of the table one by one.
+//
+// With ChunkSize configured, the table is read in keyset-paginated chunks instead of a single long-running query.
+// Tables without a primary key are read by a single query. Snapshot.QueryTimeout is applied to each chunk.
+func scanTable(ctx context.Context, db DbHolder, config *Configuration, tableName string, pkColumns []string, handler func(rowObject *RowObject) error) ([]string, error) {
+	scanAll := func() ([]string, error) {
+		queryCtx, cancel := queryContext(ctx, config)
+		defer cancel()
+		return scanTableRows(queryCtx, db, config, tableQuery(config, tableName, pkColumns, ""), nil, handler)
+	}
+	chunkSize := config.Snapshot.ChunkSize
+	if chunkSize <= 0 || len(pkColumns) == 0 || config.isSampled(tableName) {
+		return scanAll()
+	}
+
+	columns, err := GetColumnNames(ctx, db, tableName, config.Db.Schema)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkColumns) >= len(columns) {
+		// PKがない(全カラムをPKとみなしている)テーブルはNULLや重複があり得るのでページングできない
+		return scanAll()
+	}
+	pkIndexes := keyColumnIndexes(columns, pkColumns)
+
+	retries := config.Snapshot.ChunkRetries
+	if retries == 0 {
+		retries = DefaultChunkRetries
+	}
+
+	var lastKey []interface{}
+	for {
+		query, args := chunkQuery(config, tableName, pkColumns, lastKey, chunkSize)
+		var chunk []*RowObject
+		for attempt := 0; ; attempt++ {
+			chunk = nil
+			queryCtx, cancel := queryContext(ctx, config)
+			columns, err = scanRows(queryCtx, db, config, query, args, pkIndexes, func(rowObject *RowObject) error {
+				chunk = append(chunk, rowObject)
+				return nil
+			})
+			cancel()
+			if err == nil || attempt >= retries || ctx.Err() != nil {
+				break
+			}
+			select {
+			case <-time.After(chunkRetryInterval * time.Duration(attempt+1)):
+			case <-ctx.Done():
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chunk of %s: %w", tableName, err)
+		}
+
+		for _, rowObject := range chunk {
+			if err := handler(rowObject); err != nil {
+				return nil, err
+			}
+		}
+		if len(chunk) < chunkSize {
+			return columns, nil
+		}
+
+		last := chunk[len(chunk)-1]
+		lastKey = make([]interface{}, len(pkIndexes))
+		for i, index := range pkIndexes {
+			lastKey[i] = last.ColScans[index].RawValue()
+			if lastKey[i] == nil {
+				return nil, fmt.Errorf("NULL in primary key of %s", tableName)
+			}
+		}
+		for _, rowObject := range chunk {
+			for _, index := range pkIndexes {
+				rowObject.ColScans[index].raw = nil
+			}
+		}
+	}
+}
+
+// Query of the chunk following lastKey(the first chunk if nil)
+func chunkQuery(config *Configuration, tableName string, pkColumns []string, lastKey []interface{}, chunkSize int) (string, []interface{}) {
+	dbType := config.Db.DbType
+	var where string
+	var args []interface{}
+	if lastKey != nil {
+		args = lastKey
+		if dbType == "mssql" {
+			// 行値構成子が使えないので (a > ?) OR (a = ? AND b > ?) ... に展開する
+			var conditions []string
+			for i := range pkColumns {
+				var terms []string
+				for j := 0; j < i; j++ {
+					terms = append(terms, pkColumns[j]+" = "+placeholder(dbType, j+1))
+				}
+				terms = append(terms, pkColumns[i]+" > "+placeholder(dbType, i+1))
+				conditions = append(conditions, "("+strings.Join(terms, " AND ")+")")
+			}
+			where = strings.Join(conditions, " OR ")
+		} else {
+			var placeholders []string
+			for i := range pkColumns {
+				placeholders = append(placeholders, placeholder(dbType, i+1))
+			}
+			where = "(" + strings.Join(pkColumns, ",") + ") > (" + strings.Join(placeholders, ",") + ")"
+		}
+	}
+
+	query := tableQuery(config, tableName, pkColumns, where)
+	if dbType == "mssql" {
+		query += fmt.Sprintf(" OFFSET 0 ROWS FETCH NEXT %d ROWS ONLY", chunkSize)
+	} else {
+		query += fmt.Sprintf(" LIMIT %d", chunkSize)
+	}
+	return query, args
+}
@@ -0,0 +1,35 @@
+package dbdiff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_chunkQuery(t *testing.T) {
+	pkColumns := []string{"a", "b"}
+	lastKey := []interface{}{int64(1), "x"}
+	tests := []struct {
+		name      string
+		dbType    string
+		lastKey   []interface{}
+		wantQuery string
+		wantArgs  []interface{}
+	}{
+		{"First", "postgresql", nil, "SELECT * FROM s.t ORDER BY a,b LIMIT 100", nil},
+		{"PostgreSQL", "postgresql", lastKey, "SELECT * FROM s.t WHERE (a,b) > ($1,$2) ORDER BY a,b LIMIT 100", lastKey},
+		{"MySQL", "mysql", lastKey, "SELECT * FROM s.t WHERE (a,b) > (?,?) ORDER BY a,b LIMIT 100", lastKey},
+		{"SQLServer", "mssql", lastKey, "SELECT * FROM s.t WHERE (a > @p1) OR (a = @p1 AND b > @p2) ORDER BY a,b OFFSET 0 ROWS FETCH NEXT 100 ROWS ONLY", lastKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Configuration{Db: Db{DbType: tt.dbType, Schema: "s."}}
+			gotQuery, gotArgs := chunkQuery(config, "t", pkColumns, tt.lastKey, 100)
+			if gotQuery != tt.wantQuery {
+				t.Errorf("chunkQuery() query = %v, want %v", gotQuery, tt.wantQuery)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("chunkQuery() args = %v, want %v", gotArgs, tt.wantArgs)
+			}
+		})
+	}
+}
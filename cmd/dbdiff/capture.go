@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jparound30/dbdiff"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// dbdiff capture [options]
+//
+// Capture changes with temporary audit triggers instead of full table snapshots.
+func runCapture(args []string) {
+	flagSet := flag.NewFlagSet(os.Args[0]+" capture", flag.ExitOnError)
+
+	configOptions := registerConfigFlags(flagSet)
+	var tables string
+	flagSet.StringVar(&tables, "tables", "", "Comma separated table names to capture. All tables if empty.")
+	outputOptions := registerOutputFlags(flagSet)
+
+	_ = flagSet.Parse(args)
+
+	configuration := configOptions.load()
+	printConnection(&configuration.Db)
+	db, err := dbdiff.GetDBInstance(&configuration.Db)
+	if err != nil {
+		log.Fatalf("DB instance initialization failed. : %v", err)
+	}
+	defer db.Finalize()
+
+	ctx := interruptContext()
+	tablePks := collectTableInformation(ctx, db, configuration)
+	if tables != "" {
+		selected := map[string][]string{}
+		for _, tableName := range strings.Split(tables, ",") {
+			tableName = strings.TrimSpace(tableName)
+			pkColumns, ok := tablePks[tableName]
+			if !ok {
+				log.Fatalf("Table not found: %s", tableName)
+			}
+			selected[tableName] = pkColumns
+		}
+		tablePks = selected
+	}
+
+	capture := dbdiff.NewTriggerCapture(db, configuration, tablePks)
+	var uninstallOnce sync.Once
+	uninstall := func() {
+		uninstallOnce.Do(func() {
+			fmt.Println("[CAPTURE] Uninstalling triggers...")
+			if err := capture.Uninstall(); err != nil {
+				log.Printf("Failed to uninstall triggers. Please drop triggers(%s*) and table(%s) manually. : %v\n",
+					dbdiff.TriggerNamePrefix, dbdiff.ChangeLogTableName, err)
+			}
+		})
+	}
+
+	// エラーや中断で終了する場合もトリガーを削除する
+	defer onExit(uninstall)()
+
+	fmt.Printf("[CAPTURE] Installing triggers on %d tables...", len(tablePks))
+	err = capture.Install(ctx)
+	checkErr(err)
+	fmt.Println(" COMPLETE!")
+	defer uninstall()
+
+	promptLoop(func() {
+		fmt.Print("\n[CAPTURE] Collecting changes...")
+		before, after, err := capture.Collect(ctx)
+		checkErr(err)
+		extractChangedData := after.ExtractChangedData(before)
+		// 更新前後の行は1件と数える
+		changeCount := 0
+		for _, rows := range extractChangedData {
+			changeCount += len(dbdiff.GroupChanges(rows))
+		}
+		fmt.Printf(", Changed record count: %d ...", changeCount)
+		fmt.Println("COMPLETE!")
+
+		// トリガーで取得した変更には抽出条件を適用しない
+		outputResult(extractChangedData, tablePks, nil, configuration, outputOptions)
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jparound30/dbdiff"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dbdiff compare [options]
+//
+// Compare two databases by range bisection.
+func runCompare(args []string) {
+	flagSet := flag.NewFlagSet(os.Args[0]+" compare", flag.ExitOnError)
+
+	source := &configOptions{}
+	flagSet.StringVar(&source.configFilePath, "conf", DefaultConfigurationYaml, "Specify path of configuration file of the source database.")
+	flagSet.StringVar(&source.profile, "source", "", "Profile of the source database. Top level settings if empty.")
+	target := &configOptions{}
+	flagSet.StringVar(&target.profile, "target", "", "Profile of the target database, or path of configuration file(.yaml/.yml) of the target database.")
+	flagSet.StringVar(&target.configFilePath, "target-conf", "", "Specify path of configuration file of the target database. Same as -conf if empty.")
+	var segments int
+	flagSet.IntVar(&segments, "segments", dbdiff.DefaultBisectionSegments, "Number of segments a differing key range is split into.")
+	var minRows int
+	flagSet.IntVar(&minRows, "min-rows", dbdiff.DefaultBisectionMinRows, "Key ranges having at most this number of rows are compared row by row.")
+	outputOptions := registerOutputFlags(flagSet)
+
+	_ = flagSet.Parse(args)
+
+	if target.profile == "" && target.configFilePath == "" {
+		log.Fatal("-target or -target-conf is required.")
+	}
+	// 以前の -target <file> との互換性のため
+	if ext := strings.ToLower(filepath.Ext(target.profile)); ext == ".yaml" || ext == ".yml" {
+		target.configFilePath, target.profile = target.profile, ""
+	}
+	if target.configFilePath == "" {
+		target.configFilePath = source.configFilePath
+	}
+	sourceConfiguration := source.load()
+	targetConfiguration, err := dbdiff.LoadProfileFile(target.configFilePath, target.profile)
+	if err != nil {
+		log.Fatalf("Failed to load target configuration file. : %v", err)
+	}
+
+	printConnection(&sourceConfiguration.Db)
+	sourceDb, err := dbdiff.GetDBInstance(&sourceConfiguration.Db)
+	if err != nil {
+		log.Fatalf("DB instance initialization failed. : %v", err)
+	}
+	defer sourceDb.Finalize()
+	printConnection(&targetConfiguration.Db)
+	targetDb, err := dbdiff.OpenDB(&targetConfiguration.Db)
+	if err != nil {
+		log.Fatalf("Target DB instance initialization failed. : %v", err)
+	}
+	defer targetDb.Finalize()
+
+	ctx := interruptContext()
+	tablePks := collectTableInformation(ctx, sourceDb, sourceConfiguration)
+	targetTableNames, err := dbdiff.GetAllTables(ctx, targetDb, targetConfiguration)
+	checkErr(err)
+	targetTables := map[string]struct{}{}
+	for _, tableName := range targetTableNames {
+		targetTables[tableName] = struct{}{}
+	}
+	for tableName := range tablePks {
+		if _, ok := targetTables[tableName]; !ok {
+			fmt.Printf("[COMPARE] Table %s does not exist in the target database. Skipped.\n", tableName)
+			delete(tablePks, tableName)
+		}
+	}
+
+	fmt.Print("[COMPARE] Comparing source and target...")
+	extractChangedData, stats, err := dbdiff.BisectionDiff(ctx, sourceDb, sourceConfiguration, targetDb, targetConfiguration, tablePks,
+		dbdiff.BisectionOptions{Segments: segments, MinRows: minRows})
+	checkErr(err)
+	fmt.Printf(" Checksum queries: %d, Fetched record count: %d, Identical tables: %d ... COMPLETE!\n",
+		stats.ChecksumQueries, stats.FetchedRows, stats.SkippedTables)
+
+	outputResult(extractChangedData, tablePks, sourceConfiguration.EffectiveFilters(), sourceConfiguration, outputOptions)
+}
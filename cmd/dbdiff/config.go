@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jparound30/dbdiff"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// dbdiff config validate|init [options]
+//
+// Check a configuration file, or write an annotated configuration file.
+func runConfig(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s config validate|init [options]\n", os.Args[0])
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "validate":
+		runConfigValidate(args[1:])
+	case "init":
+		runConfigInit(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown config command: %s (validate|init)\n", args[0])
+		os.Exit(2)
+	}
+}
+
+func runConfigValidate(args []string) {
+	flagSet := flag.NewFlagSet(os.Args[0]+" config validate", flag.ExitOnError)
+	var configFilePath string
+	flagSet.StringVar(&configFilePath, "conf", DefaultConfigurationYaml, "Specify path of configuration file.")
+	_ = flagSet.Parse(args)
+
+	problems, err := dbdiff.ValidateConfigurationFile(configFilePath)
+	checkErr(err)
+	for _, problem := range problems {
+		if problem.Line == 0 {
+			fmt.Printf("%s: %s\n", configFilePath, problem.Message)
+		} else {
+			fmt.Printf("%s:%d: %s\n", configFilePath, problem.Line, problem.Message)
+		}
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%d problem(s) found.\n", len(problems))
+		os.Exit(1)
+	}
+	fmt.Printf("%s: OK\n", configFilePath)
+}
+
+func runConfigInit(args []string) {
+	flagSet := flag.NewFlagSet(os.Args[0]+" config init", flag.ExitOnError)
+	var dbType, outputFileName string
+	var force bool
+	flagSet.StringVar(&dbType, "type", "postgresql", "Database type(postgresql|mysql|mssql).")
+	flagSet.StringVar(&outputFileName, "o", DefaultConfigurationYaml, "Filename of the configuration file. Standard output if \"-\".")
+	flagSet.BoolVar(&force, "force", false, "Overwrite the file if it exists.")
+	_ = flagSet.Parse(args)
+
+	template, err := dbdiff.ConfigurationTemplate(dbType)
+	checkErr(err)
+	if outputFileName == "-" {
+		fmt.Print(template)
+		return
+	}
+	if _, err := os.Stat(outputFileName); err == nil && !force {
+		log.Fatalf("%s already exists. Use -force to overwrite it.", outputFileName)
+	}
+	checkErr(ioutil.WriteFile(outputFileName, []byte(template), 0644))
+	fmt.Printf("%s is created. Edit db settings and check it by \"%s config validate -conf %s\".\n", outputFileName, os.Args[0], outputFileName)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Time to wait for running queries to be canceled after Ctrl-C
+const interruptGracePeriod = 5 * time.Second
+
+var (
+	cleanupLock sync.Mutex
+	cleanups    = map[int]func(){}
+	cleanupSeq  int
+
+	interrupted  int32 // 1 after Ctrl-C
+	waitingInput int32 // 1 while waiting for the user's input in promptLoop()
+)
+
+// Register fn(e.g. removing temporary files) to be called when the tool exits by an error or Ctrl-C.
+// Returned function unregisters fn.
+func onExit(fn func()) (remove func()) {
+	cleanupLock.Lock()
+	defer cleanupLock.Unlock()
+	cleanupSeq++
+	id := cleanupSeq
+	cleanups[id] = fn
+	return func() {
+		cleanupLock.Lock()
+		defer cleanupLock.Unlock()
+		delete(cleanups, id)
+	}
+}
+
+// Call the registered functions in reverse order and exit
+func exitWithCleanup(code int) {
+	// os.Exit()するまでロックは解放しない(他のgoroutineからの終了処理は待たせる)
+	cleanupLock.Lock()
+	for id := cleanupSeq; id > 0; id-- {
+		if fn, ok := cleanups[id]; ok {
+			fn()
+		}
+	}
+	os.Exit(code)
+}
+
+func exitInterrupted() {
+	fmt.Fprintln(os.Stderr, "[INTERRUPTED] Aborted by user.")
+	exitWithCleanup(130)
+}
+
+// Context canceled on Ctrl-C(SIGINT) or SIGTERM.
+//
+// Running queries are canceled, then the tool exits after the registered cleanups(see onExit()). If the queries are not
+// canceled within interruptGracePeriod or Ctrl-C is pressed again, the tool exits without waiting for them.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 2)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		atomic.StoreInt32(&interrupted, 1)
+		cancel()
+		if atomic.LoadInt32(&waitingInput) == 1 {
+			// 入力待ちなので実行中のクエリはない
+			fmt.Fprintln(os.Stderr)
+			exitInterrupted()
+		}
+		fmt.Fprintln(os.Stderr, "\n[INTERRUPTED] Cancelling running queries... (press Ctrl-C again to quit immediately)")
+		select {
+		case <-interrupt:
+		case <-time.After(interruptGracePeriod):
+		}
+		exitInterrupted()
+	}()
+	return ctx
+}
+
+// Exit if Ctrl-C has been pressed
+func exitIfInterrupted() {
+	if atomic.LoadInt32(&interrupted) == 1 {
+		exitInterrupted()
+	}
+}
+
+// Write the file via a temporary file in the same directory, so that an error or Ctrl-C does not leave a partially
+// written file.
+func writeFileAtomically(fileName string, write func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	tempName := f.Name()
+	removeTemp := onExit(func() {
+		f.Close()
+		os.Remove(tempName)
+	})
+	defer removeTemp()
+
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempName, fileName)
+	}
+	if err != nil {
+		os.Remove(tempName)
+	}
+	return err
+}
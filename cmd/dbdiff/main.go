@@ -2,16 +2,21 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/360EntSecGroup-Skylar/excelize/v2"
 	"github.com/jparound30/dbdiff"
+	"io"
 	"log"
 	_ "net/http/pprof"
 	"os"
 	"os/exec"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,70 +26,91 @@ const (
 )
 
 func main() {
+	// Sub commands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "watch":
+			runWatch(os.Args[2:])
+			return
+		case "capture":
+			runCapture(os.Args[2:])
+			return
+		case "compare":
+			runCompare(os.Args[2:])
+			return
+		case "config":
+			runConfig(os.Args[2:])
+			return
+		}
+	}
+
 	// Parse arguments
 	flag.CommandLine.Init(os.Args[0], flag.ExitOnError)
 
-	var configFilePath string
-	flag.StringVar(&configFilePath, "conf", DefaultConfigurationYaml, "Specify path of configuration file.")
-	var outputFileName string
-	flag.StringVar(&outputFileName, "o", DefaultOutputResultFilename, "Filename of result file(.xlsx).")
+	configOptions := registerConfigFlags(flag.CommandLine)
+	outputOptions := registerOutputFlags(flag.CommandLine)
+	var compact bool
+	flag.BoolVar(&compact, "compact", false, "Keep only hashes of rows for the before snapshot to reduce memory usage.")
+	var noSpill bool
+	flag.BoolVar(&noSpill, "no-spill", false, "With -compact, do not write before values to a temporary file. Before values of changed rows are not shown.")
+	var checksumPrecheck bool
+	flag.BoolVar(&checksumPrecheck, "checksum", false, "Skip fetching tables whose checksum computed by the database is not changed.")
+	var memoryBudget int64
+	flag.Int64Var(&memoryBudget, "memory-budget", 0, "Memory budget(MB) per table. Tables exceeding it are compared with sorted temporary files. Disabled if 0.")
 
 	flag.Parse()
 
-	configuration, err := dbdiff.LoadConfiguration(configFilePath)
-	if err != nil {
-		log.Fatal("Failed to load configuration file.")
+	configuration := configOptions.load()
+	if checksumPrecheck {
+		configuration.Snapshot.ChecksumPrecheck = true
 	}
+	printConnection(&configuration.Db)
 	db, err := dbdiff.GetDBInstance(&configuration.Db)
 	if err != nil {
-		log.Fatal("DB instance initialization failed.")
+		log.Fatalf("DB instance initialization failed. : %v", err)
 	}
 	defer db.Finalize()
 
-	fmt.Println("[INITIALIZING] Collecting Table Information ...")
-	tableNames, err := dbdiff.GetAllTables(db, configuration)
-	checkErr(err)
-	fmt.Printf("Table count: %d\n", len(tableNames))
+	ctx := interruptContext()
+	tablePks := collectTableInformation(ctx, db, configuration)
 
-	tablePks, err := dbdiff.GetPksOfTables(db, configuration, tableNames)
-	checkErr(err)
-	//for key, value := range tablePks {
-	//	fmt.Printf("TABLE:%s, PK_COLUMN:%s\n", key, value)
-	//}
+	if memoryBudget > 0 {
+		runSpillingSnapshots(ctx, db, configuration, tablePks, outputOptions,
+			dbdiff.SpillOptions{MemoryBudget: memoryBudget * 1024 * 1024})
+		return
+	}
+	if compact {
+		runCompactSnapshots(ctx, db, configuration, tablePks, outputOptions,
+			dbdiff.CompactOptions{Spill: !noSpill, ColumnHashes: noSpill})
+		return
+	}
 
 	fmt.Print("[BEFORE] Collecting snapshot data...")
 	before := dbdiff.AllTableStore{}
-	err = before.CollectAllTableData(db, configuration, tablePks)
+	err = before.CollectAllTableData(ctx, db, configuration, tablePks)
 	checkErr(err)
 	fmt.Printf(", Total record count: %d ...", before.TotalDataCount)
 	fmt.Println(" COMPLETE!")
-
-	stdin := bufio.NewScanner(os.Stdin)
+	printFailedTables(&before)
 
 	printMemStat()
-	fmt.Printf("OK, Let's do some operations, THEN HIT ANY KEY! OR type 'q' or 'exit' to quit this tool.  ")
-	for stdin.Scan() {
-		input := stdin.Text()
-		if input == "q" || input == "exit" {
-			break
-		}
-
+	promptLoop(func() {
 		fmt.Print("\n[AFTER ] Collecting snapshot data...")
 		after := dbdiff.AllTableStore{}
-		err = after.CollectAllTableData(db, configuration, tablePks)
+		err = after.CollectIncrementalTableData(ctx, db, configuration, tablePks, &before)
 		checkErr(err)
-		fmt.Printf(", Total record count: %d ...", after.TotalDataCount)
+		fmt.Printf(", Total record count: %d (fetched: %d, skipped tables: %d) ...", after.TotalDataCount, after.FetchedDataCount, after.SkippedTableCount)
 		fmt.Println("COMPLETE!")
+		printFailedTables(&after)
 
 		extractChangedData := after.ExtractChangedData(&before)
-		outputResultToExcelFile(extractChangedData, outputFileName)
+		outputResult(extractChangedData, tablePks, configuration.EffectiveFilters(), configuration, outputOptions)
 
 		// swap
 		before = after
 
 		printMemStat()
-		fmt.Printf("OK, Let's do some operations, THEN HIT ANY KEY! OR type 'q' or 'exit' to quit this tool.  ")
-	}
+	})
 
 	// TODO プロファイル用 そのうち削除
 	//var wg sync.WaitGroup
@@ -97,6 +123,230 @@ func main() {
 	//wg.Wait()
 }
 
+// Repeat fn each time the user hits enter, until 'q' or 'exit' is typed.
+func promptLoop(fn func()) {
+	stdin := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Printf("OK, Let's do some operations, THEN HIT ANY KEY! OR type 'q' or 'exit' to quit this tool.  ")
+		atomic.StoreInt32(&waitingInput, 1)
+		exitIfInterrupted()
+		ok := stdin.Scan()
+		atomic.StoreInt32(&waitingInput, 0)
+		exitIfInterrupted()
+		if !ok {
+			break
+		}
+		input := stdin.Text()
+		if input == "q" || input == "exit" {
+			break
+		}
+		fn()
+	}
+}
+
+// Interactive loop with CompactTableStore
+func runCompactSnapshots(ctx context.Context, db dbdiff.DbHolder, configuration *dbdiff.Configuration, tablePks map[string][]string, outputOptions *outputOptions, compactOptions dbdiff.CompactOptions) {
+	fmt.Print("[BEFORE] Collecting compact snapshot data...")
+	before := dbdiff.NewCompactTableStore(compactOptions)
+	// 中断時に一時ファイルを削除する
+	defer onExit(func() { before.Close() })()
+	err := before.Collect(ctx, db, configuration, tablePks)
+	if err != nil {
+		before.Close()
+		checkErr(err)
+	}
+	fmt.Printf(", Total record count: %d ...", before.TotalDataCount)
+	fmt.Println(" COMPLETE!")
+	printCompactMemoryEstimate(before)
+
+	promptLoop(func() {
+		fmt.Print("\n[AFTER ] Collecting compact snapshot data...")
+		extractChangedData, after, err := before.CollectAndCompare(ctx, db, configuration, tablePks)
+		if err != nil {
+			before.Close()
+			checkErr(err)
+		}
+		fmt.Printf(", Total record count: %d ...", after.TotalDataCount)
+		fmt.Println("COMPLETE!")
+
+		outputResult(extractChangedData, tablePks, configuration.EffectiveFilters(), configuration, outputOptions)
+
+		// swap
+		before.Close()
+		before = after
+
+		printCompactMemoryEstimate(before)
+	})
+	before.Close()
+}
+
+// Interactive loop with SpillingTableStore
+func runSpillingSnapshots(ctx context.Context, db dbdiff.DbHolder, configuration *dbdiff.Configuration, tablePks map[string][]string, outputOptions *outputOptions, spillOptions dbdiff.SpillOptions) {
+	fmt.Print("[BEFORE] Collecting snapshot data...")
+	before := dbdiff.NewSpillingTableStore(spillOptions)
+	// 中断時に一時ファイルを削除する
+	defer onExit(func() { before.Close() })()
+	err := before.Collect(ctx, db, configuration, tablePks)
+	if err != nil {
+		before.Close()
+		checkErr(err)
+	}
+	fmt.Printf(", Total record count: %d, Spilled table count: %d ...", before.TotalDataCount, before.SpilledTableCount())
+	fmt.Println(" COMPLETE!")
+	printMemStat()
+
+	promptLoop(func() {
+		fmt.Print("\n[AFTER ] Collecting snapshot data...")
+		after := dbdiff.NewSpillingTableStore(spillOptions)
+		removeCleanup := onExit(func() { after.Close() })
+		defer removeCleanup()
+		err := after.Collect(ctx, db, configuration, tablePks)
+		if err == nil {
+			fmt.Printf(", Total record count: %d, Spilled table count: %d ...", after.TotalDataCount, after.SpilledTableCount())
+			fmt.Println("COMPLETE!")
+		}
+		var extractChangedData map[string][]*dbdiff.RowObject
+		if err == nil {
+			extractChangedData, err = after.ExtractChangedData(before)
+		}
+		if err != nil {
+			before.Close()
+			after.Close()
+			checkErr(err)
+		}
+
+		outputResult(extractChangedData, tablePks, configuration.EffectiveFilters(), configuration, outputOptions)
+
+		// swap
+		before.Close()
+		before = after
+
+		printMemStat()
+	})
+	before.Close()
+}
+
+// Print tables failed to collect with continue_on_error
+func printFailedTables(ats *dbdiff.AllTableStore) {
+	for _, err := range ats.FailedTables {
+		fmt.Printf("[WARN] Failed to collect table, not compared: %v\n", err)
+	}
+}
+
+func printCompactMemoryEstimate(cts *dbdiff.CompactTableStore) {
+	compactSize, fullSize := cts.MemoryEstimate()
+	const megas = float64(1024 * 1024)
+	fmt.Printf("Snapshot size(estimated): %.3f MB (%.3f MB without compact mode)\n",
+		float64(compactSize)/megas, float64(fullSize)/megas)
+	printMemStat()
+}
+
+// Configuration file and profile to load
+type configOptions struct {
+	configFilePath string
+	profile        string
+}
+
+func registerConfigFlags(flagSet *flag.FlagSet) *configOptions {
+	options := &configOptions{}
+	flagSet.StringVar(&options.configFilePath, "conf", DefaultConfigurationYaml, "Specify path of configuration file.")
+	flagSet.StringVar(&options.profile, "profile", "", "Name of the profile in the configuration file. Top level settings if empty.")
+	return options
+}
+
+// Load the configuration. Exit if failed.
+func (options *configOptions) load() *dbdiff.Configuration {
+	configuration, err := dbdiff.LoadProfile(options.configFilePath, options.profile)
+	if err != nil {
+		log.Fatalf("Failed to load configuration file. : %v", err)
+	}
+	return configuration
+}
+
+// Options of result output
+type outputOptions struct {
+	outputFileName        string
+	markdownFileName      string
+	markdownMaxSize       int
+	markdownMaxCellLength int
+	verbose               bool
+	colorMode             string
+}
+
+func registerOutputFlags(flagSet *flag.FlagSet) *outputOptions {
+	options := &outputOptions{}
+	flagSet.StringVar(&options.outputFileName, "o", DefaultOutputResultFilename, "Filename of result file(.xlsx).")
+	flagSet.StringVar(&options.markdownFileName, "md", "", "Filename of Markdown summary file(.md) for pull request comments. Not generated if empty.")
+	flagSet.IntVar(&options.markdownMaxSize, "md-max-size", dbdiff.DefaultMarkdownMaxSize, "Max size(bytes) of Markdown summary. Exceeded rows are omitted.")
+	flagSet.IntVar(&options.markdownMaxCellLength, "md-max-cell", dbdiff.DefaultMarkdownMaxCellLength, "Max length of a cell value in Markdown summary. Longer values are truncated.")
+	flagSet.BoolVar(&options.verbose, "v", false, "Show all columns of changed rows on console.")
+	flagSet.StringVar(&options.colorMode, "color", "auto", "Colorize console output. (auto|always|never)")
+	return options
+}
+
+// Output result to console, Excel file and Markdown file
+//
+// filters are the effective filters of the tables(see Configuration#EffectiveFilters()).
+// Rows whose key is changed are detected and values are masked by configuration before any output
+// (see Configuration.KeyChanges, Configuration#EffectiveMasking()).
+// Binary values of the changed rows are exported if Snapshot.BlobExportDir is set.
+func outputResult(extractChangedData map[string][]*dbdiff.RowObject, tablePks map[string][]string, filters map[string]string, configuration *dbdiff.Configuration, options *outputOptions) {
+	extractChangedData, err := dbdiff.DetectKeyChanges(extractChangedData, tablePks, configuration)
+	checkErr(err)
+	extractChangedData, err = dbdiff.MaskChanges(extractChangedData, configuration.EffectiveMasking())
+	checkErr(err)
+	terminalOptions := dbdiff.TerminalOptions{Verbose: options.verbose, Color: useColor(options.colorMode), Filters: filters}
+	err = dbdiff.WriteTerminal(os.Stdout, extractChangedData, tablePks, terminalOptions)
+	checkErr(err)
+	if blobExportDir := configuration.Snapshot.BlobExportDir; blobExportDir != "" {
+		files, skippedTables, err := dbdiff.ExportBlobs(blobExportDir, extractChangedData, tablePks, configuration.EffectiveMasking())
+		checkErr(err)
+		for _, tableName := range skippedTables {
+			log.Printf("Warning: binary values of %s are not exported, because its primary key is masked\n", tableName)
+		}
+		if len(files) > 0 {
+			fmt.Printf("[ResultOutput] %d binary value(s) exported to %s\n", len(files), blobExportDir)
+		}
+	}
+	outputResultToExcelFile(extractChangedData, filters, options.outputFileName)
+	if options.markdownFileName != "" {
+		outputResultToMarkdownFile(extractChangedData, tablePks, options.markdownFileName,
+			dbdiff.MarkdownOptions{MaxSize: options.markdownMaxSize, MaxCellLength: options.markdownMaxCellLength, Filters: filters})
+	}
+}
+
+// Whether to colorize console output. mode is one of auto|always|never.
+func useColor(mode string) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	default:
+		return dbdiff.IsColorTerminal(os.Stdout)
+	}
+}
+
+// Collect table names and their primary keys
+func collectTableInformation(ctx context.Context, db dbdiff.DbHolder, configuration *dbdiff.Configuration) map[string][]string {
+	fmt.Println("[INITIALIZING] Collecting Table Information ...")
+	tableNames, err := dbdiff.GetAllTables(ctx, db, configuration)
+	checkErr(err)
+	fmt.Printf("Table count: %d\n", len(tableNames))
+
+	tablePks, err := dbdiff.GetPksOfTables(ctx, db, configuration, tableNames)
+	checkErr(err)
+	err = dbdiff.ResolveTableFilters(ctx, db, configuration, tablePks)
+	checkErr(err)
+	for tableName, filter := range configuration.EffectiveFilters() {
+		fmt.Printf("Filter of %s: %s\n", tableName, filter)
+	}
+	//for key, value := range tablePks {
+	//	fmt.Printf("TABLE:%s, PK_COLUMN:%s\n", key, value)
+	//}
+	return tablePks
+}
+
 const (
 	DiffResultOffsetForColumn = 2 // "B"
 	DiffResultOffsetForRow    = 2 // "2"
@@ -105,7 +355,7 @@ const (
 	SheetName = "Sheet1"
 )
 
-func outputResultToExcelFile(extractChangedData map[string][]*dbdiff.RowObject, outputFileName string) {
+func outputResultToExcelFile(extractChangedData map[string][]*dbdiff.RowObject, filters map[string]string, outputFileName string) {
 	// TODO Excel出力　要refactoring
 	var err error
 	xlsx := excelize.NewFile()
@@ -161,7 +411,6 @@ func outputResultToExcelFile(extractChangedData map[string][]*dbdiff.RowObject,
 			// table no differences
 			continue
 		}
-		fmt.Println("===" + tableName + "===")
 
 		///////
 		// Table name
@@ -179,6 +428,18 @@ func outputResultToExcelFile(extractChangedData map[string][]*dbdiff.RowObject,
 		err = xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), tableName)
 		checkErr(err)
 
+		if filter := filters[tableName]; filter != "" {
+			// 抽出条件出力
+			ci++
+			err = xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), "Filter")
+			checkErr(err)
+			err = xlsx.SetCellStyle(SheetName, rowColIndexToAlpha(ri, ci), rowColIndexToAlpha(ri, ci), tableNameCellStyle)
+			checkErr(err)
+			ci++
+			err = xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), filter)
+			checkErr(err)
+		}
+
 		///////
 		// Header ( Column names )
 		///////
@@ -194,6 +455,18 @@ func outputResultToExcelFile(extractChangedData map[string][]*dbdiff.RowObject,
 			xlsx.SetCellStyle(SheetName, rowColIndexToAlpha(ri, ci), rowColIndexToAlpha(ri, ci), headerCellStyle)
 			ci++
 		}
+		// JSON、XMLの変更されたパスは最後の列に出す
+		hasPathChanges := false
+		for _, v := range value {
+			if len(v.ModifiedPaths) > 0 {
+				hasPathChanges = true
+				break
+			}
+		}
+		if hasPathChanges {
+			xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), "(changed paths)")
+			xlsx.SetCellStyle(SheetName, rowColIndexToAlpha(ri, ci), rowColIndexToAlpha(ri, ci), headerCellStyle)
+		}
 
 		ri++
 		ci = DiffResultOffsetForColumn
@@ -201,35 +474,27 @@ func outputResultToExcelFile(extractChangedData map[string][]*dbdiff.RowObject,
 		for _, v := range value {
 			switch v.DiffStatus {
 			case dbdiff.DiffStatusAdd:
-				fmt.Printf("INSERTED        : %s\n", v)
 				ci = DiffResultOffsetForColumn
 				xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), "INSERTED")
 				xlsx.SetCellStyle(SheetName, rowColIndexToAlpha(ri, ci), rowColIndexToAlpha(ri, ci), unmodCellStyle)
 				for _, col := range v.ColScans {
 					ci++
-					xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), col.GetValueString())
+					xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), excelCellValue(col))
 					xlsx.SetCellStyle(SheetName, rowColIndexToAlpha(ri, ci), rowColIndexToAlpha(ri, ci), unmodCellStyle)
 				}
 			case dbdiff.DiffStatusDel:
-				fmt.Printf("DELETED         : %s\n", v)
 				ci = DiffResultOffsetForColumn
 				xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), "DELETED")
 				xlsx.SetCellStyle(SheetName, rowColIndexToAlpha(ri, ci), rowColIndexToAlpha(ri, ci), unmodCellStyle)
 
 				for _, col := range v.ColScans {
 					ci++
-					xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), col.GetValueString())
+					xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), excelCellValue(col))
 					xlsx.SetCellStyle(SheetName, rowColIndexToAlpha(ri, ci), rowColIndexToAlpha(ri, ci), unmodCellStyle)
 				}
-			case dbdiff.DiffStatusMod:
+			case dbdiff.DiffStatusMod, dbdiff.DiffStatusKeyChanged:
 				ci = DiffResultOffsetForColumn
-				if v.IsBeforeData {
-					fmt.Printf("UPDATED[Before] : %s\n", v)
-					xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), "UPD BEFORE")
-				} else {
-					fmt.Printf("UPDATED[After ] : %s\n", v)
-					xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), "UPD  AFTER")
-				}
+				xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), dbdiff.DiffStatusLabel(v))
 				xlsx.SetCellStyle(SheetName, rowColIndexToAlpha(ri, ci), rowColIndexToAlpha(ri, ci), unmodCellStyle)
 
 				for colIndex, col := range v.ColScans {
@@ -240,12 +505,15 @@ func outputResultToExcelFile(extractChangedData map[string][]*dbdiff.RowObject,
 							xlsx.SetCellStyle(SheetName, rowColIndexToAlpha(ri, ci), rowColIndexToAlpha(ri, ci), modCellStyle)
 						}
 					}
-					xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), col.GetValueString())
+					xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), excelCellValue(col))
+				}
+				if hasPathChanges && !v.IsBeforeData {
+					ci++
+					xlsx.SetCellStr(SheetName, rowColIndexToAlpha(ri, ci), excelPathChanges(v))
 				}
 			case dbdiff.DiffStatusInit:
 				fallthrough
 			case dbdiff.DiffStatusNotModified:
-				fmt.Printf("DiffStatus %d\n", v.DiffStatus)
 				continue
 			}
 			ri++
@@ -254,7 +522,10 @@ func outputResultToExcelFile(extractChangedData map[string][]*dbdiff.RowObject,
 	}
 
 	xlsxFilename := generateOutFilename(outputFileName)
-	xlsx.SaveAs(xlsxFilename)
+	err = writeFileAtomically(xlsxFilename, func(w io.Writer) error {
+		return xlsx.Write(w)
+	})
+	checkErr(err)
 	fmt.Println("[ResultOutput] See " + xlsxFilename)
 
 	// EXCELファイルを表示する
@@ -269,6 +540,32 @@ func outputResultToExcelFile(extractChangedData map[string][]*dbdiff.RowObject,
 	}
 }
 
+// Max length of a cell value of Excel is 32,767 characters. Leave room for the truncation marker.
+const excelMaxCellLength = 32000
+
+func excelCellValue(col *dbdiff.ColumnScan) string {
+	return dbdiff.TruncateCellValue(col.GetValueString(), excelMaxCellLength)
+}
+
+// Changed paths of the JSON and XML columns of the row, a line for each path like "settings: $.theme: "dark" -> "light""
+func excelPathChanges(row *dbdiff.RowObject) string {
+	var lines []string
+	for index, colName := range row.ColumnNames {
+		for _, change := range row.ColumnPathChanges(index) {
+			lines = append(lines, colName+": "+change.String())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func outputResultToMarkdownFile(extractChangedData map[string][]*dbdiff.RowObject, tablePks map[string][]string, outputFileName string, opts dbdiff.MarkdownOptions) {
+	err := writeFileAtomically(outputFileName, func(w io.Writer) error {
+		return dbdiff.WriteMarkdown(w, extractChangedData, tablePks, opts)
+	})
+	checkErr(err)
+	fmt.Println("[ResultOutput] See " + outputFileName)
+}
+
 // Generate Output filename.
 func generateOutFilename(specifiedFilename string) string {
 	var xlsxFilename string
@@ -293,11 +590,26 @@ func rowColIndexToAlpha(r int, c int) string {
 	}
 }
 
+// Print the database to connect, without the password
+func printConnection(dbConfig *dbdiff.Db) {
+	if connStr := dbConfig.RedactedConnectionString(); connStr != "" {
+		fmt.Printf("Connect to ... %s\n", connStr)
+	}
+}
+
 // TODO 消したい
 func checkErr(err error) {
-	if err != nil {
-		log.Fatalf("ERROR : %v", err)
+	if err == nil {
+		return
+	}
+	// 中断によるエラーはそのまま終了する
+	exitIfInterrupted()
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("ERROR : timed out. (see query_timeout and timeout of snapshot in the configuration) : %v", err)
+	} else {
+		log.Printf("ERROR : %v", err)
 	}
+	exitWithCleanup(1)
 }
 
 func printMemStat() {
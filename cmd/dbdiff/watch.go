@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jparound30/dbdiff"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const DefaultWatchInterval = 2 * time.Second
+
+// dbdiff watch [options]
+//
+// Take snapshots periodically and print changes since the previous snapshot.
+func runWatch(args []string) {
+	flagSet := flag.NewFlagSet(os.Args[0]+" watch", flag.ExitOnError)
+
+	configOptions := registerConfigFlags(flagSet)
+	var interval time.Duration
+	flagSet.DurationVar(&interval, "interval", DefaultWatchInterval, "Interval between snapshots.")
+	var logFileName string
+	flagSet.StringVar(&logFileName, "log", "", "Append changes to this file. Not written if empty.")
+	var checksumPrecheck bool
+	flagSet.BoolVar(&checksumPrecheck, "checksum", false, "Skip fetching tables whose checksum computed by the database is not changed.")
+	var verbose bool
+	flagSet.BoolVar(&verbose, "v", false, "Show all columns of changed rows on console.")
+	var colorMode string
+	flagSet.StringVar(&colorMode, "color", "auto", "Colorize console output. (auto|always|never)")
+
+	_ = flagSet.Parse(args)
+
+	if interval <= 0 {
+		log.Fatal("-interval must be positive.")
+	}
+	terminalOptions := dbdiff.TerminalOptions{Verbose: verbose, Color: useColor(colorMode)}
+
+	var logFile io.Writer
+	if logFileName != "" {
+		f, err := os.OpenFile(logFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		checkErr(err)
+		defer f.Close()
+		logFile = f
+	}
+
+	configuration := configOptions.load()
+	if checksumPrecheck {
+		configuration.Snapshot.ChecksumPrecheck = true
+	}
+	printConnection(&configuration.Db)
+	db, err := dbdiff.GetDBInstance(&configuration.Db)
+	if err != nil {
+		log.Fatalf("DB instance initialization failed. : %v", err)
+	}
+	defer db.Finalize()
+
+	ctx := interruptContext()
+	tablePks := collectTableInformation(ctx, db, configuration)
+	terminalOptions.Filters = configuration.EffectiveFilters()
+
+	before := dbdiff.AllTableStore{}
+	err = before.CollectAllTableData(ctx, db, configuration, tablePks)
+	checkErr(err)
+	fmt.Printf("[WATCH] Total record count: %d. Watching changes every %s. Press Ctrl-C to quit.\n", before.TotalDataCount, interval)
+
+	wait := interval
+	backingOff := false
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("[WATCH] Stopped.")
+			return
+		case <-time.After(wait):
+		}
+
+		start := time.Now()
+		after := dbdiff.AllTableStore{}
+		err = after.CollectIncrementalTableData(ctx, db, configuration, tablePks, &before)
+		checkErr(err)
+		printFailedTables(&after)
+		elapsed := time.Since(start)
+
+		extractChangedData, err := dbdiff.DetectKeyChanges(after.ExtractChangedData(&before), tablePks, configuration)
+		checkErr(err)
+		if changeCount := countAllChanges(extractChangedData); changeCount > 0 {
+			extractChangedData, err = dbdiff.MaskChanges(extractChangedData, configuration.EffectiveMasking())
+			checkErr(err)
+			header := fmt.Sprintf("[%s] %d changes\n", start.Format("2006-01-02 15:04:05.000"), changeCount)
+			fmt.Print(header)
+			err = dbdiff.WriteTerminal(os.Stdout, extractChangedData, tablePks, terminalOptions)
+			checkErr(err)
+			if logFile != nil {
+				_, err = io.WriteString(logFile, header)
+				checkErr(err)
+				err = dbdiff.WriteTerminal(logFile, extractChangedData, tablePks, dbdiff.TerminalOptions{Verbose: verbose, Filters: terminalOptions.Filters})
+				checkErr(err)
+			}
+		}
+
+		// swap
+		before = after
+
+		// スナップショット取得が間隔より長い場合は、DBに負荷をかけ続けないよう間隔を広げる
+		if elapsed > interval {
+			if !backingOff {
+				fmt.Printf("[WATCH] Snapshot took %s, longer than interval %s. Backing off.\n", elapsed.Round(time.Millisecond), interval)
+			}
+			backingOff = true
+			wait = elapsed
+		} else {
+			backingOff = false
+			wait = interval - elapsed
+		}
+	}
+}
+
+// Number of changed rows of all tables
+func countAllChanges(extractChangedData map[string][]*dbdiff.RowObject) int {
+	count := 0
+	for _, rows := range extractChangedData {
+		count += dbdiff.SummarizeChanges(rows).Total()
+	}
+	return count
+}
@@ -0,0 +1,427 @@
+package dbdiff
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Estimated memory usage per object(bytes), used to report memory savings
+const (
+	rowObjectMemoryOverhead  = 96 // RowObject, its slices and the map entry
+	columnMemoryOverhead     = 56 // *ColumnScan, ColumnScan and sql.NullString
+	compactRowMemoryOverhead = 80 // compactRow and the map entry
+	columnHashMemoryOverhead = 8
+)
+
+const (
+	compactRowHashSize        = 16
+	compactRowLengthUnspilled = -1
+	compactSpillFilePrefix    = "dbdiff_spill_"
+	compactSpillWriteBufSize  = 1024 * 1024
+	compactColumnNullMark     = 0
+	compactColumnNotNullMark  = 1
+	compactColumnJSONMark     = 2 // not null value of a JSON column(see ColumnScan#structure)
+	compactColumnXMLMark      = 3 // not null value of an XML column
+)
+
+// Marks of the not null values by ColumnScan#structure
+var compactColumnStructureMarks = map[string]byte{
+	"":              compactColumnNotNullMark,
+	CompareTypeJSON: compactColumnJSONMark,
+	CompareTypeXML:  compactColumnXMLMark,
+}
+
+// Options for CompactTableStore
+type CompactOptions struct {
+	// Write full values of rows to a temporary file, so that before values of changed rows can be shown.
+	Spill bool
+	// Directory of the spill file. os.TempDir() if empty.
+	SpillDir string
+	// Keep a hash per column, so that modified columns can be detected even without the spill file.
+	ColumnHashes bool
+}
+
+type compactRow struct {
+	hash         [compactRowHashSize]byte
+	columnHashes []uint64
+	offset       int64
+	length       int64
+}
+
+// Snapshot which keeps only the key and the hash of the contents of each row.
+//
+// Use it as the before side instead of AllTableStore to reduce memory usage.
+// Changed rows are detected exactly as AllTableStore#ExtractChangedData(), but before values are available only if
+// CompactOptions.Spill is enabled. Otherwise, before values are shown as NotCapturedValue.
+type CompactTableStore struct {
+	AllColumn      map[string][]string
+	TotalDataCount uint64
+
+	rows               map[string]map[string]*compactRow
+	options            CompactOptions
+	spill              *os.File
+	spillWriter        *bufio.Writer
+	spillOffset        int64
+	fullSize           uint64
+	compactSize        uint64
+	alreadyCollectData bool
+}
+
+// Value shown for before values which are not kept in compact mode
+const NotCapturedValue = "<NOT CAPTURED>"
+
+func NewCompactTableStore(options CompactOptions) *CompactTableStore {
+	return &CompactTableStore{options: options}
+}
+
+// Without CompactOptions.Spill, rows are compared by hashes and the comparison rules can not be applied.
+// Returns an error instead of ignoring the rules silently. JSON and XML columns are hashed in their canonical forms
+// instead(see canonicalStructure()).
+func (cts *CompactTableStore) checkComparison(config *Configuration) error {
+	if !cts.options.Spill && len(config.Comparison.Rules) > 0 {
+		return errors.New("comparison rules can not be used without spilling before values in compact mode")
+	}
+	return nil
+}
+
+// Collect data of all tables. See AllTableStore#CollectAllTableData() about ctx.
+func (cts *CompactTableStore) Collect(ctx context.Context, db DbHolder, config *Configuration, tablePks map[string][]string) error {
+	if cts.alreadyCollectData {
+		return errors.New("already collected data")
+	}
+	if err := cts.checkComparison(config); err != nil {
+		return err
+	}
+	if err := cts.init(); err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, config.Snapshot.Timeout)
+	defer cancel()
+	if err := ResolveTableFilters(ctx, db, config, tablePks); err != nil {
+		return err
+	}
+	for tableName, pkColumns := range tablePks {
+		columns, err := scanTable(ctx, db, config, tableName, pkColumns, func(rowObject *RowObject) error {
+			return cts.add(tableName, rowObject.GetKey(pkColumns), rowObject)
+		})
+		if err != nil {
+			return &ErrCollectTable{Table: tableName, Err: err}
+		}
+		cts.AllColumn[tableName] = columns
+		if _, ok := cts.rows[tableName]; !ok {
+			cts.rows[tableName] = map[string]*compactRow{}
+		}
+	}
+	return cts.finish()
+}
+
+// Collect data of all tables and compare with this snapshot(as before data).
+//
+// Returns the changed data as AllTableStore#ExtractChangedData() and the new snapshot, which should be used as the
+// before data of the next comparison. The comparison rules of config require CompactOptions.Spill(see checkComparison()).
+func (cts *CompactTableStore) CollectAndCompare(ctx context.Context, db DbHolder, config *Configuration, tablePks map[string][]string) (map[string][]*RowObject, *CompactTableStore, error) {
+	if err := cts.checkComparison(config); err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := withTimeout(ctx, config.Snapshot.Timeout)
+	defer cancel()
+	if err := ResolveTableFilters(ctx, db, config, tablePks); err != nil {
+		return nil, nil, err
+	}
+	comparer, err := config.ValueComparer()
+	if err != nil {
+		return nil, nil, err
+	}
+	next := NewCompactTableStore(cts.options)
+	if err := next.init(); err != nil {
+		return nil, nil, err
+	}
+
+	var output = map[string][]*RowObject{}
+	for tableName, pkColumns := range tablePks {
+		beforeTableRows, compare := cts.rows[tableName]
+		var outputTableData []*RowObject
+		var scannedKeys = map[string]struct{}{}
+		equal := comparer.ForTable(tableName)
+
+		columns, err := scanTable(ctx, db, config, tableName, pkColumns, func(afterRowObject *RowObject) error {
+			key := afterRowObject.GetKey(pkColumns)
+			if err := next.add(tableName, key, afterRowObject); err != nil {
+				return err
+			}
+			if !compare {
+				return nil
+			}
+
+			scannedKeys[key] = struct{}{}
+			beforeRow, ok := beforeTableRows[key]
+			if !ok {
+				// 追加されたデータ
+				afterRowObject.DiffStatus = DiffStatusAdd
+				outputTableData = append(outputTableData, afterRowObject)
+				return nil
+			}
+			if beforeRow.hash == hashRow(afterRowObject, cts.structuralHashes()) {
+				return nil
+			}
+
+			// キーはあるが一致しないので変更
+			beforeRowObject, err := cts.restore(tableName, beforeRow)
+			if err != nil {
+				return err
+			}
+			if beforeRow.length == compactRowLengthUnspilled {
+				// 値を保持していない場合はカラム単位のハッシュで変更カラムを求める
+				markModifiedColumns(beforeRow, beforeRowObject, afterRowObject, cts.structuralHashes())
+			} else if beforeRowObject.EqualColumnsWith(afterRowObject, equal) {
+				// 比較ルールで一致するので変更なし
+				return nil
+			}
+			beforeRowObject.DiffStatus = DiffStatusMod
+			afterRowObject.DiffStatus = DiffStatusMod
+			outputTableData = append(outputTableData, beforeRowObject, afterRowObject)
+			return nil
+		})
+		if err != nil {
+			next.Close()
+			return nil, nil, &ErrCollectTable{Table: tableName, Err: err}
+		}
+		next.AllColumn[tableName] = columns
+		if _, ok := next.rows[tableName]; !ok {
+			next.rows[tableName] = map[string]*compactRow{}
+		}
+		if !compare {
+			continue
+		}
+
+		for key, beforeRow := range beforeTableRows {
+			if _, ok := scannedKeys[key]; ok {
+				continue
+			}
+			// afterに要素がないので削除データ
+			beforeRowObject, err := cts.restore(tableName, beforeRow)
+			if err != nil {
+				next.Close()
+				return nil, nil, err
+			}
+			beforeRowObject.DiffStatus = DiffStatusDel
+			outputTableData = append(outputTableData, beforeRowObject)
+		}
+		output[tableName] = outputTableData
+	}
+
+	if err := next.finish(); err != nil {
+		next.Close()
+		return nil, nil, err
+	}
+	return output, next, nil
+}
+
+// Estimated memory usage of this snapshot and of the same snapshot held by AllTableStore
+func (cts *CompactTableStore) MemoryEstimate() (compactSize uint64, fullSize uint64) {
+	return cts.compactSize, cts.fullSize
+}
+
+// Remove the spill file
+func (cts *CompactTableStore) Close() error {
+	if cts.spill == nil {
+		return nil
+	}
+	name := cts.spill.Name()
+	err := cts.spill.Close()
+	cts.spill = nil
+	if removeErr := os.Remove(name); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+func (cts *CompactTableStore) init() error {
+	cts.AllColumn = map[string][]string{}
+	cts.rows = map[string]map[string]*compactRow{}
+	if cts.options.Spill {
+		f, err := ioutil.TempFile(cts.options.SpillDir, compactSpillFilePrefix)
+		if err != nil {
+			return err
+		}
+		cts.spill = f
+		cts.spillWriter = bufio.NewWriterSize(f, compactSpillWriteBufSize)
+	}
+	return nil
+}
+
+func (cts *CompactTableStore) finish() error {
+	cts.alreadyCollectData = true
+	if cts.spillWriter != nil {
+		err := cts.spillWriter.Flush()
+		cts.spillWriter = nil
+		return err
+	}
+	return nil
+}
+
+func (cts *CompactTableStore) add(tableName string, key string, rowObject *RowObject) error {
+	tableRows, ok := cts.rows[tableName]
+	if !ok {
+		tableRows = map[string]*compactRow{}
+		cts.rows[tableName] = tableRows
+	}
+
+	row := &compactRow{hash: hashRow(rowObject, cts.structuralHashes()), length: compactRowLengthUnspilled}
+	if cts.options.ColumnHashes {
+		row.columnHashes = make([]uint64, len(rowObject.ColScans))
+		for i, col := range rowObject.ColScans {
+			row.columnHashes[i] = hashColumn(col, cts.structuralHashes())
+		}
+	}
+	if cts.spillWriter != nil {
+		buf := encodeRowValues(rowObject)
+		if _, err := cts.spillWriter.Write(buf); err != nil {
+			return err
+		}
+		row.offset = cts.spillOffset
+		row.length = int64(len(buf))
+		cts.spillOffset += int64(len(buf))
+	}
+	tableRows[key] = row
+
+	cts.TotalDataCount++
+	cts.fullSize += rowObjectMemoryOverhead + uint64(len(key))
+	for _, col := range rowObject.ColScans {
+		cts.fullSize += columnMemoryOverhead + uint64(len(col.GetValueString()))
+	}
+	cts.compactSize += compactRowMemoryOverhead + uint64(len(key)) + uint64(len(row.columnHashes)*columnHashMemoryOverhead)
+	return nil
+}
+
+// Restore the before row from the spill file. Values are NotCapturedValue if not spilled.
+func (cts *CompactTableStore) restore(tableName string, row *compactRow) (*RowObject, error) {
+	columns := cts.AllColumn[tableName]
+	var colScans []*ColumnScan
+	if row.length == compactRowLengthUnspilled || cts.spill == nil {
+		for range columns {
+			colScans = append(colScans, &ColumnScan{Value: &sql.NullString{String: NotCapturedValue, Valid: true}})
+		}
+	} else {
+		buf := make([]byte, row.length)
+		if _, err := cts.spill.ReadAt(buf, row.offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		var err error
+		if colScans, err = decodeRowValues(buf); err != nil {
+			return nil, err
+		}
+	}
+	return &RowObject{ColScans: colScans, DiffStatus: DiffStatusInit, ModifiedColumnIndex: []uint8{}, ColumnNames: columns, IsBeforeData: true}, nil
+}
+
+// Set ModifiedColumnIndex by the column hashes. All columns are modified if no column hashes.
+func markModifiedColumns(beforeRow *compactRow, beforeRowObject *RowObject, afterRowObject *RowObject, structural bool) {
+	beforeRowObject.ModifiedColumnIndex = []uint8{}
+	afterRowObject.ModifiedColumnIndex = []uint8{}
+	for index, col := range afterRowObject.ColScans {
+		if beforeRow.columnHashes != nil && index < len(beforeRow.columnHashes) && beforeRow.columnHashes[index] == hashColumn(col, structural) {
+			continue
+		}
+		beforeRowObject.ModifiedColumnIndex = append(beforeRowObject.ModifiedColumnIndex, uint8(index))
+		afterRowObject.ModifiedColumnIndex = append(afterRowObject.ModifiedColumnIndex, uint8(index))
+	}
+}
+
+// Whether JSON and XML columns are hashed in their canonical forms. Only without spilling, because the spilled
+// values are compared by the comparison rules, which can compare them exactly.
+func (cts *CompactTableStore) structuralHashes() bool {
+	return !cts.options.Spill
+}
+
+// Hash of the row, equal iff RowObject#EqualColumns() is true(except for collisions).
+// With structural, JSON and XML columns are hashed in their canonical forms.
+func hashRow(rowObject *RowObject, structural bool) [compactRowHashSize]byte {
+	h := sha256.New()
+	var lenBuf [binary.MaxVarintLen64]byte
+	h.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(rowObject.ColScans)))])
+	for _, col := range rowObject.ColScans {
+		v := hashedValue(col, structural)
+		h.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(v)))])
+		h.Write([]byte(v))
+	}
+	var hash [compactRowHashSize]byte
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
+
+func hashColumn(col *ColumnScan, structural bool) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(hashedValue(col, structural)))
+	return h.Sum64()
+}
+
+func hashedValue(col *ColumnScan, structural bool) string {
+	v := col.GetValueString()
+	if structural && col.structure != "" && v != "<NULL>" {
+		return canonicalStructure(col.structure, v)
+	}
+	return v
+}
+
+// Encode values of the row: uvarint(column count), then for each column: null mark, uvarint(length), bytes.
+// The mark of not null values also holds the structure of the column, so that it is compared the same after restoring.
+func encodeRowValues(rowObject *RowObject) []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+	buf := append([]byte{}, lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(rowObject.ColScans)))]...)
+	for _, col := range rowObject.ColScans {
+		ns, ok := col.Value.(*sql.NullString)
+		if ok && !ns.Valid {
+			buf = append(buf, compactColumnNullMark)
+			continue
+		}
+		v := col.GetValueString()
+		buf = append(buf, compactColumnStructureMarks[col.structure])
+		buf = append(buf, lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(v)))]...)
+		buf = append(buf, v...)
+	}
+	return buf
+}
+
+func decodeRowValues(buf []byte) ([]*ColumnScan, error) {
+	errInvalid := errors.New("invalid spill data")
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, errInvalid
+	}
+	buf = buf[n:]
+	colScans := make([]*ColumnScan, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if len(buf) == 0 {
+			return nil, errInvalid
+		}
+		ns := &sql.NullString{}
+		col := &ColumnScan{Value: ns}
+		mark := buf[0]
+		buf = buf[1:]
+		if mark != compactColumnNullMark {
+			for structure, structureMark := range compactColumnStructureMarks {
+				if mark == structureMark {
+					col.structure = structure
+				}
+			}
+			length, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < length {
+				return nil, errInvalid
+			}
+			ns.String = string(buf[n : n+int(length)])
+			ns.Valid = true
+			buf = buf[n+int(length):]
+		}
+		colScans = append(colScans, col)
+	}
+	return colScans, nil
+}
@@ -0,0 +1,113 @@
+package dbdiff
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_encodeRowValues(t *testing.T) {
+	columns := []string{"id", "name", "note"}
+	row := newTestRow(DiffStatusInit, false, columns, []string{"1", "<NULL>", "日本語"})
+	row.ColScans[0].structure = CompareTypeJSON
+	row.ColScans[2].structure = CompareTypeXML
+	colScans, err := decodeRowValues(encodeRowValues(row))
+	if err != nil {
+		t.Fatalf("decodeRowValues() error = %v", err)
+	}
+	if !reflect.DeepEqual(colScans, row.ColScans) {
+		t.Errorf("decodeRowValues() = %v, want %v", colScans, row.ColScans)
+	}
+
+	if _, err := decodeRowValues([]byte{3, compactColumnNotNullMark, 10, 'a'}); err == nil {
+		t.Errorf("decodeRowValues() error = nil, want error")
+	}
+}
+
+func Test_hashRow(t *testing.T) {
+	columns := []string{"a", "b"}
+	tests := []struct {
+		name  string
+		this  *RowObject
+		that  *RowObject
+		equal bool
+	}{
+		{"Equal", newTestRow(DiffStatusInit, false, columns, []string{"x", "y"}), newTestRow(DiffStatusInit, false, columns, []string{"x", "y"}), true},
+		{"Modified", newTestRow(DiffStatusInit, false, columns, []string{"x", "y"}), newTestRow(DiffStatusInit, false, columns, []string{"x", "z"}), false},
+		{"Boundary", newTestRow(DiffStatusInit, false, columns, []string{"xy", ""}), newTestRow(DiffStatusInit, false, columns, []string{"x", "y"}), false},
+		{"ColumnCount", newTestRow(DiffStatusInit, false, columns, []string{"x", "y"}), newTestRow(DiffStatusInit, false, []string{"a"}, []string{"x"}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hashRow(tt.this, false) == hashRow(tt.that, false); got != tt.equal {
+				t.Errorf("hashRow() equal = %v, want %v", got, tt.equal)
+			}
+			if got := tt.this.EqualColumns(tt.that); got != tt.equal {
+				t.Errorf("EqualColumns() = %v, want %v", got, tt.equal)
+			}
+		})
+	}
+}
+
+func Test_hashRow_structural(t *testing.T) {
+	columns := []string{"id", "doc"}
+	row := func(structure string, doc string) *RowObject {
+		r := newTestRow(DiffStatusInit, false, columns, []string{"1", doc})
+		r.ColScans[1].structure = structure
+		return r
+	}
+	tests := []struct {
+		name           string
+		this           *RowObject
+		that           *RowObject
+		wantStructural bool
+	}{
+		{"JSONKeys", row(CompareTypeJSON, `{"b": 1, "a": [1, 2]}`), row(CompareTypeJSON, `{"a":[1,2],"b":1}`), true},
+		{"JSONChanged", row(CompareTypeJSON, `{"a": 1}`), row(CompareTypeJSON, `{"a": 2}`), false},
+		{"XMLAttributes", row(CompareTypeXML, `<a x="1" y="2"/>`), row(CompareTypeXML, `<a y="2" x="1"></a>`), true},
+		{"NotStructured", row("", `{"b": 1, "a": 2}`), row("", `{"a": 2, "b": 1}`), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hashRow(tt.this, true) == hashRow(tt.that, true); got != tt.wantStructural {
+				t.Errorf("hashRow(structural) equal = %v, want %v", got, tt.wantStructural)
+			}
+			if hashColumn(tt.this.ColScans[1], true) == hashColumn(tt.that.ColScans[1], true) != tt.wantStructural {
+				t.Errorf("hashColumn(structural) equal = %v, want %v", !tt.wantStructural, tt.wantStructural)
+			}
+			if hashRow(tt.this, false) == hashRow(tt.that, false) {
+				t.Errorf("hashRow() equal = true, want false")
+			}
+		})
+	}
+}
+
+func TestCompactTableStore_CollectAndCompare_structuredColumns(t *testing.T) {
+	// PostgreSQLのjsonはキーの順序を保存するので、並べ替えただけの値も異なる文字列になる
+	docs := []string{`{"theme": "dark", "lang": "ja"}`, `{"lang": "ja", "theme": "dark"}`}
+	db := openFakeDB(t, func(query string, args []interface{}) (*fakeResult, error) {
+		result := &fakeResult{columns: []string{"id", "settings"}, types: []string{"INT4", "JSON"}}
+		if !strings.HasSuffix(query, "WHERE 1 = 0") {
+			result.rows = [][]interface{}{{int64(1), docs[0]}}
+			docs = docs[1:]
+		}
+		return result, nil
+	})
+	config := &Configuration{Db: Db{DbType: "postgresql"}}
+	tablePks := map[string][]string{"users": {"id"}}
+
+	before := NewCompactTableStore(CompactOptions{ColumnHashes: true})
+	defer before.Close()
+	if err := before.Collect(context.Background(), db, config, tablePks); err != nil {
+		t.Fatal(err)
+	}
+	got, after, err := before.CollectAndCompare(context.Background(), db, config, tablePks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer after.Close()
+	if len(got["users"]) != 0 {
+		t.Errorf("CollectAndCompare() = %v, want no changes", got["users"])
+	}
+}
@@ -0,0 +1,312 @@
+package dbdiff
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of values for CompareRule.Type
+const (
+	CompareTypeNumber    = "number"
+	CompareTypeTimestamp = "timestamp"
+	CompareTypeString    = "string"
+	CompareTypeJSON      = "json" // Compared structurally, the changed paths are reported
+	CompareTypeXML       = "xml"  // Compared structurally, the changed paths are reported
+)
+
+// Database type names(sql.ColumnType#DatabaseTypeName()) of the columns compared structurally without rules
+var structuredTypeNames = map[string]string{
+	"JSON":  CompareTypeJSON, // MySQL, PostgreSQL
+	"JSONB": CompareTypeJSON, // PostgreSQL
+	"XML":   CompareTypeXML,  // SQL Server
+}
+
+// Rules of value comparison
+type ComparisonConfig struct {
+	// Rules applied in order, the first rule matching the column and the type of the values is used
+	Rules []CompareRule `yaml:"rules"`
+}
+
+// Rule to regard different values of the columns as equal, e.g. floats with rounding errors or timestamps with
+// different precisions. Values are always equal if they are the same, and NULL is equal only to NULL.
+type CompareRule struct {
+	Table  string `yaml:"table"`  // Pattern of table names(path.Match), all tables if empty
+	Column string `yaml:"column"` // Pattern of column names(path.Match), all columns if empty
+	// number, timestamp, string, json or xml. The rule is used only if both values are of the type. Any values if empty.
+	Type string `yaml:"type"`
+	// With json or xml, arrays(and XML elements of the same name) are compared regardless of the order.
+	// Keys of JSON objects and XML attributes are always compared regardless of the order.
+	IgnoreArrayOrder bool `yaml:"ignore_array_order"`
+
+	// Numbers are equal if |a-b| <= absolute_tolerance or |a-b| <= relative_tolerance * max(|a|, |b|)
+	AbsoluteTolerance float64 `yaml:"absolute_tolerance"`
+	RelativeTolerance float64 `yaml:"relative_tolerance"`
+	// Decimals are equal regardless of trailing zeros, e.g. 1.50 and 1.5
+	IgnoreTrailingZeros bool `yaml:"ignore_trailing_zeros"`
+
+	// Timestamps are equal if they differ by less than the precision, e.g. 1ms, 10ms, 1s
+	Precision time.Duration `yaml:"precision"`
+	// Time zone of timestamps without offset, e.g. Asia/Tokyo. UTC if empty. Timestamps are compared as instants,
+	// so that "2020-01-01 09:00:00+09" and "2020-01-01 00:00:00"(in UTC) are equal.
+	TimeZone string `yaml:"timezone"`
+
+	// Strings are compared case-insensitively, and/or without leading and trailing spaces
+	IgnoreCase bool `yaml:"ignore_case"`
+	TrimSpace  bool `yaml:"trim_space"`
+
+	// Values are compared exactly, e.g. to turn off the structural comparison of JSON and XML columns
+	Exact bool `yaml:"exact"`
+}
+
+type compiledCompareRule struct {
+	CompareRule
+	location *time.Location
+}
+
+// Comparer of values by the rules of ComparisonConfig. nil compares values exactly, except JSON and XML columns.
+type ValueComparer struct {
+	rules []*compiledCompareRule
+}
+
+// Function to compare values of the column. Used by RowObject#EqualColumnsWith().
+// kind is CompareTypeJSON or CompareTypeXML if the column is of the type, otherwise empty.
+// changes are the changed paths of structured(JSON, XML) values, nil for the other values.
+type ColumnEqualFunc func(colName string, kind string, a string, b string) (equal bool, changes []PathChange)
+
+func NewValueComparer(config ComparisonConfig) (*ValueComparer, error) {
+	if len(config.Rules) == 0 {
+		return nil, nil
+	}
+	comparer := &ValueComparer{}
+	for i := range config.Rules {
+		rule, err := compileCompareRule(config.Rules[i])
+		if err != nil {
+			return nil, err
+		}
+		comparer.rules = append(comparer.rules, rule)
+	}
+	return comparer, nil
+}
+
+func compileCompareRule(rule CompareRule) (*compiledCompareRule, error) {
+	structured := rule.Type == CompareTypeJSON || rule.Type == CompareTypeXML
+	if rule.Exact {
+		if rule != (CompareRule{Table: rule.Table, Column: rule.Column, Exact: true}) {
+			return nil, errors.New("exact of comparison rule can not be used with type or the other settings")
+		}
+	} else if rule.AbsoluteTolerance == 0 && rule.RelativeTolerance == 0 && !rule.IgnoreTrailingZeros && rule.Precision == 0 &&
+		rule.TimeZone == "" && !rule.IgnoreCase && !rule.TrimSpace && !structured {
+		return nil, errors.New("comparison rule has no tolerance setting")
+	}
+	switch rule.Type {
+	case "", CompareTypeNumber, CompareTypeTimestamp, CompareTypeString, CompareTypeJSON, CompareTypeXML:
+	default:
+		return nil, fmt.Errorf("type of comparison rule [%s] is invalid (number|timestamp|string|json|xml)", rule.Type)
+	}
+	if rule.IgnoreArrayOrder && !structured {
+		return nil, errors.New("ignore_array_order of comparison rule requires type json or xml")
+	}
+	if rule.AbsoluteTolerance < 0 || rule.RelativeTolerance < 0 || rule.Precision < 0 {
+		return nil, errors.New("tolerances and precision of comparison rule must not be negative")
+	}
+	for _, p := range []string{rule.Table, rule.Column} {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern of comparison rule [%s]: %v", p, err)
+		}
+	}
+	compiled := &compiledCompareRule{CompareRule: rule}
+	if rule.TimeZone != "" {
+		location, err := time.LoadLocation(rule.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone of comparison rule: %v", err)
+		}
+		compiled.location = location
+	}
+	return compiled, nil
+}
+
+// Comparison rules of the configuration. nil if no rules.
+func (c *Configuration) ValueComparer() (*ValueComparer, error) {
+	return NewValueComparer(c.Comparison)
+}
+
+// Function comparing the values of the columns of the table by the rules.
+// JSON and XML columns without a matching rule are compared structurally, so that e.g. keys reordered by MySQL are equal.
+func (vc *ValueComparer) ForTable(tableName string) ColumnEqualFunc {
+	var allRules []*compiledCompareRule
+	if vc != nil {
+		allRules = vc.rules
+	}
+	// テーブル内の行はカラムが同じなので、カラムごとに該当するルールを覚えておく
+	columnRules := map[string][]*compiledCompareRule{}
+	return func(colName string, kind string, a string, b string) (bool, []PathChange) {
+		if a == b {
+			return true, nil
+		}
+		if a == "<NULL>" || b == "<NULL>" {
+			return false, nil
+		}
+		rules, ok := columnRules[colName]
+		if !ok {
+			for _, rule := range allRules {
+				if matchName(rule.Table, tableName) && matchName(rule.Column, colName) {
+					rules = append(rules, rule)
+				}
+			}
+			columnRules[colName] = rules
+		}
+		for _, rule := range rules {
+			if rule.Exact {
+				return false, nil
+			}
+			if rule.Type == CompareTypeJSON || rule.Type == CompareTypeXML {
+				if changes, ok := rule.diffStructure(a, b); ok {
+					return len(changes) == 0, changes
+				}
+				continue
+			}
+			if equal, applied := rule.equal(a, b); applied {
+				return equal, nil
+			}
+		}
+		// ルールがなければ、JSON、XMLのカラムは構造で比較する
+		if kind != "" {
+			rule := &compiledCompareRule{CompareRule: CompareRule{Type: kind}}
+			if changes, ok := rule.diffStructure(a, b); ok {
+				return len(changes) == 0, changes
+			}
+		}
+		return false, nil
+	}
+}
+
+// Changed paths of JSON or XML values. ok is false if the values are not of the type of the rule.
+func (rule *compiledCompareRule) diffStructure(a string, b string) (changes []PathChange, ok bool) {
+	differ := &structureDiffer{ignoreArrayOrder: rule.IgnoreArrayOrder, leafEqual: rule.equalLeaves}
+	if rule.Type == CompareTypeXML {
+		return differ.diffXML(a, b)
+	}
+	return differ.diffJSON(a, b)
+}
+
+// Whether scalar values in JSON or XML are equal. Numbers are compared numerically(and with the tolerances).
+func (rule *compiledCompareRule) equalLeaves(a string, b string, number bool) bool {
+	if a == b {
+		return true
+	}
+	if number || rule.AbsoluteTolerance > 0 || rule.RelativeTolerance > 0 || rule.IgnoreTrailingZeros {
+		x, errX := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		y, errY := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if errX == nil && errY == nil {
+			return rule.equalNumbers(a, b, x, y)
+		}
+	}
+	return rule.equalStrings(a, b)
+}
+
+// Whether the values are equal by the rule. applied is false if the values are not of the type of the rule.
+func (rule *compiledCompareRule) equal(a string, b string) (equal bool, applied bool) {
+	switch rule.Type {
+	case CompareTypeNumber:
+		x, errX := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		y, errY := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if errX != nil || errY != nil {
+			return false, false
+		}
+		return rule.equalNumbers(a, b, x, y), true
+	case CompareTypeTimestamp:
+		x, okX := rule.parseTimestamp(a)
+		y, okY := rule.parseTimestamp(b)
+		if !okX || !okY {
+			return false, false
+		}
+		return rule.equalTimestamps(x, y), true
+	case CompareTypeString:
+		return rule.equalStrings(a, b), true
+	}
+
+	// 型の指定がなければ、設定された項目と値の形式に合わせて比較する
+	if rule.AbsoluteTolerance > 0 || rule.RelativeTolerance > 0 || rule.IgnoreTrailingZeros {
+		x, errX := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		y, errY := strconv.ParseFloat(strings.TrimSpace(b), 64)
+		if errX == nil && errY == nil {
+			return rule.equalNumbers(a, b, x, y), true
+		}
+	}
+	if rule.Precision > 0 || rule.location != nil {
+		x, okX := rule.parseTimestamp(a)
+		y, okY := rule.parseTimestamp(b)
+		if okX && okY {
+			return rule.equalTimestamps(x, y), true
+		}
+	}
+	if rule.IgnoreCase || rule.TrimSpace {
+		return rule.equalStrings(a, b), true
+	}
+	return false, false
+}
+
+func (rule *compiledCompareRule) equalNumbers(a string, b string, x float64, y float64) bool {
+	if rule.IgnoreTrailingZeros && trimTrailingZeros(strings.TrimSpace(a)) == trimTrailingZeros(strings.TrimSpace(b)) {
+		return true
+	}
+	diff := math.Abs(x - y)
+	return diff <= rule.AbsoluteTolerance || diff <= rule.RelativeTolerance*math.Max(math.Abs(x), math.Abs(y))
+}
+
+// "1.500" -> "1.5", "2.0" -> "2"
+func trimTrailingZeros(s string) string {
+	if !strings.Contains(s, ".") || strings.ContainsAny(s, "eE") {
+		return s
+	}
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+func (rule *compiledCompareRule) equalTimestamps(x time.Time, y time.Time) bool {
+	if rule.Precision > 0 {
+		// 切り捨てだと境界をまたぐ値(00.009999と00.010)が一致しないので、差で比べる
+		diff := x.Sub(y)
+		return diff < rule.Precision && -diff < rule.Precision
+	}
+	return x.Equal(y)
+}
+
+func (rule *compiledCompareRule) equalStrings(a string, b string) bool {
+	if rule.TrimSpace {
+		a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	}
+	if rule.IgnoreCase {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// Formats of timestamps returned by the drivers as strings
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// Timestamp of the value. Timestamps without offset are in the time zone of the rule(UTC if not set).
+func (rule *compiledCompareRule) parseTimestamp(value string) (time.Time, bool) {
+	location := rule.location
+	if location == nil {
+		location = time.UTC
+	}
+	value = strings.TrimSpace(value)
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,121 @@
+package dbdiff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValueComparer_ForTable(t *testing.T) {
+	comparer, err := NewValueComparer(ComparisonConfig{Rules: []CompareRule{
+		{Table: "measurements", Column: "value", AbsoluteTolerance: 0.001},
+		{Column: "ratio", RelativeTolerance: 0.01},
+		{Type: CompareTypeNumber, IgnoreTrailingZeros: true},
+		{Column: "local_at", TimeZone: "Asia/Tokyo"},
+		{Type: CompareTypeTimestamp, Precision: 10 * time.Millisecond},
+		{Column: "code", IgnoreCase: true, TrimSpace: true},
+	}})
+	if err != nil {
+		t.Fatalf("NewValueComparer() error = %v", err)
+	}
+	tests := []struct {
+		name   string
+		table  string
+		column string
+		a      string
+		b      string
+		want   bool
+	}{
+		{"Same", "users", "name", "alice", "alice", true},
+		{"NoRule", "users", "name", "alice", "Alice", false},
+		{"Null", "users", "code", "<NULL>", "", false},
+		{"AbsoluteTolerance", "measurements", "value", "0.1", "0.1005", true},
+		{"AbsoluteToleranceExceeded", "measurements", "value", "0.1", "0.102", false},
+		{"AbsoluteToleranceOtherTable", "samples", "value", "0.1", "0.1005", false},
+		{"RelativeTolerance", "samples", "ratio", "1000", "1009", true},
+		{"RelativeToleranceExceeded", "samples", "ratio", "1000", "1011", false},
+		{"TrailingZeros", "orders", "price", "1.50", "1.5", true},
+		{"TrailingZerosInteger", "orders", "price", "2.000", "2", true},
+		{"TrailingZerosDifferent", "orders", "price", "1.50", "1.05", false},
+		// MySQLのマイクロ秒とSQL Serverのdatetime(約3ms精度)
+		{"Precision", "orders", "created_at", "2020-01-01 10:00:00.001234", "2020-01-01T10:00:00.003Z", true},
+		{"PrecisionBoundary", "orders", "created_at", "2020-01-01 10:00:00.009999", "2020-01-01 10:00:00.010", true},
+		{"PrecisionBoundaryReversed", "orders", "created_at", "2020-01-01 10:00:00.010", "2020-01-01 10:00:00.009999", true},
+		{"PrecisionExceeded", "orders", "created_at", "2020-01-01 10:00:00.001", "2020-01-01 10:00:00.011", false},
+		{"TimeZone", "orders", "local_at", "2020-01-01 09:00:00", "2020-01-01 00:00:00+00", true},
+		{"TimeZoneDifferent", "orders", "local_at", "2020-01-01 00:00:00", "2020-01-01 00:00:00+00", false},
+		{"IgnoreCaseTrimSpace", "users", "code", " ABC ", "abc", true},
+		{"IgnoreCaseDifferent", "users", "code", "ABC", "abd", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := comparer.ForTable(tt.table)(tt.column, "", tt.a, tt.b); got != tt.want {
+				t.Errorf("ForTable(%v)(%v, %v, %v) = %v, want %v", tt.table, tt.column, tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRowObject_EqualColumnsWith(t *testing.T) {
+	comparer, err := NewValueComparer(ComparisonConfig{Rules: []CompareRule{{Column: "amount", AbsoluteTolerance: 0.01}}})
+	if err != nil {
+		t.Fatalf("NewValueComparer() error = %v", err)
+	}
+	columns := []string{"id", "amount", "note"}
+	before := newTestRow(DiffStatusInit, true, columns, []string{"1", "10.001", "a"})
+	after := newTestRow(DiffStatusInit, false, columns, []string{"1", "10.002", "b"})
+	if before.EqualColumnsWith(after, comparer.ForTable("orders")) {
+		t.Errorf("EqualColumnsWith() = true, want false")
+	}
+	if len(after.ModifiedColumnIndex) != 1 || !after.IsModifiedColumn(2) {
+		t.Errorf("ModifiedColumnIndex = %v, want [2]", after.ModifiedColumnIndex)
+	}
+
+	for _, rule := range []CompareRule{
+		{Column: "amount"},
+		{Column: "amount", AbsoluteTolerance: -1},
+		{Type: "decimal", IgnoreTrailingZeros: true},
+		{TimeZone: "Nowhere/Unknown"},
+		{Column: "settings", Exact: true, TrimSpace: true},
+		{Type: CompareTypeJSON, Exact: true},
+	} {
+		if _, err := NewValueComparer(ComparisonConfig{Rules: []CompareRule{rule}}); err == nil {
+			t.Errorf("NewValueComparer(%+v) error = nil, want error", rule)
+		}
+	}
+}
+
+func TestValueComparer_ForTable_structuredColumns(t *testing.T) {
+	comparer, err := NewValueComparer(ComparisonConfig{Rules: []CompareRule{
+		{Column: "raw", Exact: true},
+		{Column: "tags", Type: CompareTypeJSON, IgnoreArrayOrder: true},
+	}})
+	if err != nil {
+		t.Fatalf("NewValueComparer() error = %v", err)
+	}
+	tests := []struct {
+		name     string
+		comparer *ValueComparer
+		column   string
+		kind     string
+		a        string
+		b        string
+		want     bool
+	}{
+		// MySQLのJSON型はキーを並べ替えて返す
+		{"JSONWithoutRules", nil, "settings", CompareTypeJSON, `{"b": 1, "a": 2}`, `{"a": 2, "b": 1}`, true},
+		{"JSONChanged", nil, "settings", CompareTypeJSON, `{"a": 1}`, `{"a": 2}`, false},
+		{"XMLWithoutRules", nil, "doc", CompareTypeXML, `<a x="1" y="2"/>`, `<a y="2" x="1"/>`, true},
+		{"NotStructuredColumn", nil, "note", "", `{"b": 1, "a": 2}`, `{"a": 2, "b": 1}`, false},
+		{"InvalidJSON", nil, "settings", CompareTypeJSON, `{"a": 1`, `{"a": 1 `, false},
+		{"NoMatchingRule", comparer, "settings", CompareTypeJSON, `{"b": 1, "a": 2}`, `{"a": 2, "b": 1}`, true},
+		{"Exact", comparer, "raw", CompareTypeJSON, `{"b": 1, "a": 2}`, `{"a": 2, "b": 1}`, false},
+		{"Rule", comparer, "tags", CompareTypeJSON, `["a", "b"]`, `["b", "a"]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := tt.comparer.ForTable("users")(tt.column, tt.kind, tt.a, tt.b); got != tt.want {
+				t.Errorf("ForTable()(%v, %v, %v, %v) = %v, want %v", tt.column, tt.kind, tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
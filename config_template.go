@@ -0,0 +1,111 @@
+package dbdiff
+
+import (
+	"strings"
+)
+
+// Default ports of the dialects
+var defaultPorts = map[string]string{
+	"postgresql": "5432",
+	"mysql":      "3306",
+	"mssql":      "1433",
+}
+
+const configurationTemplate = `# Configuration of dbdiff. Check it by "dbdiff config validate".
+db:
+  type: {{type}}
+  host: localhost
+  port: {{port}}
+  user: username
+  # Password can also be read from a file or a command, or written as an environment variable:
+  #   password: ${DB_PASSWORD}
+  #   password_file: secrets/db_password
+  #   password_command: pass show db/dev
+  password: password
+  name: sampledatabase
+{{schema}}  # Connection string of the driver can be used instead of host, port and name:
+  #   url: {{url}}
+  # Options of the driver:
+  #   params:
+  #     {{param}}
+  # TLS(disable|require|verify-ca|verify-full):
+  #   tls:
+  #     mode: verify-full
+  #     ca_file: certs/ca.pem
+
+snapshot:
+  # Skip tables whose checksum is not changed
+  checksum_precheck: false
+  # Read tables in chunks of this number of rows. Disabled if 0.
+  chunk_size: 0
+  # Condition applied to tables having the columns, e.g. "tenant_id = :tenant"
+  #   where: tenant_id = :tenant
+  #   params:
+  #     tenant: 42
+  # Timeout of each query and of a whole snapshot, e.g. 30s, 10m. Disabled if 0.
+  query_timeout: 0s
+  timeout: 0s
+  # Truncate text values longer than this(in characters). Disabled if 0.
+  max_cell_length: 0
+  # Binary values are shown like "<blob 12KB sha256:...>", with this number of leading bytes in hex
+  blob_preview_bytes: 0
+  # Export binary values of the changed rows to the directory
+  #   blob_export_dir: blobs
+
+# Per-table settings
+#   tables:
+#     orders:
+#       tracking_column: updated_at
+#       where: created_at > '2020-01-01'
+#       sample_percent: 10
+
+# Masking of personal information in the reports(redact|partial|hash|fake)
+#   masking:
+#     salt: ${DBDIFF_MASKING_SALT}
+#     rules:
+#       - column: "*email*"
+#         strategy: hash
+
+# Tolerance rules of value comparison
+#   comparison:
+#     rules:
+#       - type: number
+#         absolute_tolerance: 0.000001
+#       - type: timestamp
+#         precision: 10ms
+
+# Report a deleted row and an inserted row as the same row whose key is changed,
+# if this ratio of the values except the primary key are equal
+#   key_changes:
+#     threshold: 0.9
+#     columns: ["*"]
+
+# Named profiles overriding the settings above(use with -profile)
+#   profiles:
+#     staging:
+#       db:
+#         host: staging.example.com
+`
+
+// Annotated configuration file of the dialect(postgresql, mysql or mssql), for "dbdiff config init"
+func ConfigurationTemplate(dbType string) (string, error) {
+	port, ok := defaultPorts[dbType]
+	if !ok {
+		return "", &ErrUnsupportedDialect{DbType: dbType}
+	}
+	schema, url, param := "", "", ""
+	switch dbType {
+	case "postgresql":
+		schema = "  # Schema of the tables(with a trailing dot)\n  schema: public.\n"
+		url = "postgresql://username@localhost:5432/sampledatabase"
+		param = "sslmode: disable"
+	case "mysql":
+		url = "mysql://username@localhost:3306/sampledatabase"
+		param = `parseTime: "true"`
+	case "mssql":
+		url = "sqlserver://username@localhost:1433?database=sampledatabase"
+		param = "encrypt: disable"
+	}
+	return strings.NewReplacer("{{type}}", dbType, "{{port}}", port, "{{schema}}", schema, "{{url}}", url,
+		"{{param}}", param).Replace(configurationTemplate), nil
+}
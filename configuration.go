@@ -5,11 +5,27 @@ import (
 	"gopkg.in/yaml.v3"
 	"io/ioutil"
 	"log"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 type Configuration struct {
-	Db Db `yaml:"db"`
+	Db       Db                     `yaml:"db"`
+	Snapshot Snapshot               `yaml:"snapshot"`
+	Tables   map[string]TableConfig `yaml:"tables"`
+	// Masking of personal information in the reports
+	Masking MaskingConfig `yaml:"masking"`
+	// Tolerance rules of value comparison
+	Comparison ComparisonConfig `yaml:"comparison"`
+	// Detection of the rows whose primary key is changed
+	KeyChanges KeyChangeConfig `yaml:"key_changes"`
+
+	// Name of the loaded profile, empty for the top level settings
+	Profile string `yaml:"-"`
+
+	filters map[string]string // resolved by ResolveTableFilters()
+	sources []ValueSource     // values not written in the configuration file
 }
 
 type Db struct {
@@ -20,19 +36,119 @@ type Db struct {
 	Password string `yaml:"password"`
 	Name     string `yaml:"name"`
 	Schema   string `yaml:"schema"`
+
+	// Connection string of the driver, used instead of host, port and name
+	DSN string `yaml:"dsn"`
+	// URL like "postgresql://user@host:5432/name", used instead of host, port and name. type can be omitted.
+	URL string `yaml:"url"`
+	// Options of the driver merged into the connection string, e.g. {sslmode: disable}, {parseTime: "true"}
+	Params map[string]string `yaml:"params"`
+	// TLS configuration
+	TLS TLSConfig `yaml:"tls"`
+
+	// File containing the password, e.g. a secret mounted by Docker/Kubernetes
+	PasswordFile string `yaml:"password_file"`
+	// Command printing the password, e.g. "pass show db/dev"
+	PasswordCommand string `yaml:"password_command"`
+	// .pgpass or my.cnf style file of the credentials, used if no password is specified.
+	// ~/.pgpass(or PGPASSFILE) for PostgreSQL and ~/.my.cnf for MySQL if empty.
+	CredentialsFile string `yaml:"credentials_file"`
 }
 
+// Configuration of snapshot collection
+type Snapshot struct {
+	// Compare checksums computed by the database before fetching rows, and skip tables whose checksum is not changed
+	// since the previous snapshot.
+	ChecksumPrecheck bool `yaml:"checksum_precheck"`
+	// Read tables in chunks of this number of rows, paginated by the primary key. Disabled if 0.
+	ChunkSize int `yaml:"chunk_size"`
+	// Number of retries of a failed chunk. 0 means DefaultChunkRetries, negative means no retry.
+	ChunkRetries int `yaml:"chunk_retries"`
+	// Condition applied to tables having any of the columns used in it, e.g. "tenant_id = :tenant"
+	Where string `yaml:"where"`
+	// Values of the parameters in where, e.g. {tenant: 42}
+	Params map[string]string `yaml:"params"`
+	// Percentage of rows to sample from each table. Disabled if 0.
+	SamplePercent float64 `yaml:"sample_percent"`
+	// Seed of the sampling, rows are sampled repeatably with the same seed
+	SampleSeed int `yaml:"sample_seed"`
+	// Timeout of each query, e.g. "30s". Disabled if 0.
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+	// Timeout of collecting a whole snapshot, e.g. "10m". Disabled if 0.
+	Timeout time.Duration `yaml:"timeout"`
+	// Continue collecting other tables when a table fails(see AllTableStore#FailedTables)
+	ContinueOnError bool `yaml:"continue_on_error"`
+	// Text values longer than this(in characters) are truncated with a marker having the hash of the whole value.
+	// Disabled if 0.
+	MaxCellLength int `yaml:"max_cell_length"`
+	// Number of leading bytes of binary values shown in hex. Only the size and the hash are shown if 0.
+	BlobPreviewBytes int `yaml:"blob_preview_bytes"`
+	// Directory to export the binary values of the changed rows to(see ExportBlobs()). Not exported if empty.
+	BlobExportDir string `yaml:"blob_export_dir"`
+}
+
+// Per-table configuration
+type TableConfig struct {
+	// Column whose value increases on every insert/update(e.g. updated_at, rowversion).
+	// Only rows with a value not less than the max value of the previous snapshot are fetched.
+	TrackingColumn string `yaml:"tracking_column"`
+	// Condition of the rows to collect, e.g. "user_id = :user"
+	Where string `yaml:"where"`
+	// Percentage of rows to sample. Snapshot.SamplePercent is used if 0.
+	SamplePercent float64 `yaml:"sample_percent"`
+	// Masking strategy by column name pattern, e.g. {email: hash, card_number: partial}
+	Masking map[string]string `yaml:"masking"`
+	// Patterns of the columns used for detecting key changes. KeyChangeConfig.Columns is used if empty.
+	KeyChangeColumns []string `yaml:"key_change_columns"`
+}
+
+// Sources of the values supplied by environment variables, password_file, password_command or credentials files
+func (c *Configuration) ValueSources() []ValueSource {
+	return c.sources
+}
+
+// Get configuration of the table. Zero value if not configured.
+func (c *Configuration) GetTableConfig(tableName string) TableConfig {
+	return c.Tables[tableName]
+}
+
+// Load the top level settings of the configuration file and keep it for GetConfiguration().
+// The file is loaded again on each call.
 func LoadConfiguration(configFilePath string) (*Configuration, error) {
-	var err error
-	onceYaml.Do(func() {
-		if instanceYaml, err = initializeYaml(configFilePath); err != nil {
-			log.Printf("Can not load configuration %+v\n", err)
-		}
-	})
-	return instanceYaml, err
+	return LoadProfile(configFilePath, "")
+}
+
+// Load the named profile of the configuration file and keep it for GetConfiguration().
+// The top level settings are loaded if profile is empty.
+func LoadProfile(configFilePath string, profile string) (*Configuration, error) {
+	config, err := LoadProfileFile(configFilePath, profile)
+	if err != nil {
+		return nil, err
+	}
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
+	instanceYaml = config
+	return config, nil
+}
+
+// Load a configuration file without replacing the one loaded by LoadConfiguration(),
+// e.g. for the target database of a comparison.
+func LoadConfigurationFile(configFilePath string) (*Configuration, error) {
+	return LoadProfileFile(configFilePath, "")
+}
+
+// Load the named profile of the configuration file without replacing the one loaded by LoadConfiguration().
+func LoadProfileFile(configFilePath string, profile string) (*Configuration, error) {
+	config, err := initializeYamlProfile(configFilePath, profile)
+	if err != nil {
+		log.Printf("Can not load configuration %+v\n", err)
+	}
+	return config, err
 }
 
 func GetConfiguration() (*Configuration, error) {
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
 	var err error
 	if instanceYaml == nil {
 		log.Printf("Need to be initialize with LoadConfiguration()\n")
@@ -42,28 +158,86 @@ func GetConfiguration() (*Configuration, error) {
 }
 
 var instanceYaml *Configuration
-var onceYaml sync.Once
+var instanceLock sync.Mutex
 
 const DefaultConfigFilePath = "configuration.yaml"
 
 // yamlファイルから構造体を生成
 func initializeYaml(configFilePath string) (*Configuration, error) {
+	return initializeYamlProfile(configFilePath, "")
+}
+
+// Configuration of the profile in the yaml document. Relative paths are resolved from baseDir.
+func decodeProfile(document *yaml.Node, profile string, baseDir string) (*Configuration, error) {
+	// 環境変数は選択したプロファイルだけで置換する(他のプロファイル用の変数は未設定でもよい)
+	document, err := selectProfile(document, profile)
+	if err != nil {
+		return nil, err
+	}
+	var instance = &Configuration{Profile: profile}
+	var problems []ConfigProblem
+	interpolateEnv(document, "", &instance.sources, &problems)
+	if len(problems) > 0 {
+		return nil, problemsError(problems)
+	}
+	if len(document.Content) > 0 {
+		if err = document.Decode(instance); err != nil {
+			return nil, err
+		}
+	}
+	if instance.Db.DbType == "" && instance.Db.URL != "" {
+		instance.Db.DbType = dbTypeOfURL(instance.Db.URL)
+	}
+	if err = resolveCredentials(&instance.Db, baseDir, &instance.sources); err != nil {
+		return nil, err
+	}
+	if err = instance.Db.TLS.validate(instance.Db.DbType, baseDir); err != nil {
+		return nil, err
+	}
+	masking := instance.EffectiveMasking()
+	for i := range masking.Rules {
+		if err = masking.Rules[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = instance.ValueComparer(); err != nil {
+		return nil, err
+	}
+	if err = instance.KeyChanges.validate(); err != nil {
+		return nil, err
+	}
+	return instance, nil
+}
+
+// yamlファイルのプロファイルから構造体を生成
+func initializeYamlProfile(configFilePath string, profile string) (*Configuration, error) {
 	var buf []byte
 	var err error
 	if len(configFilePath) == 0 {
-		buf, err = ioutil.ReadFile(DefaultConfigFilePath)
-	} else {
-		buf, err = ioutil.ReadFile(configFilePath)
+		configFilePath = DefaultConfigFilePath
 	}
+	buf, err = ioutil.ReadFile(configFilePath)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
-	var instance = &Configuration{}
-	err = yaml.Unmarshal(buf, instance)
+	document := &yaml.Node{}
+	if err = yaml.Unmarshal(buf, document); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	// 未知のキーは互換性のためエラーにせず警告だけ出す(dbdiff config validateではエラー)
+	for _, problem := range checkUnknownKeys(document) {
+		log.Printf("Warning: %s\n", problem)
+	}
+	instance, err := decodeProfile(document, profile, filepath.Dir(configFilePath))
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
-	return instance, err
+	// 値は出さず、どこから設定されたかだけを出す
+	for _, source := range instance.sources {
+		log.Printf("Configuration %s is supplied by %s\n", source.Key, source.Source)
+	}
+	return instance, nil
 }
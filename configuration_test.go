@@ -1,9 +1,13 @@
 package dbdiff
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
-	"sync"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 const TestConfigPrefix string = "./testdata/configuration/"
@@ -103,7 +107,6 @@ func TestLoadConfiguration(t *testing.T) {
 			want:    &expectedConfig,
 			wantErr: false,
 			setup: func() {
-				onceYaml = sync.Once{}
 				instanceYaml = nil
 			},
 		},
@@ -113,20 +116,15 @@ func TestLoadConfiguration(t *testing.T) {
 			want:    nil,
 			wantErr: true,
 			setup: func() {
-				onceYaml = sync.Once{}
 				instanceYaml = nil
 			},
 		},
 		{
 			name:    "Already initialized",
-			args:    args{configFilePath: TestConfigPrefix + "test_config_notfound.yaml"},
-			want:    &Configuration{Db: Db{}},
+			args:    args{configFilePath: TestConfigPrefix + "test_config_normal.yaml"},
+			want:    &expectedConfig,
 			wantErr: false,
 			setup: func() {
-				onceYaml = sync.Once{}
-				onceYaml.Do(func() {
-					// nothing
-				})
 				instanceYaml = &Configuration{Db: Db{}}
 			},
 		},
@@ -145,3 +143,178 @@ func TestLoadConfiguration(t *testing.T) {
 		})
 	}
 }
+
+func TestConfiguration_GetTableConfig(t *testing.T) {
+	config, err := initializeYaml(TestConfigPrefix + "test_config_tables.yaml")
+	if err != nil {
+		t.Fatalf("initializeYaml() error = %v", err)
+	}
+	tests := []struct {
+		name      string
+		tableName string
+		want      TableConfig
+	}{
+		{"Configured", "users", TableConfig{TrackingColumn: "updated_at"}},
+		{"NotConfigured", "orders", TableConfig{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := config.GetTableConfig(tt.tableName); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetTableConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfiguration_Timeouts(t *testing.T) {
+	config, err := initializeYaml(TestConfigPrefix + "test_config_tables.yaml")
+	if err != nil {
+		t.Fatalf("initializeYaml() error = %v", err)
+	}
+	if config.Snapshot.QueryTimeout != 30*time.Second {
+		t.Errorf("QueryTimeout = %v, want %v", config.Snapshot.QueryTimeout, 30*time.Second)
+	}
+	if config.Snapshot.Timeout != 10*time.Minute {
+		t.Errorf("Timeout = %v, want %v", config.Snapshot.Timeout, 10*time.Minute)
+	}
+}
+
+func TestInitializeYaml_Secrets(t *testing.T) {
+	for name, value := range map[string]string{"DBDIFF_TEST_HOST": "dbhost", "DBDIFF_TEST_CHUNK_SIZE": "500"} {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+	os.Unsetenv("DBDIFF_TEST_PORT")
+
+	config, err := initializeYaml(TestConfigPrefix + "test_config_secrets.yaml")
+	if err != nil {
+		t.Fatalf("initializeYaml() error = %v", err)
+	}
+	wantDb := Db{DbType: "postgresql", Host: "dbhost", Port: "5432", User: "user1", Password: "pswd3", Name: "db_dbhost", PasswordFile: "password.txt"}
+	if !reflect.DeepEqual(config.Db, wantDb) {
+		t.Errorf("Db = %+v, want %+v", config.Db, wantDb)
+	}
+	if config.Snapshot.ChunkSize != 500 {
+		t.Errorf("ChunkSize = %v, want %v", config.Snapshot.ChunkSize, 500)
+	}
+	if config.Snapshot.Where != "note = '${literal}'" {
+		t.Errorf("Where = %v, want %v", config.Snapshot.Where, "note = '${literal}'")
+	}
+	wantSources := []ValueSource{
+		{"db.host", "environment variable DBDIFF_TEST_HOST"},
+		{"db.port", "environment variable DBDIFF_TEST_PORT"},
+		{"db.name", "environment variable DBDIFF_TEST_HOST"},
+		{"snapshot.chunk_size", "environment variable DBDIFF_TEST_CHUNK_SIZE"},
+		{"db.password", "password_file " + filepath.Join(TestConfigPrefix, "password.txt")},
+	}
+	if !reflect.DeepEqual(config.ValueSources(), wantSources) {
+		t.Errorf("ValueSources() = %v, want %v", config.ValueSources(), wantSources)
+	}
+
+	os.Unsetenv("DBDIFF_TEST_HOST")
+	if _, err := initializeYaml(TestConfigPrefix + "test_config_secrets.yaml"); err == nil {
+		t.Errorf("initializeYaml() error = nil, want error of unset variable")
+	}
+}
+
+func Test_resolveCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		db       Db
+		wantUser string
+		wantPass string
+		wantErr  bool
+	}{
+		{"Plain", Db{User: "user1", Password: "pass"}, "user1", "pass", false},
+		{"Command", Db{User: "user1", PasswordCommand: "echo secret"}, "user1", "secret", false},
+		{"CommandFailed", Db{User: "user1", PasswordCommand: "exit 1"}, "user1", "", true},
+		{"Both", Db{Password: "pass", PasswordFile: "password.txt"}, "", "pass", true},
+		{"Pgpass", Db{DbType: "postgresql", Host: "dbhost", User: "user1", CredentialsFile: "test_pgpass"}, "user1", "pass:word", false},
+		{"PgpassWildcard", Db{DbType: "postgresql", Host: "otherhost", User: "user2", CredentialsFile: "test_pgpass"}, "user2", "any", false},
+		{"PgpassUser", Db{DbType: "postgresql", Host: "dbhost", CredentialsFile: "test_pgpass"}, "user1", "pass:word", false},
+		{"MyCnf", Db{DbType: "mysql", CredentialsFile: "test_my.cnf"}, "user1", "pass word", false},
+		{"MyCnfOtherUser", Db{DbType: "mysql", User: "user2", CredentialsFile: "test_my.cnf"}, "user2", "", false},
+		{"NotFound", Db{DbType: "mysql", CredentialsFile: "notfound.cnf"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.db.PasswordCommand != "" && runtime.GOOS == "windows" {
+				t.Skip("sh is not available")
+			}
+			var sources []ValueSource
+			err := resolveCredentials(&tt.db, TestConfigPrefix, &sources)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tt.db.User != tt.wantUser || tt.db.Password != tt.wantPass {
+				t.Errorf("resolveCredentials() = %v/%v, want %v/%v", tt.db.User, tt.db.Password, tt.wantUser, tt.wantPass)
+			}
+			for _, source := range sources {
+				if tt.wantPass != "" && strings.Contains(source.Source, tt.wantPass) {
+					t.Errorf("source %v contains the password", source)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	configFilePath := TestConfigPrefix + "test_config_profiles.yaml"
+	users := TableConfig{TrackingColumn: "updated_at"}
+	tests := []struct {
+		name    string
+		profile string
+		want    *Configuration
+		wantErr bool
+	}{
+		{
+			name: "TopLevel",
+			want: &Configuration{
+				Db:       Db{DbType: "postgresql", Host: "localhost", Port: "5432", User: "user1", Password: "pswd2", Name: "dbname"},
+				Snapshot: Snapshot{ChecksumPrecheck: true},
+				Tables:   map[string]TableConfig{"users": users},
+			},
+		},
+		{
+			// 他のプロファイルの環境変数は未設定でもよい
+			name:    "Profile",
+			profile: "qa",
+			want: &Configuration{
+				Db:       Db{DbType: "mysql", Host: "qa.example.com", Port: "3306", User: "user1", Password: "pswd4", Name: "dbname"},
+				Snapshot: Snapshot{ChecksumPrecheck: true},
+				Tables:   map[string]TableConfig{"users": users, "orders": {Where: "id > 100"}},
+				Profile:  "qa",
+			},
+		},
+		{name: "UnsetVariable", profile: "staging", wantErr: true},
+		{name: "NotDefined", profile: "prod", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadProfile(configFilePath, tt.profile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadProfile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// 複数のプロファイルを同じプロセスで読み込める
+	os.Setenv("DBDIFF_TEST_STAGING_PASSWORD", "pswd3")
+	defer os.Unsetenv("DBDIFF_TEST_STAGING_PASSWORD")
+	staging, err := LoadProfileFile(configFilePath, "staging")
+	if err != nil {
+		t.Fatalf("LoadProfileFile() error = %v", err)
+	}
+	if staging.Db.Host != "staging.example.com" || staging.Db.Password != "pswd3" || staging.Snapshot.ChecksumPrecheck {
+		t.Errorf("LoadProfileFile() = %+v", staging)
+	}
+	if current, _ := GetConfiguration(); current.Profile != "qa" {
+		t.Errorf("GetConfiguration().Profile = %v, want %v", current.Profile, "qa")
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
-	"log"
 	"sync"
 	"time"
 )
@@ -23,43 +22,64 @@ func GetDBInstance(dbConfig *Db) (*DBManager, error) {
 
 	lock.Lock()
 	if holder.closed {
-		var connStr string
-		var driverName string
-		switch dbConfig.DbType {
-		case "postgresql":
-			const connStringPostgreSQL = "postgresql://%s:%s@%s:%s/%s"
-			fmt.Printf("Connect to ... "+connStringPostgreSQL+"\n", dbConfig.User, "********", dbConfig.Host, dbConfig.Port, dbConfig.Name)
-			connStr = fmt.Sprintf(connStringPostgreSQL, dbConfig.User, dbConfig.Password, dbConfig.Host, dbConfig.Port, dbConfig.Name)
-			driverName = "pgx"
-		case "mysql":
-			// "username:password@protocol(address)/dbname"
-			const connStringMysql = "%s:%s@tcp(%s:%s)/%s"
-			fmt.Printf("Connect to ... "+connStringMysql+"\n", dbConfig.User, "********", dbConfig.Host, dbConfig.Port, dbConfig.Name)
-			connStr = fmt.Sprintf(connStringMysql, dbConfig.User, dbConfig.Password, dbConfig.Host, dbConfig.Port, dbConfig.Name)
-			driverName = "mysql"
-		case "mssql":
-			const connStringMSSql = "user id=%s;password=%s;server=%s;port=%s;database=%s;"
-			fmt.Printf("Connect to ... "+connStringMSSql+"\n", dbConfig.User, "********", dbConfig.Host, dbConfig.Port, dbConfig.Name)
-			connStr = fmt.Sprintf(connStringMSSql, dbConfig.User, dbConfig.Password, dbConfig.Host, dbConfig.Port, dbConfig.Name)
-			driverName = "sqlserver"
-		default:
-			err := errors.New("unknown DbType")
-			return nil, err
-		}
-		holder.db, err = sql.Open(driverName, connStr)
+		var db *sql.DB
+		db, err = openDB(dbConfig)
 		if err != nil {
-			log.Fatal("[DB] can not get DB", err)
-		}
-		// TODO avoid "unexpected EOF"...
-		if dbConfig.DbType == "mysql" {
-			holder.db.SetMaxIdleConns(0)
+			lock.Unlock()
+			return nil, err
 		}
+		holder.db = db
+		holder.closed = false
 	}
 	lock.Unlock()
 
 	return holder, err
 }
 
+// Open a new connection independent of GetDBInstance(), e.g. for the target database of a comparison.
+func OpenDB(dbConfig *Db) (*DBManager, error) {
+	db, err := openDB(dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &DBManager{db: db, closed: false}, nil
+}
+
+// Wrap an already opened *sql.DB, e.g. the connection of the caller's tests. Finalize() closes db.
+func NewDBManager(db *sql.DB) *DBManager {
+	return &DBManager{db: db, closed: false}
+}
+
+func openDB(dbConfig *Db) (*sql.DB, error) {
+	driverName, connStr, err := connectionString(dbConfig)
+	if err != nil {
+		var unsupported *ErrUnsupportedDialect
+		if errors.As(err, &unsupported) {
+			return nil, err
+		}
+		return nil, &ErrConnect{DbType: dbConfig.DbType, Host: dbConfig.Host, Name: dbConfig.Name, Err: err}
+	}
+	if dbConfig.DbType == "postgresql" {
+		if connStr, err = postgreSQLDriverConfig(&dbConfig.TLS, connStr); err != nil {
+			return nil, &ErrConnect{DbType: dbConfig.DbType, Host: dbConfig.Host, Name: dbConfig.Name, Err: err}
+		}
+	}
+	db, err := sql.Open(driverName, connStr)
+	if err != nil {
+		return nil, &ErrConnect{DbType: dbConfig.DbType, Host: dbConfig.Host, Name: dbConfig.Name, Err: err}
+	}
+	// sql.Open()は接続しないので、ここで接続できることを確認する
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, &ErrConnect{DbType: dbConfig.DbType, Host: dbConfig.Host, Name: dbConfig.Name, Err: err}
+	}
+	// TODO avoid "unexpected EOF"...
+	if dbConfig.DbType == "mysql" {
+		db.SetMaxIdleConns(0)
+	}
+	return db, nil
+}
+
 func (holder *DBManager) Finalize() error {
 	var err error
 	lock.Lock()
@@ -67,17 +87,16 @@ func (holder *DBManager) Finalize() error {
 		err = holder.db.Close()
 		holder.closed = true
 		if err != nil {
-			log.Fatal("[DB] can not get DB", err)
+			err = fmt.Errorf("[DB] can not close DB: %w", err)
 		}
 	}
 	lock.Unlock()
 	return err
 }
 
+// Does nothing, the connection is closed by Finalize().
 func (holder *DBManager) Close() error {
-	var err error
-	fmt.Println("DBManager:Close()")
-	return err
+	return nil
 }
 
 // Wrapper for sql.DB#PingContext()
@@ -122,7 +141,7 @@ func (holder *DBManager) Prepare(query string) (*sql.Stmt, error) {
 
 // Wrapper for sql.DB#ExecContext()
 func (holder *DBManager) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	return holder.db.ExecContext(ctx, query, args)
+	return holder.db.ExecContext(ctx, query, args...)
 }
 
 // Wrapper for sql.DB#Exec()
@@ -132,7 +151,7 @@ func (holder *DBManager) Exec(query string, args ...interface{}) (sql.Result, er
 
 // Wrapper for sql.DB#QueryContext()
 func (holder *DBManager) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	return holder.db.QueryContext(ctx, query, args)
+	return holder.db.QueryContext(ctx, query, args...)
 }
 
 // Wrapper for sql.DB#Query()
@@ -142,7 +161,7 @@ func (holder *DBManager) Query(query string, args ...interface{}) (*sql.Rows, er
 
 // Wrapper for sql.DB#QueryRowContext()
 func (holder *DBManager) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	return holder.db.QueryRowContext(ctx, query, args)
+	return holder.db.QueryRowContext(ctx, query, args...)
 }
 
 // Wrapper for sql.DB#QueryRow()
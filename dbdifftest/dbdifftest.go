@@ -0,0 +1,124 @@
+// Package dbdifftest provides helpers to assert the changes of a database in tests.
+//
+//	d := dbdifftest.Start(t, db)
+//	// run the code under test
+//	d.AssertChanges(t, dbdifftest.Expect().
+//		Table("users").Inserted(dbdifftest.Values{"name": "alice"}).
+//		Table("orders").Changed("status", "new", "paid"))
+//	d.AssertNoChanges(t, "audit_log")
+package dbdifftest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/stdlib"
+	"github.com/jparound30/dbdiff"
+	"strings"
+	"testing"
+)
+
+// Snapshot of a database taken by Start()
+type Diff struct {
+	db       dbdiff.DbHolder
+	config   *dbdiff.Configuration
+	tablePks map[string][]string
+	start    *dbdiff.AllTableStore
+}
+
+// Take a snapshot of all tables of db. The dbtype is detected by the driver of db.
+func Start(t testing.TB, db *sql.DB) *Diff {
+	t.Helper()
+	dbType, err := detectDbType(db)
+	if err != nil {
+		t.Fatalf("dbdifftest: %v", err)
+	}
+	return StartWithConfig(t, db, &dbdiff.Configuration{Db: dbdiff.Db{DbType: dbType}})
+}
+
+// Same as Start() with the configuration, e.g. to specify the schema or filters of tables.
+// The connection settings of config are not used.
+func StartWithConfig(t testing.TB, db *sql.DB, config *dbdiff.Configuration) *Diff {
+	t.Helper()
+	d := &Diff{db: dbdiff.NewDBManager(db), config: config}
+	ctx := context.Background()
+	tableNames, err := dbdiff.GetAllTables(ctx, d.db, config)
+	if err != nil {
+		t.Fatalf("dbdifftest: can not get tables: %v", err)
+	}
+	d.tablePks, err = dbdiff.GetPksOfTables(ctx, d.db, config, tableNames)
+	if err != nil {
+		t.Fatalf("dbdifftest: can not get primary keys: %v", err)
+	}
+	d.start = &dbdiff.AllTableStore{}
+	if err := d.start.CollectAllTableData(ctx, d.db, config, d.tablePks); err != nil {
+		t.Fatalf("dbdifftest: can not take snapshot: %v", err)
+	}
+	return d
+}
+
+// Changes since Start(), as dbdiff.AllTableStore#ExtractChangedData()
+func (d *Diff) Changes(t testing.TB) map[string][]*dbdiff.RowObject {
+	t.Helper()
+	current := &dbdiff.AllTableStore{}
+	if err := current.CollectAllTableData(context.Background(), d.db, d.config, d.tablePks); err != nil {
+		t.Fatalf("dbdifftest: can not take snapshot: %v", err)
+	}
+	return current.ExtractChangedData(d.start.Copy())
+}
+
+// Assert that the changes since Start() match expected(see Expect()). Reports the differences and returns false if not.
+func (d *Diff) AssertChanges(t testing.TB, expected Expecter) bool {
+	t.Helper()
+	changedData := d.Changes(t)
+	failures := expected.expectation().check(changedData, d.tablePks)
+	if len(failures) == 0 {
+		return true
+	}
+	t.Errorf("dbdifftest: changes do not match the expectation\n%s\nactual changes:\n%s",
+		strings.Join(failures, "\n"), d.format(changedData))
+	return false
+}
+
+// Assert that the tables are not changed since Start(). If no table is specified, all tables are checked.
+func (d *Diff) AssertNoChanges(t testing.TB, tables ...string) bool {
+	t.Helper()
+	changedData := d.Changes(t)
+	if len(tables) == 0 {
+		tables = dbdiff.SortedTableNames(changedData)
+	}
+	unexpected := map[string][]*dbdiff.RowObject{}
+	for _, tableName := range tables {
+		if rows := changedData[tableName]; len(rows) > 0 {
+			unexpected[tableName] = rows
+		}
+	}
+	if len(unexpected) == 0 {
+		return true
+	}
+	t.Errorf("dbdifftest: unexpected changes\n%s", d.format(unexpected))
+	return false
+}
+
+func (d *Diff) format(changedData map[string][]*dbdiff.RowObject) string {
+	builder := strings.Builder{}
+	if err := dbdiff.WriteTerminal(&builder, changedData, d.tablePks, dbdiff.TerminalOptions{}); err != nil {
+		return err.Error()
+	}
+	return builder.String()
+}
+
+func detectDbType(db *sql.DB) (string, error) {
+	switch db.Driver().(type) {
+	case *stdlib.Driver:
+		return "postgresql", nil
+	case *mysql.MySQLDriver:
+		return "mysql", nil
+	case *mssql.Driver:
+		return "mssql", nil
+	default:
+		return "", errors.New("unknown driver, use StartWithConfig() to specify the dbtype")
+	}
+}
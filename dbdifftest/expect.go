@@ -0,0 +1,253 @@
+package dbdifftest
+
+import (
+	"fmt"
+	"github.com/jparound30/dbdiff"
+	"sort"
+	"strings"
+)
+
+// Values of columns to match, formatted as dbdiff.ColumnScan#GetValueString()("<NULL>" for NULL).
+// Columns not in Values are not checked.
+type Values map[string]string
+
+// Change of a column value of an updated row
+type ColumnChange struct {
+	Column string
+	From   string
+	To     string
+}
+
+// Expected changes, passed to Diff#AssertChanges()
+type Expecter interface {
+	expectation() *Expectation
+}
+
+// Expected changes of tables.
+//
+// Tables not specified by Table() must not be changed, except the ignored tables.
+type Expectation struct {
+	tables  map[string]*TableExpectation
+	ignored map[string]struct{}
+}
+
+// Start building expected changes
+func Expect() *Expectation {
+	return &Expectation{tables: map[string]*TableExpectation{}, ignored: map[string]struct{}{}}
+}
+
+// Expected changes of the table. Without any expected row, the table must not be changed.
+func (e *Expectation) Table(name string) *TableExpectation {
+	te, ok := e.tables[name]
+	if !ok {
+		te = &TableExpectation{parent: e, name: name}
+		e.tables[name] = te
+	}
+	return te
+}
+
+// Do not check changes of the tables
+func (e *Expectation) Ignore(tables ...string) *Expectation {
+	for _, tableName := range tables {
+		e.ignored[tableName] = struct{}{}
+	}
+	return e
+}
+
+func (e *Expectation) expectation() *Expectation {
+	return e
+}
+
+// Expected changes of a table. Each expected row must match a distinct changed row, and all changed rows must be expected.
+type TableExpectation struct {
+	parent *Expectation
+	name   string
+	rows   []rowExpectation
+}
+
+// An inserted row having the values
+func (te *TableExpectation) Inserted(values Values) *TableExpectation {
+	te.rows = append(te.rows, rowExpectation{diffStatus: dbdiff.DiffStatusAdd, values: values})
+	return te
+}
+
+// A deleted row having the values
+func (te *TableExpectation) Deleted(values Values) *TableExpectation {
+	te.rows = append(te.rows, rowExpectation{diffStatus: dbdiff.DiffStatusDel, values: values})
+	return te
+}
+
+// An updated row having the values of where after the update, and whose columns are changed as changes.
+// Other columns may also be changed.
+func (te *TableExpectation) Updated(where Values, changes ...ColumnChange) *TableExpectation {
+	te.rows = append(te.rows, rowExpectation{diffStatus: dbdiff.DiffStatusMod, values: where, changes: changes})
+	return te
+}
+
+// An updated row whose column is changed from -> to
+func (te *TableExpectation) Changed(column string, from string, to string) *TableExpectation {
+	return te.Updated(nil, ColumnChange{Column: column, From: from, To: to})
+}
+
+// Expected changes of another table
+func (te *TableExpectation) Table(name string) *TableExpectation {
+	return te.parent.Table(name)
+}
+
+// Do not check changes of the tables
+func (te *TableExpectation) Ignore(tables ...string) *Expectation {
+	return te.parent.Ignore(tables...)
+}
+
+func (te *TableExpectation) expectation() *Expectation {
+	return te.parent
+}
+
+type rowExpectation struct {
+	diffStatus int8
+	values     Values
+	changes    []ColumnChange
+}
+
+func (re rowExpectation) String() string {
+	var s string
+	switch re.diffStatus {
+	case dbdiff.DiffStatusAdd:
+		s = "INSERTED"
+	case dbdiff.DiffStatusDel:
+		s = "DELETED"
+	default:
+		s = "UPDATED"
+	}
+	if len(re.values) > 0 {
+		s += " " + formatValues(re.values)
+	}
+	for _, change := range re.changes {
+		s += fmt.Sprintf(" %s: %s -> %s", change.Column, change.From, change.To)
+	}
+	return s
+}
+
+func (re rowExpectation) matches(change dbdiff.RowChange) bool {
+	if change.DiffStatus() != re.diffStatus {
+		return false
+	}
+	row := change.Row()
+	if re.diffStatus == dbdiff.DiffStatusMod {
+		if change.Before == nil || change.After == nil {
+			return false
+		}
+		row = change.After
+	}
+	for column, value := range re.values {
+		if v, ok := columnValue(row, column); !ok || v != value {
+			return false
+		}
+	}
+	for _, c := range re.changes {
+		from, ok := columnValue(change.Before, c.Column)
+		to, _ := columnValue(change.After, c.Column)
+		if !ok || from != c.From || to != c.To {
+			return false
+		}
+	}
+	return true
+}
+
+// Differences between the expectation and changedData, one line each
+func (e *Expectation) check(changedData map[string][]*dbdiff.RowObject, tablePks map[string][]string) []string {
+	tableNames := map[string]struct{}{}
+	for tableName := range e.tables {
+		tableNames[tableName] = struct{}{}
+	}
+	for tableName, rows := range changedData {
+		if len(rows) > 0 {
+			tableNames[tableName] = struct{}{}
+		}
+	}
+	sortedNames := make([]string, 0, len(tableNames))
+	for tableName := range tableNames {
+		if _, ok := e.ignored[tableName]; !ok {
+			sortedNames = append(sortedNames, tableName)
+		}
+	}
+	sort.Strings(sortedNames)
+
+	var failures []string
+	for _, tableName := range sortedNames {
+		var expected []rowExpectation
+		if te, ok := e.tables[tableName]; ok {
+			expected = te.rows
+		}
+		actual := dbdiff.GroupChanges(changedData[tableName])
+		missing, unexpected := matchChanges(expected, actual)
+		for _, i := range missing {
+			failures = append(failures, fmt.Sprintf("%s: missing %s", tableName, expected[i]))
+		}
+		for _, i := range unexpected {
+			change := actual[i]
+			failures = append(failures, fmt.Sprintf("%s: unexpected %s %s", tableName,
+				strings.TrimSpace(dbdiff.DiffStatusLabel(change.Row())), change.Row().KeyString(tablePks[tableName])))
+		}
+	}
+	return failures
+}
+
+// Match each expected row to a distinct actual change(maximum bipartite matching).
+// Returns the indexes of unmatched expected rows and unmatched actual changes.
+func matchChanges(expected []rowExpectation, actual []dbdiff.RowChange) (missing []int, unexpected []int) {
+	matchedExpected := make([]int, len(actual))
+	for i := range matchedExpected {
+		matchedExpected[i] = -1
+	}
+	var augment func(e int, visited []bool) bool
+	augment = func(e int, visited []bool) bool {
+		for a := range actual {
+			if visited[a] || !expected[e].matches(actual[a]) {
+				continue
+			}
+			visited[a] = true
+			if matchedExpected[a] < 0 || augment(matchedExpected[a], visited) {
+				matchedExpected[a] = e
+				return true
+			}
+		}
+		return false
+	}
+	for e := range expected {
+		if !augment(e, make([]bool, len(actual))) {
+			missing = append(missing, e)
+		}
+	}
+	for a, e := range matchedExpected {
+		if e < 0 {
+			unexpected = append(unexpected, a)
+		}
+	}
+	return missing, unexpected
+}
+
+func columnValue(row *dbdiff.RowObject, column string) (string, bool) {
+	if row == nil {
+		return "", false
+	}
+	for i, name := range row.ColumnNames {
+		if strings.EqualFold(name, column) {
+			return row.ColScans[i].GetValueString(), true
+		}
+	}
+	return "", false
+}
+
+// Values like "{id: 1, name: alice}" ordered by column name
+func formatValues(values Values) string {
+	columns := make([]string, 0, len(values))
+	for column := range values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for i, column := range columns {
+		columns[i] = column + ": " + values[column]
+	}
+	return "{" + strings.Join(columns, ", ") + "}"
+}
@@ -0,0 +1,94 @@
+package dbdifftest
+
+import (
+	"database/sql"
+	"github.com/jparound30/dbdiff"
+	"reflect"
+	"testing"
+)
+
+func newRow(diffStatus int8, isBeforeData bool, values ...string) *dbdiff.RowObject {
+	var colScans []*dbdiff.ColumnScan
+	for _, v := range values {
+		colScans = append(colScans, &dbdiff.ColumnScan{Value: &sql.NullString{String: v, Valid: true}})
+	}
+	return &dbdiff.RowObject{DiffStatus: diffStatus, IsBeforeData: isBeforeData, ColumnNames: []string{"id", "name", "status"}, ColScans: colScans}
+}
+
+func TestExpectation_check(t *testing.T) {
+	changedData := map[string][]*dbdiff.RowObject{
+		"users": {
+			newRow(dbdiff.DiffStatusAdd, false, "2", "bob", "active"),
+		},
+		"orders": {
+			newRow(dbdiff.DiffStatusMod, true, "1", "book", "new"),
+			newRow(dbdiff.DiffStatusMod, false, "1", "book", "paid"),
+			newRow(dbdiff.DiffStatusDel, true, "3", "pen", "new"),
+		},
+		"audit_log": {
+			newRow(dbdiff.DiffStatusAdd, false, "9", "log", "ok"),
+		},
+	}
+	tablePks := map[string][]string{"users": {"id"}, "orders": {"id"}, "audit_log": {"id"}}
+
+	tests := []struct {
+		name     string
+		expected Expecter
+		want     []string
+	}{
+		{
+			name: "Match",
+			expected: Expect().Ignore("audit_log").
+				Table("users").Inserted(Values{"name": "bob"}).
+				Table("orders").Changed("status", "new", "paid").Deleted(Values{"id": "3"}),
+		},
+		{
+			name: "UpdatedWhere",
+			expected: Expect().Ignore("audit_log", "users").
+				Table("orders").Updated(Values{"id": "1"}, ColumnChange{"status", "new", "paid"}).Deleted(nil),
+		},
+		{
+			name: "Missing",
+			expected: Expect().Ignore("audit_log").
+				Table("users").Inserted(Values{"name": "bob"}).Inserted(Values{"name": "carol"}).
+				Table("orders").Changed("status", "new", "paid").Deleted(Values{"id": "3"}),
+			want: []string{"users: missing INSERTED {name: carol}"},
+		},
+		{
+			name: "WrongValue",
+			expected: Expect().Ignore("audit_log", "users").
+				Table("orders").Changed("status", "new", "shipped").Deleted(Values{"id": "3"}),
+			want: []string{"orders: missing UPDATED status: new -> shipped", "orders: unexpected UPD BEFORE id=1"},
+		},
+		{
+			name: "NotExpectedTable",
+			expected: Expect().
+				Table("users").Inserted(Values{"name": "bob"}).
+				Table("orders").Changed("status", "new", "paid").Deleted(Values{"id": "3"}),
+			want: []string{"audit_log: unexpected INSERTED id=9"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.expected.expectation().check(changedData, tablePks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("check() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchChanges(t *testing.T) {
+	// 貪欲に割り当てると1つ目の期待値が2つ目の行を取ってしまう
+	actual := dbdiff.GroupChanges([]*dbdiff.RowObject{
+		newRow(dbdiff.DiffStatusAdd, false, "1", "alice", "active"),
+		newRow(dbdiff.DiffStatusAdd, false, "2", "bob", "active"),
+	})
+	expected := []rowExpectation{
+		{diffStatus: dbdiff.DiffStatusAdd, values: Values{"status": "active"}},
+		{diffStatus: dbdiff.DiffStatusAdd, values: Values{"name": "alice"}},
+	}
+	missing, unexpected := matchChanges(expected, actual)
+	if len(missing) != 0 || len(unexpected) != 0 {
+		t.Errorf("matchChanges() = %v, %v, want no unmatched", missing, unexpected)
+	}
+}
@@ -0,0 +1,115 @@
+package dbdifftest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/jparound30/dbdiff"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Flag of the test binary to write the golden files instead of comparing with them, e.g. "go test ./... -update".
+// It is not registered by this package, so that it does not conflict with the flag of the test package:
+// define it in the test package like
+//
+//	var _ = flag.Bool("update", false, "write the golden files")
+const UpdateGoldenFlag = "update"
+
+// Environment variable to write the golden files, used if the test binary does not define UpdateGoldenFlag
+const UpdateGoldenEnv = "DBDIFF_UPDATE_GOLDEN"
+
+func updateGolden() bool {
+	if f := flag.Lookup(UpdateGoldenFlag); f != nil {
+		update, _ := strconv.ParseBool(f.Value.String())
+		return update
+	}
+	update, _ := strconv.ParseBool(os.Getenv(UpdateGoldenEnv))
+	return update
+}
+
+// Assert that the changes since Start() are the same as goldenFile, after the values are normalized by rules.
+// The file is written as JSON if its extension is ".json", otherwise as text.
+//
+// Run tests with -update(see UpdateGoldenFlag) or DBDIFF_UPDATE_GOLDEN=1 to write the golden files.
+func (d *Diff) AssertGolden(t testing.TB, goldenFile string, rules ...dbdiff.NormalizeRule) bool {
+	t.Helper()
+	buf := bytes.Buffer{}
+	opts := dbdiff.GoldenOptions{JSON: strings.EqualFold(filepath.Ext(goldenFile), ".json"), Rules: rules}
+	if err := dbdiff.WriteGolden(&buf, d.Changes(t), d.tablePks, opts); err != nil {
+		t.Fatalf("dbdifftest: %v", err)
+	}
+
+	if updateGolden() {
+		if err := os.MkdirAll(filepath.Dir(goldenFile), 0755); err != nil {
+			t.Fatalf("dbdifftest: %v", err)
+		}
+		if err := ioutil.WriteFile(goldenFile, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("dbdifftest: %v", err)
+		}
+		return true
+	}
+
+	want, err := ioutil.ReadFile(goldenFile)
+	if os.IsNotExist(err) {
+		t.Errorf("dbdifftest: golden file %s does not exist, run go test with -%s or %s=1 to create it", goldenFile, UpdateGoldenFlag, UpdateGoldenEnv)
+		return false
+	}
+	if err != nil {
+		t.Fatalf("dbdifftest: %v", err)
+	}
+	if diff := compareGolden(string(want), buf.String()); diff != "" {
+		t.Errorf("dbdifftest: changes do not match golden file %s (-want +got)\n%s", goldenFile, diff)
+		return false
+	}
+	return true
+}
+
+// Line diff of want and got("-" for want only, "+" for got only), empty if they are the same.
+func compareGolden(want string, got string) string {
+	// Windowsでチェックアウトされたファイルの改行の違いは無視する
+	want = strings.ReplaceAll(want, "\r\n", "\n")
+	if want == got {
+		return ""
+	}
+	a := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	// 最長共通部分列
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	builder := strings.Builder{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&builder, "  %s\n", a[i])
+			i, j = i+1, j+1
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&builder, "- %s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&builder, "+ %s\n", b[j])
+			j++
+		}
+	}
+	return builder.String()
+}
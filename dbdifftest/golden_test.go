@@ -0,0 +1,46 @@
+package dbdifftest
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+var _ = flag.Bool(UpdateGoldenFlag, false, "write the golden files")
+
+func Test_compareGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		got  string
+		diff string
+	}{
+		{"Same", "a\nb\n", "a\nb\n", ""},
+		{"CRLF", "a\r\nb\r\n", "a\nb\n", ""},
+		{"Changed", "a\nb\nc\n", "a\nx\nc\n", "  a\n- b\n+ x\n  c\n"},
+		{"Added", "a\n", "a\nb\n", "  a\n+ b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareGolden(tt.want, tt.got); got != tt.diff {
+				t.Errorf("compareGolden() = %q, want %q", got, tt.diff)
+			}
+		})
+	}
+}
+
+func Test_updateGolden(t *testing.T) {
+	defer flag.Set(UpdateGoldenFlag, flag.Lookup(UpdateGoldenFlag).Value.String())
+	defer os.Setenv(UpdateGoldenEnv, os.Getenv(UpdateGoldenEnv))
+
+	// -updateが定義されていれば環境変数より優先する
+	os.Setenv(UpdateGoldenEnv, "1")
+	flag.Set(UpdateGoldenFlag, "false")
+	if updateGolden() {
+		t.Error("updateGolden() = true, want false")
+	}
+	flag.Set(UpdateGoldenFlag, "true")
+	if !updateGolden() {
+		t.Error("updateGolden() = false, want true")
+	}
+}
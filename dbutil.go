@@ -1,25 +1,29 @@
 package dbdiff
 
 import (
+	"context"
 	"database/sql"
-	"errors"
+	"fmt"
+	"time"
 )
 
 // Get all table name from db
-func GetAllTables(db DbHolder, config *Configuration) ([]string, error) {
+func GetAllTables(ctx context.Context, db DbHolder, config *Configuration) ([]string, error) {
 	var err error
 	var tableNames []string
 	var rows *sql.Rows
+	ctx, cancel := queryContext(ctx, config)
+	defer cancel()
 	switch config.Db.DbType {
 	case "postgresql":
-		rows, err = db.Query("SELECT relname AS TABLE_NAME FROM pg_stat_user_tables ORDER BY TABLE_NAME")
+		rows, err = db.QueryContext(ctx, "SELECT relname AS TABLE_NAME FROM pg_stat_user_tables ORDER BY TABLE_NAME")
 	case "mysql":
-		rows, err = db.Query("SELECT TABLE_NAME FROM information_schema.tables WHERE table_schema=database() ORDER BY TABLE_NAME")
+		rows, err = db.QueryContext(ctx, "SELECT TABLE_NAME FROM information_schema.tables WHERE table_schema=database() ORDER BY TABLE_NAME")
 	case "mssql":
-		rows, err = db.Query("SELECT name AS TABLENAME FROM sys.objects WHERE type = 'U' ORDER BY TABLENAME")
+		rows, err = db.QueryContext(ctx, "SELECT name AS TABLENAME FROM sys.objects WHERE type = 'U' ORDER BY TABLENAME")
 	default:
 		rows = nil
-		err = errors.New("unsupported dbtype [" + config.Db.DbType + "]")
+		err = &ErrUnsupportedDialect{DbType: config.Db.DbType}
 	}
 	if err != nil {
 		return []string{}, err
@@ -29,23 +33,27 @@ func GetAllTables(db DbHolder, config *Configuration) ([]string, error) {
 		var tableName string
 		err = rows.Scan(&tableName)
 		if err != nil {
+			rows.Close()
 			return []string{}, err
 		}
 		tableNames = append(tableNames, tableName)
 	}
 	rows.Close()
+	if err = rows.Err(); err != nil {
+		return []string{}, err
+	}
 	return tableNames, nil
 }
 
 // Get Primary key information
-func GetPksOfTables(db DbHolder, config *Configuration, tableNames []string) (map[string][]string, error) {
+func GetPksOfTables(ctx context.Context, db DbHolder, config *Configuration, tableNames []string) (map[string][]string, error) {
 	var err error
 	schema := config.Db.Schema
 
 	var stmt *sql.Stmt
 	switch config.Db.DbType {
 	case "postgresql":
-		stmt, err = db.Prepare(`
+		stmt, err = db.PrepareContext(ctx, `
 		SELECT
 		       kcu.ordinal_position AS PkOrder,
 		       ccu.column_name AS ColumnName
@@ -70,7 +78,7 @@ func GetPksOfTables(db DbHolder, config *Configuration, tableNames []string) (ma
 		    , kcu.ordinal_position
 		`)
 	case "mysql":
-		stmt, err = db.Prepare(`
+		stmt, err = db.PrepareContext(ctx, `
 		SELECT
 			ORDINAL_POSITION, COLUMN_NAME
 		FROM
@@ -83,7 +91,7 @@ func GetPksOfTables(db DbHolder, config *Configuration, tableNames []string) (ma
 			ORDINAL_POSITION
 		`)
 	case "mssql":
-		stmt, err = db.Prepare(`
+		stmt, err = db.PrepareContext(ctx, `
 		SELECT
 		       kcu.ordinal_position AS PkOrder,
 		       ccu.column_name AS ColumnName
@@ -107,8 +115,10 @@ func GetPksOfTables(db DbHolder, config *Configuration, tableNames []string) (ma
 		    , tb_con.constraint_name
 		    , kcu.ordinal_position
 		`)
+	default:
+		err = &ErrUnsupportedDialect{DbType: config.Db.DbType}
 	}
-	if err != nil || stmt == nil {
+	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
@@ -116,25 +126,13 @@ func GetPksOfTables(db DbHolder, config *Configuration, tableNames []string) (ma
 	// PK取得(ORDER BYに使う)
 	tablePks := make(map[string][]string, len(tableNames))
 	for _, tableName := range tableNames {
-		rows, err := stmt.Query(tableName)
+		columns, err := queryPkColumns(ctx, stmt, config, tableName)
 		if err != nil {
 			return nil, err
 		}
 
-		var columns []string
-		for rows.Next() {
-			var order int
-			var columnName string
-			err = rows.Scan(&order, &columnName)
-			if err != nil {
-				return nil, err
-			}
-			columns = append(columns, columnName)
-		}
-		rows.Close()
-
 		if len(columns) == 0 {
-			columns, err = GetAllColumnsOnTable(db, tableName, schema)
+			columns, err = GetAllColumnsOnTable(ctx, db, tableName, schema)
 			if err != nil {
 				return nil, err
 			}
@@ -145,11 +143,33 @@ func GetPksOfTables(db DbHolder, config *Configuration, tableNames []string) (ma
 	return tablePks, nil
 }
 
-func GetAllColumnsOnTable(db DbHolder, tableName string, schema string) ([]string, error) {
+func queryPkColumns(ctx context.Context, stmt *sql.Stmt, config *Configuration, tableName string) ([]string, error) {
+	ctx, cancel := queryContext(ctx, config)
+	defer cancel()
+	rows, err := stmt.QueryContext(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var order int
+		var columnName string
+		err = rows.Scan(&order, &columnName)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, columnName)
+	}
+	return columns, rows.Err()
+}
+
+func GetAllColumnsOnTable(ctx context.Context, db DbHolder, tableName string, schema string) ([]string, error) {
 	var columns []string
 
 	// Pkがないので、対象テーブルの全カラム情報を取得して全カラムPKとみなす
-	astQuery, err := db.Query("SELECT * FROM " + schema + tableName + " LIMIT 1")
+	astQuery, err := db.QueryContext(ctx, "SELECT * FROM "+schema+tableName+" LIMIT 1")
 	if err != nil {
 		return nil, err
 	}
@@ -164,3 +184,38 @@ func GetAllColumnsOnTable(db DbHolder, tableName string, schema string) ([]strin
 	}
 	return columns, nil
 }
+
+// Get column names of the table in ordinal order (works even if the table is empty)
+func GetColumnNames(ctx context.Context, db DbHolder, tableName string, schema string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT * FROM "+schema+tableName+" WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// Bind parameter placeholder of the n-th(1..) parameter
+func placeholder(dbType string, n int) string {
+	switch dbType {
+	case "postgresql":
+		return fmt.Sprintf("$%d", n)
+	case "mssql":
+		return fmt.Sprintf("@p%d", n)
+	default:
+		return "?"
+	}
+}
+
+// Context canceled after d. If d is not positive, ctx is returned as is.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// Context of a query(including reading its rows) limited by Snapshot.QueryTimeout
+func queryContext(ctx context.Context, config *Configuration) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, config.Snapshot.QueryTimeout)
+}
@@ -0,0 +1,310 @@
+package dbdiff
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const redactedPassword = "********"
+
+// Driver name and connection string of the database.
+//
+// If dsn or url is specified, it is used instead of host, port and name. user and password are added to it if specified,
+// and params are merged into it with the escaping of each driver.
+func connectionString(dbConfig *Db) (string, string, error) {
+	if dbConfig.DSN != "" && dbConfig.URL != "" {
+		return "", "", errors.New("only one of dsn and url can be specified")
+	}
+	switch dbConfig.DbType {
+	case "postgresql":
+		connStr, err := postgresqlConnectionString(dbConfig)
+		return "pgx", connStr, err
+	case "mysql":
+		connStr, err := mysqlConnectionString(dbConfig)
+		return "mysql", connStr, err
+	case "mssql":
+		connStr, err := mssqlConnectionString(dbConfig)
+		return "sqlserver", connStr, err
+	default:
+		return "", "", &ErrUnsupportedDialect{DbType: dbConfig.DbType}
+	}
+}
+
+// dbtype of the scheme of the url, empty if unknown
+func dbTypeOfURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	switch u.Scheme {
+	case "postgres", "postgresql":
+		return "postgresql"
+	case "mysql":
+		return "mysql"
+	case "sqlserver", "mssql":
+		return "mssql"
+	}
+	return ""
+}
+
+// URL(postgresql://...) or key=value style connection string of pgx
+func postgresqlConnectionString(dbConfig *Db) (string, error) {
+	source := dbConfig.DSN + dbConfig.URL
+	if source != "" && !strings.Contains(source, "://") {
+		// key=value形式: 後に書いた値が優先される
+		var buf strings.Builder
+		buf.WriteString(source)
+		for _, kv := range connectionParams(dbConfig, "user", "password") {
+			fmt.Fprintf(&buf, " %s='%s'", kv[0], strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(kv[1]))
+		}
+		return buf.String(), nil
+	}
+
+	u := &url.URL{Scheme: "postgresql", Host: joinHostPort(dbConfig.Host, dbConfig.Port), Path: "/" + dbConfig.Name}
+	query := url.Values{}
+	if source != "" {
+		var err error
+		if u, err = url.Parse(source); err != nil {
+			return "", fmt.Errorf("invalid url: %v", err)
+		}
+		query = u.Query()
+	} else if strings.HasPrefix(dbConfig.Host, "/") {
+		// Unixドメインソケットのディレクトリ
+		u.Host = ":" + dbConfig.Port
+		query.Set("host", dbConfig.Host)
+	}
+	setURLUser(u, dbConfig)
+	for _, kv := range connectionParams(dbConfig) {
+		query.Set(kv[0], kv[1])
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// DSN of go-sql-driver/mysql, "user:password@tcp(host:port)/dbname?param=value"
+func mysqlConnectionString(dbConfig *Db) (string, error) {
+	config := mysql.NewConfig()
+	var params [][2]string
+	switch {
+	case dbConfig.DSN != "":
+		var err error
+		if config, err = mysql.ParseDSN(dbConfig.DSN); err != nil {
+			return "", fmt.Errorf("invalid dsn: %v", err)
+		}
+	case dbConfig.URL != "":
+		u, err := url.Parse(dbConfig.URL)
+		if err != nil {
+			return "", fmt.Errorf("invalid url: %v", err)
+		}
+		config.Net = "tcp"
+		config.Addr = u.Host
+		if u.Port() == "" {
+			config.Addr = joinHostPort(u.Hostname(), "3306")
+		}
+		config.DBName = strings.TrimPrefix(u.Path, "/")
+		if u.User != nil {
+			config.User = u.User.Username()
+			config.Passwd, _ = u.User.Password()
+		}
+		params = sortedParams(u.Query())
+	default:
+		config.Net = "tcp"
+		config.Addr = joinHostPort(dbConfig.Host, dbConfig.Port)
+		if strings.HasPrefix(dbConfig.Host, "/") {
+			config.Net = "unix"
+			config.Addr = dbConfig.Host
+		}
+		config.DBName = dbConfig.Name
+	}
+	if dbConfig.User != "" {
+		config.User = dbConfig.User
+	}
+	if dbConfig.Password != "" {
+		config.Passwd = dbConfig.Password
+	}
+	if dbConfig.TLS.Mode != "" {
+		var err error
+		if config.TLSConfig, err = registerMySQLTLSConfig(&dbConfig.TLS, config.Addr); err != nil {
+			return "", err
+		}
+	}
+
+	var buf strings.Builder
+	buf.WriteString(config.FormatDSN())
+	separator := "?"
+	if strings.Contains(buf.String(), "?") {
+		separator = "&"
+	}
+	for _, kv := range append(params, connectionParams(dbConfig)...) {
+		buf.WriteString(separator + kv[0] + "=" + mysqlParamEscaper.Replace(kv[1]))
+		separator = "&"
+	}
+	// 不正なパラメータ(parseTime=abcなど)はここでエラーにする
+	if _, err := mysql.ParseDSN(buf.String()); err != nil {
+		return "", fmt.Errorf("invalid params: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// go-sql-driver/mysqlはパラメータによってQueryUnescapeしたりしなかったりするので、
+// 区切り文字('&', '=', '/'など)と'%', '+'(QueryUnescapeで空白になる)だけをエスケープする
+var mysqlParamEscaper = strings.NewReplacer("%", "%25", "&", "%26", "+", "%2B", "=", "%3D", " ", "%20", "/", "%2F")
+
+// URL(sqlserver://...), ADO("key=value;") or ODBC("odbc:key={value};") style connection string of go-mssqldb
+func mssqlConnectionString(dbConfig *Db) (string, error) {
+	source := dbConfig.DSN + dbConfig.URL
+	if source != "" && !strings.Contains(source, "://") {
+		odbc := strings.HasPrefix(source, "odbc:")
+		var buf strings.Builder
+		buf.WriteString(strings.TrimSuffix(source, ";"))
+		for _, kv := range connectionParams(dbConfig, "user id", "password") {
+			value := kv[1]
+			if odbc {
+				value = "{" + strings.Replace(value, "}", "}}", -1) + "}"
+			} else if strings.Contains(value, ";") {
+				return "", fmt.Errorf("%s can not contain ';' in ADO style dsn, use url or odbc: style instead", kv[0])
+			}
+			fmt.Fprintf(&buf, ";%s=%s", kv[0], value)
+		}
+		return buf.String(), nil
+	}
+
+	u := &url.URL{Scheme: "sqlserver", Host: joinHostPort(dbConfig.Host, dbConfig.Port)}
+	query := url.Values{}
+	if source != "" {
+		var err error
+		if u, err = url.Parse(source); err != nil {
+			return "", fmt.Errorf("invalid url: %v", err)
+		}
+		u.Scheme = "sqlserver"
+		query = u.Query()
+	} else {
+		// 名前付きインスタンス(HOST\INSTANCE)
+		if i := strings.Index(dbConfig.Host, `\`); i >= 0 {
+			u.Host = joinHostPort(dbConfig.Host[:i], dbConfig.Port)
+			u.Path = "/" + dbConfig.Host[i+1:]
+		}
+		if dbConfig.Name != "" {
+			query.Set("database", dbConfig.Name)
+		}
+	}
+	setURLUser(u, dbConfig)
+	for _, kv := range connectionParams(dbConfig) {
+		query.Set(kv[0], kv[1])
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func joinHostPort(host string, port string) string {
+	if port == "" {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func setURLUser(u *url.URL, dbConfig *Db) {
+	user, password := dbConfig.User, dbConfig.Password
+	if u.User != nil {
+		if user == "" {
+			user = u.User.Username()
+		}
+		if p, ok := u.User.Password(); ok && password == "" {
+			password = p
+		}
+	}
+	switch {
+	case password != "":
+		u.User = url.UserPassword(user, password)
+	case user != "":
+		u.User = url.User(user)
+	}
+}
+
+// params(and the parameters of the TLS configuration) ordered by key, preceded by user and password with the keys if specified
+func connectionParams(dbConfig *Db, credentialKeys ...string) [][2]string {
+	var params [][2]string
+	if len(credentialKeys) == 2 {
+		if dbConfig.User != "" {
+			params = append(params, [2]string{credentialKeys[0], dbConfig.User})
+		}
+		if dbConfig.Password != "" {
+			params = append(params, [2]string{credentialKeys[1], dbConfig.Password})
+		}
+	}
+	values := url.Values{}
+	for key, value := range tlsParams(dbConfig) {
+		values.Set(key, value)
+	}
+	for key, value := range dbConfig.Params {
+		values.Set(key, value)
+	}
+	return append(params, sortedParams(values)...)
+}
+
+func sortedParams(values url.Values) [][2]string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	params := make([][2]string, 0, len(keys))
+	for _, key := range keys {
+		params = append(params, [2]string{key, values.Get(key)})
+	}
+	return params
+}
+
+// Patterns of password=..., pwd=... in connection strings
+var (
+	urlPasswordPattern  = regexp.MustCompile(`(?i)((?:^|&)(?:password|pwd)=)[^&]*`)
+	pgPasswordPattern   = regexp.MustCompile(`(?i)((?:^|\s)password\s*=\s*)('(?:\\.|[^'\\])*'|(?:\\.|\S)*)`)
+	adoPasswordPattern  = regexp.MustCompile(`(?i)((?:^|;|odbc:)\s*(?:password|pwd)\s*=\s*)(\{(?:\}\}|[^}])*\}|[^;]*)`)
+	passwordReplacement = "${1}" + redactedPassword
+)
+
+// Connection string of the database with the password replaced by "********", to be printed.
+// Empty if the connection string can not be built.
+func (dbConfig *Db) RedactedConnectionString() string {
+	_, connStr, err := connectionString(dbConfig)
+	if err != nil {
+		return ""
+	}
+	return redactConnectionString(dbConfig.DbType, connStr)
+}
+
+// Connection string with the password replaced by "********", to be printed
+func redactConnectionString(dbType string, connStr string) string {
+	if strings.Contains(connStr, "://") {
+		u, err := url.Parse(connStr)
+		if err != nil {
+			return "(invalid url)"
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedPassword)
+		}
+		u.RawQuery = urlPasswordPattern.ReplaceAllString(u.RawQuery, passwordReplacement)
+		return u.String()
+	}
+	switch dbType {
+	case "mysql":
+		config, err := mysql.ParseDSN(connStr)
+		if err != nil {
+			return "(invalid dsn)"
+		}
+		if prefix := config.User + ":" + config.Passwd + "@"; config.Passwd != "" && strings.HasPrefix(connStr, prefix) {
+			return config.User + ":" + redactedPassword + "@" + connStr[len(prefix):]
+		}
+		return connStr
+	case "mssql":
+		return adoPasswordPattern.ReplaceAllString(connStr, passwordReplacement)
+	default:
+		return pgPasswordPattern.ReplaceAllString(connStr, passwordReplacement)
+	}
+}
@@ -0,0 +1,157 @@
+package dbdiff
+
+import (
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_connectionString_PostgreSQL(t *testing.T) {
+	tests := []struct {
+		name     string
+		db       Db
+		wantHost string
+		wantPass string
+		wantApp  string
+	}{
+		{"Fields", Db{Host: "db", Port: "5432", User: "user1", Password: "p@ss/w:rd", Name: "dbname", Params: map[string]string{"application_name": "dbdiff test"}}, "db", "p@ss/w:rd", "dbdiff test"},
+		{"Socket", Db{Host: "/var/run/postgresql", Port: "5432", User: "user1", Password: "pass", Name: "dbname"}, "/var/run/postgresql", "pass", ""},
+		{"URL", Db{URL: "postgres://user1@db/dbname?application_name=app", Password: "p'ss"}, "db", "p'ss", "app"},
+		{"DSN", Db{DSN: "host=db dbname=dbname", User: "user1", Password: `it's \ me`, Params: map[string]string{"application_name": "a b"}}, "db", `it's \ me`, "a b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.db.DbType = "postgresql"
+			_, connStr, err := connectionString(&tt.db)
+			if err != nil {
+				t.Fatalf("connectionString() error = %v", err)
+			}
+			config, err := pgx.ParseConnectionString(connStr)
+			if err != nil {
+				t.Fatalf("ParseConnectionString(%v) error = %v", connStr, err)
+			}
+			if config.Host != tt.wantHost || config.User != "user1" || config.Password != tt.wantPass || config.Database != "dbname" {
+				t.Errorf("ParseConnectionString(%v) = %v@%v/%v", connStr, config.User, config.Host, config.Database)
+			}
+			if config.Password != tt.wantPass {
+				t.Errorf("Password = %v, want %v", config.Password, tt.wantPass)
+			}
+			if got := config.RuntimeParams["application_name"]; got != tt.wantApp {
+				t.Errorf("application_name = %v, want %v", got, tt.wantApp)
+			}
+			if redacted := redactConnectionString("postgresql", connStr); strings.Contains(redacted, tt.wantPass) {
+				t.Errorf("redactConnectionString() = %v, contains the password", redacted)
+			}
+		})
+	}
+}
+
+func Test_connectionString_MySQL(t *testing.T) {
+	tests := []struct {
+		name     string
+		db       Db
+		wantNet  string
+		wantAddr string
+	}{
+		{"Fields", Db{Host: "db", Port: "3306", User: "user1", Password: "p@ss/w:rd", Name: "dbname"}, "tcp", "db:3306"},
+		{"Socket", Db{Host: "/tmp/mysql.sock", User: "user1", Password: "p@ss/w:rd", Name: "dbname"}, "unix", "/tmp/mysql.sock"},
+		{"URL", Db{URL: "mysql://user1:p%40ss%2Fw:rd@db/dbname?charset=utf8mb4"}, "tcp", "db:3306"},
+		{"DSN", Db{DSN: "user1@tcp(db:3307)/dbname", Password: "p@ss/w:rd"}, "tcp", "db:3307"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.db.DbType = "mysql"
+			if tt.db.Params == nil {
+				tt.db.Params = map[string]string{"parseTime": "true", "time_zone": "'+09:00'"}
+			}
+			_, connStr, err := connectionString(&tt.db)
+			if err != nil {
+				t.Fatalf("connectionString() error = %v", err)
+			}
+			config, err := mysql.ParseDSN(connStr)
+			if err != nil {
+				t.Fatalf("ParseDSN(%v) error = %v", connStr, err)
+			}
+			if config.Net != tt.wantNet || config.Addr != tt.wantAddr || config.User != "user1" || config.Passwd != "p@ss/w:rd" || config.DBName != "dbname" {
+				t.Errorf("ParseDSN(%v) = %+v", connStr, config)
+			}
+			if !config.ParseTime || config.Params["time_zone"] != "'+09:00'" {
+				t.Errorf("ParseDSN(%v) params = %v, %v", connStr, config.ParseTime, config.Params)
+			}
+			if redacted := redactConnectionString("mysql", connStr); strings.Contains(redacted, "p@ss") || !strings.Contains(redacted, redactedPassword) {
+				t.Errorf("redactConnectionString() = %v", redacted)
+			}
+		})
+	}
+}
+
+func Test_connectionString_MSSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		db   Db
+		want string
+	}{
+		{"Fields", Db{Host: "db", Port: "1433", User: "sa", Password: "p@ss;w/rd", Name: "dbname"},
+			"sqlserver://sa:p%40ss;w%2Frd@db:1433?database=dbname&encrypt=disable"},
+		{"NamedInstance", Db{Host: `db\SQLEXPRESS`, User: "sa", Password: "s3cret", Name: "dbname"},
+			"sqlserver://sa:s3cret@db/SQLEXPRESS?database=dbname&encrypt=disable"},
+		{"ADO", Db{DSN: "server=db;database=dbname;", User: "sa", Password: "s3cret"},
+			"server=db;database=dbname;user id=sa;password=s3cret;encrypt=disable"},
+		{"ODBC", Db{DSN: "odbc:server=db;database=dbname", User: "sa", Password: "p;ss}"},
+			"odbc:server=db;database=dbname;user id={sa};password={p;ss}}};encrypt={disable}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.db.DbType = "mssql"
+			tt.db.Params = map[string]string{"encrypt": "disable"}
+			_, connStr, err := connectionString(&tt.db)
+			if err != nil {
+				t.Fatalf("connectionString() error = %v", err)
+			}
+			if connStr != tt.want {
+				t.Errorf("connectionString() = %v, want %v", connStr, tt.want)
+			}
+			if redacted := redactConnectionString("mssql", connStr); strings.Contains(redacted, tt.db.Password) || strings.Contains(redacted, url.QueryEscape(tt.db.Password)) {
+				t.Errorf("redactConnectionString() = %v", redacted)
+			}
+		})
+	}
+
+	_, _, err := connectionString(&Db{DbType: "mssql", DSN: "server=db", Password: "p;ss"})
+	if err == nil {
+		t.Errorf("connectionString() error = nil, want error of ';' in ADO style dsn")
+	}
+}
+
+func Test_redactConnectionString(t *testing.T) {
+	tests := []struct {
+		name    string
+		dbType  string
+		connStr string
+		want    string
+	}{
+		{"URLQuery", "postgresql", "postgresql://db/dbname?password=secret&sslmode=disable", "postgresql://db/dbname?password=********&sslmode=disable"},
+		{"PgQuoted", "postgresql", `host=db password='se cr\'et' dbname=x`, "host=db password=******** dbname=x"},
+		{"ADO", "mssql", "server=db;Password=se cret;database=x", "server=db;Password=********;database=x"},
+		{"NoPassword", "mysql", "user@tcp(db:3306)/x", "user@tcp(db:3306)/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactConnectionString(tt.dbType, tt.connStr); got != tt.want {
+				t.Errorf("redactConnectionString() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDb_RedactedConnectionString(t *testing.T) {
+	dbConfig := &Db{DbType: "postgresql", DSN: "host=db password=secret dbname=x"}
+	if got, want := dbConfig.RedactedConnectionString(), "host=db password=******** dbname=x"; got != want {
+		t.Errorf("RedactedConnectionString() = %v, want %v", got, want)
+	}
+	if got := (&Db{DbType: "oracle"}).RedactedConnectionString(); got != "" {
+		t.Errorf("RedactedConnectionString() = %v, want empty", got)
+	}
+}
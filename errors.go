@@ -0,0 +1,45 @@
+package dbdiff
+
+import "fmt"
+
+// Error for dbtype of the configuration which is not supported.
+//
+//	var unsupported *dbdiff.ErrUnsupportedDialect
+//	if errors.As(err, &unsupported) { ... }
+type ErrUnsupportedDialect struct {
+	DbType string
+}
+
+func (e *ErrUnsupportedDialect) Error() string {
+	return "unsupported dbtype [" + e.DbType + "]"
+}
+
+// Error of connecting to the database
+type ErrConnect struct {
+	DbType string
+	Host   string
+	Name   string
+	Err    error
+}
+
+func (e *ErrConnect) Error() string {
+	return fmt.Sprintf("can not connect to %s database %s on %s: %v", e.DbType, e.Name, e.Host, e.Err)
+}
+
+func (e *ErrConnect) Unwrap() error {
+	return e.Err
+}
+
+// Error of collecting rows of a table
+type ErrCollectTable struct {
+	Table string
+	Err   error
+}
+
+func (e *ErrCollectTable) Error() string {
+	return fmt.Sprintf("%s: %v", e.Table, e.Err)
+}
+
+func (e *ErrCollectTable) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,80 @@
+package dbdiff
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrCollectTable(t *testing.T) {
+	err := fmt.Errorf("collect: %w", &ErrCollectTable{Table: "users", Err: context.DeadlineExceeded})
+
+	var collectErr *ErrCollectTable
+	if !errors.As(err, &collectErr) {
+		t.Fatalf("errors.As() = false, want true")
+	}
+	if collectErr.Table != "users" {
+		t.Errorf("Table = %v, want %v", collectErr.Table, "users")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("errors.Is(err, context.DeadlineExceeded) = false, want true")
+	}
+}
+
+func TestOpenDB_UnsupportedDialect(t *testing.T) {
+	_, err := OpenDB(&Db{DbType: "oracle"})
+	var unsupported *ErrUnsupportedDialect
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("OpenDB() error = %v, want *ErrUnsupportedDialect", err)
+	}
+	if unsupported.DbType != "oracle" {
+		t.Errorf("DbType = %v, want %v", unsupported.DbType, "oracle")
+	}
+}
+
+func TestColumnScan_GetValueString(t *testing.T) {
+	tests := []struct {
+		name  string
+		value sql.Scanner
+		want  string
+	}{
+		{"NullString", &sql.NullString{String: "abc", Valid: true}, "abc"},
+		{"NullStringNull", &sql.NullString{}, "<NULL>"},
+		{"NullInt64", &sql.NullInt64{Int64: 42, Valid: true}, "42"},
+		{"NullInt64Null", &sql.NullInt64{}, "<NULL>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := &ColumnScan{Value: tt.value}
+			if got := rs.GetValueString(); got != tt.want {
+				t.Errorf("GetValueString() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllTableStore_ExtractChangedData_FailedTables(t *testing.T) {
+	columns := []string{"id", "name"}
+	before := &AllTableStore{
+		AllData: map[string]map[string]*RowObject{
+			"users":  {"1": newTestRow(DiffStatusInit, false, columns, []string{"1", "alice"})},
+			"orders": {"1": newTestRow(DiffStatusInit, false, columns, []string{"1", "book"})},
+		},
+	}
+	after := &AllTableStore{
+		AllData: map[string]map[string]*RowObject{
+			"users": {"1": newTestRow(DiffStatusInit, false, columns, []string{"1", "bob"})},
+		},
+		FailedTables: map[string]*ErrCollectTable{"orders": {Table: "orders", Err: errors.New("timeout")}},
+	}
+
+	changedData := after.ExtractChangedData(before)
+	if _, ok := changedData["orders"]; ok {
+		t.Errorf("failed table orders is compared: %v", changedData["orders"])
+	}
+	if got := SummarizeChanges(changedData["users"]).Updated; got != 1 {
+		t.Errorf("Updated = %v, want %v", got, 1)
+	}
+}
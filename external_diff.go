@@ -0,0 +1,460 @@
+package dbdiff
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+const (
+	DefaultMemoryBudget = 256 * 1024 * 1024 // default memory budget(bytes) per table of SpillingTableStore
+
+	sortedRunFilePrefix   = "dbdiff_run_"
+	sortedRunReadBufSize  = 64 * 1024
+	sortedRunWriteBufSize = 1024 * 1024
+)
+
+// Options for SpillingTableStore
+type SpillOptions struct {
+	// Max estimated memory usage(bytes) of the rows of a table. Tables exceeding it are spilled into sorted files.
+	// 0 means DefaultMemoryBudget.
+	MemoryBudget int64
+	// Directory of the sorted files. os.TempDir() if empty.
+	TempDir string
+}
+
+// Snapshot which holds tables in memory, or in sorted files if they do not fit in the memory budget.
+//
+// Tables held in memory are compared as AllTableStore, and spilled tables are compared by merge-join of the rows
+// sorted by key, without holding all rows in memory.
+type SpillingTableStore struct {
+	AllColumn      map[string][]string
+	TotalDataCount uint64
+
+	tables             map[string]*spillingTable
+	options            SpillOptions
+	alreadyCollectData bool
+	comparer           *ValueComparer
+}
+
+type spillingTable struct {
+	pkColumns []string
+	rows      map[string]*RowObject // in memory
+	runs      []string              // sorted run files if spilled
+}
+
+func (st *spillingTable) spilled() bool {
+	return len(st.runs) > 0
+}
+
+func NewSpillingTableStore(options SpillOptions) *SpillingTableStore {
+	if options.MemoryBudget == 0 {
+		options.MemoryBudget = DefaultMemoryBudget
+	}
+	return &SpillingTableStore{options: options}
+}
+
+// Number of tables spilled into files
+func (sts *SpillingTableStore) SpilledTableCount() int {
+	count := 0
+	for _, table := range sts.tables {
+		if table.spilled() {
+			count++
+		}
+	}
+	return count
+}
+
+// Collect data of all tables. See AllTableStore#CollectAllTableData() about ctx.
+func (sts *SpillingTableStore) Collect(ctx context.Context, db DbHolder, config *Configuration, tablePks map[string][]string) error {
+	if sts.alreadyCollectData {
+		return errors.New("already collected data")
+	}
+	ctx, cancel := withTimeout(ctx, config.Snapshot.Timeout)
+	defer cancel()
+	if err := ResolveTableFilters(ctx, db, config, tablePks); err != nil {
+		return err
+	}
+	var err error
+	if sts.comparer, err = config.ValueComparer(); err != nil {
+		return err
+	}
+	sts.AllColumn = map[string][]string{}
+	sts.tables = map[string]*spillingTable{}
+
+	for tableName, pkColumns := range tablePks {
+		table := &spillingTable{pkColumns: pkColumns}
+		sts.tables[tableName] = table
+
+		var buffer []*RowObject
+		var bufferSize int64
+		columns, err := scanTable(ctx, db, config, tableName, pkColumns, func(rowObject *RowObject) error {
+			sts.TotalDataCount++
+			buffer = append(buffer, rowObject)
+			bufferSize += estimateRowMemory(rowObject)
+			if bufferSize <= sts.options.MemoryBudget {
+				return nil
+			}
+			// 予算を超えたのでソートしてファイルに書き出す
+			if err := table.writeRun(buffer, sts.options.TempDir); err != nil {
+				return err
+			}
+			buffer = nil
+			bufferSize = 0
+			return nil
+		})
+		if err != nil {
+			return &ErrCollectTable{Table: tableName, Err: err}
+		}
+		sts.AllColumn[tableName] = columns
+
+		if table.spilled() {
+			if len(buffer) > 0 {
+				if err := table.writeRun(buffer, sts.options.TempDir); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		table.rows = make(map[string]*RowObject, len(buffer))
+		for _, rowObject := range buffer {
+			table.rows[rowObject.GetKey(pkColumns)] = rowObject
+		}
+	}
+
+	sts.alreadyCollectData = true
+	return nil
+}
+
+// Same as AllTableStore#ExtractChangedData(), the receiver must be the after data.
+func (sts *SpillingTableStore) ExtractChangedData(beforeData *SpillingTableStore) (map[string][]*RowObject, error) {
+	var output = map[string][]*RowObject{}
+
+	for tableName, beforeTable := range beforeData.tables {
+		afterTable, ok := sts.tables[tableName]
+		if !ok {
+			afterTable = &spillingTable{pkColumns: beforeTable.pkColumns, rows: map[string]*RowObject{}}
+		}
+
+		if !beforeTable.spilled() && !afterTable.spilled() {
+			before := &AllTableStore{AllData: map[string]map[string]*RowObject{tableName: beforeTable.rows}}
+			after := &AllTableStore{AllData: map[string]map[string]*RowObject{tableName: afterTable.rows}, comparer: sts.comparer}
+			output[tableName] = after.ExtractChangedData(before)[tableName]
+			continue
+		}
+
+		outputTableData, err := mergeCompare(beforeTable, beforeData.AllColumn[tableName], afterTable, sts.AllColumn[tableName], sts.comparer.ForTable(tableName))
+		if err != nil {
+			return nil, err
+		}
+		output[tableName] = outputTableData
+	}
+	return output, nil
+}
+
+// Remove the sorted files
+func (sts *SpillingTableStore) Close() error {
+	var firstErr error
+	for _, table := range sts.tables {
+		for _, run := range table.runs {
+			if err := os.Remove(run); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		table.runs = nil
+	}
+	return firstErr
+}
+
+func estimateRowMemory(rowObject *RowObject) int64 {
+	size := int64(rowObjectMemoryOverhead)
+	for _, col := range rowObject.ColScans {
+		size += columnMemoryOverhead + int64(len(col.GetValueString()))
+	}
+	return size
+}
+
+// Sort rows by key and write them into a new run file
+func (st *spillingTable) writeRun(rows []*RowObject, tempDir string) error {
+	pkIndexes := keyColumnIndexes(rows[0].ColumnNames, st.pkColumns)
+	sort.SliceStable(rows, func(i, j int) bool {
+		return compareKeys(sortKey(rows[i], pkIndexes), sortKey(rows[j], pkIndexes)) < 0
+	})
+
+	f, err := ioutil.TempFile(tempDir, sortedRunFilePrefix)
+	if err != nil {
+		return err
+	}
+	st.runs = append(st.runs, f.Name())
+	writer := bufio.NewWriterSize(f, sortedRunWriteBufSize)
+	var lenBuf [binary.MaxVarintLen64]byte
+	for _, rowObject := range rows {
+		buf := encodeRowValues(rowObject)
+		if _, err := writer.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(buf)))]); err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := writer.Write(buf); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Iterator of rows in key order. next() returns nil at the end.
+type sortedRowIterator interface {
+	next() (*RowObject, error)
+	close()
+}
+
+func (st *spillingTable) iterator(columns []string) (sortedRowIterator, error) {
+	pkIndexes := keyColumnIndexes(columns, st.pkColumns)
+	if !st.spilled() {
+		rows := make([]*RowObject, 0, len(st.rows))
+		for _, rowObject := range st.rows {
+			rows = append(rows, rowObject)
+		}
+		sort.Slice(rows, func(i, j int) bool {
+			return compareKeys(sortKey(rows[i], pkIndexes), sortKey(rows[j], pkIndexes)) < 0
+		})
+		return &sliceRowIterator{rows: rows}, nil
+	}
+
+	merge := &mergeRowIterator{pkIndexes: pkIndexes}
+	for _, run := range st.runs {
+		f, err := os.Open(run)
+		if err != nil {
+			merge.close()
+			return nil, err
+		}
+		runIterator := &runRowIterator{file: f, reader: bufio.NewReaderSize(f, sortedRunReadBufSize), columns: columns}
+		merge.runs = append(merge.runs, runIterator)
+		rowObject, err := runIterator.next()
+		if err != nil {
+			merge.close()
+			return nil, err
+		}
+		if rowObject != nil {
+			heap.Push(merge, &mergeHead{rowObject: rowObject, key: sortKey(rowObject, pkIndexes), run: runIterator})
+		}
+	}
+	return merge, nil
+}
+
+type sliceRowIterator struct {
+	rows []*RowObject
+}
+
+func (it *sliceRowIterator) next() (*RowObject, error) {
+	if len(it.rows) == 0 {
+		return nil, nil
+	}
+	rowObject := it.rows[0]
+	it.rows = it.rows[1:]
+	return rowObject, nil
+}
+
+func (it *sliceRowIterator) close() {}
+
+type runRowIterator struct {
+	file    *os.File
+	reader  *bufio.Reader
+	columns []string
+}
+
+func (it *runRowIterator) next() (*RowObject, error) {
+	length, err := binary.ReadUvarint(it.reader)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(it.reader, buf); err != nil {
+		return nil, err
+	}
+	colScans, err := decodeRowValues(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &RowObject{ColScans: colScans, DiffStatus: DiffStatusInit, ModifiedColumnIndex: []uint8{}, ColumnNames: it.columns, IsBeforeData: false}, nil
+}
+
+func (it *runRowIterator) close() {
+	it.file.Close()
+}
+
+// k-way merge of the sorted runs
+type mergeRowIterator struct {
+	pkIndexes []int
+	runs      []*runRowIterator
+	heads     []*mergeHead
+}
+
+type mergeHead struct {
+	rowObject *RowObject
+	key       []string
+	run       *runRowIterator
+}
+
+func (it *mergeRowIterator) Len() int { return len(it.heads) }
+func (it *mergeRowIterator) Less(i, j int) bool {
+	return compareKeys(it.heads[i].key, it.heads[j].key) < 0
+}
+func (it *mergeRowIterator) Swap(i, j int)      { it.heads[i], it.heads[j] = it.heads[j], it.heads[i] }
+func (it *mergeRowIterator) Push(x interface{}) { it.heads = append(it.heads, x.(*mergeHead)) }
+func (it *mergeRowIterator) Pop() interface{} {
+	head := it.heads[len(it.heads)-1]
+	it.heads = it.heads[:len(it.heads)-1]
+	return head
+}
+
+func (it *mergeRowIterator) next() (*RowObject, error) {
+	if len(it.heads) == 0 {
+		return nil, nil
+	}
+	head := it.heads[0]
+	rowObject := head.rowObject
+	nextRowObject, err := head.run.next()
+	if err != nil {
+		return nil, err
+	}
+	if nextRowObject == nil {
+		heap.Pop(it)
+	} else {
+		head.rowObject = nextRowObject
+		head.key = sortKey(nextRowObject, it.pkIndexes)
+		heap.Fix(it, 0)
+	}
+	return rowObject, nil
+}
+
+func (it *mergeRowIterator) close() {
+	for _, run := range it.runs {
+		run.close()
+	}
+}
+
+// Compare the rows of the tables in key order
+func mergeCompare(beforeTable *spillingTable, beforeColumns []string, afterTable *spillingTable, afterColumns []string, equal ColumnEqualFunc) ([]*RowObject, error) {
+	beforeIterator, err := beforeTable.iterator(beforeColumns)
+	if err != nil {
+		return nil, err
+	}
+	defer beforeIterator.close()
+	afterIterator, err := afterTable.iterator(afterColumns)
+	if err != nil {
+		return nil, err
+	}
+	defer afterIterator.close()
+
+	beforePkIndexes := keyColumnIndexes(beforeColumns, beforeTable.pkColumns)
+	afterPkIndexes := keyColumnIndexes(afterColumns, afterTable.pkColumns)
+
+	var outputTableData []*RowObject
+	beforeRowObject, err := beforeIterator.next()
+	if err != nil {
+		return nil, err
+	}
+	afterRowObject, err := afterIterator.next()
+	if err != nil {
+		return nil, err
+	}
+	for beforeRowObject != nil || afterRowObject != nil {
+		cmp := 0
+		switch {
+		case afterRowObject == nil:
+			cmp = -1
+		case beforeRowObject == nil:
+			cmp = 1
+		default:
+			cmp = compareKeys(sortKey(beforeRowObject, beforePkIndexes), sortKey(afterRowObject, afterPkIndexes))
+		}
+
+		if cmp <= 0 {
+			beforeRowObject.IsBeforeData = true
+		}
+		switch {
+		case cmp < 0:
+			// afterに要素がないので削除データ
+			beforeRowObject.DiffStatus = DiffStatusDel
+			outputTableData = append(outputTableData, beforeRowObject)
+		case cmp > 0:
+			// 追加されたデータ
+			afterRowObject.DiffStatus = DiffStatusAdd
+			outputTableData = append(outputTableData, afterRowObject)
+		case beforeRowObject.EqualColumnsWith(afterRowObject, equal):
+			// 一致する為変更なし
+		default:
+			// キーはあるが一致しないので変更
+			beforeRowObject.DiffStatus = DiffStatusMod
+			afterRowObject.DiffStatus = DiffStatusMod
+			outputTableData = append(outputTableData, beforeRowObject, afterRowObject)
+		}
+
+		if cmp <= 0 {
+			if beforeRowObject, err = beforeIterator.next(); err != nil {
+				return nil, err
+			}
+		}
+		if cmp >= 0 {
+			if afterRowObject, err = afterIterator.next(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return outputTableData, nil
+}
+
+// Indexes of pkColumns in columns. All columns if no pkColumns.
+func keyColumnIndexes(columns []string, pkColumns []string) []int {
+	var indexes []int
+	for _, pk := range pkColumns {
+		for index, col := range columns {
+			if col == pk {
+				indexes = append(indexes, index)
+				break
+			}
+		}
+	}
+	if len(indexes) == 0 {
+		for index := range columns {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+func sortKey(rowObject *RowObject, pkIndexes []int) []string {
+	key := make([]string, len(pkIndexes))
+	for i, index := range pkIndexes {
+		if index < len(rowObject.ColScans) {
+			key[i] = rowObject.ColScans[index].GetValueString()
+		}
+	}
+	return key
+}
+
+func compareKeys(a []string, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] < b[i] {
+			return -1
+		}
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,83 @@
+package dbdiff
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_mergeCompare(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "dbdiff_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	columns := []string{"id", "name"}
+	pkColumns := []string{"id"}
+	row := func(values ...string) *RowObject {
+		return newTestRow(DiffStatusInit, false, columns, values)
+	}
+
+	// before: 2つのrunに分割して書き出す
+	beforeTable := &spillingTable{pkColumns: pkColumns}
+	if err := beforeTable.writeRun([]*RowObject{row("3", "c"), row("1", "a")}, tempDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := beforeTable.writeRun([]*RowObject{row("4", "d"), row("2", "b")}, tempDir); err != nil {
+		t.Fatal(err)
+	}
+	afterTable := &spillingTable{pkColumns: pkColumns, rows: map[string]*RowObject{
+		"1": row("1", "a"),
+		"2": row("2", "B"),
+		"4": row("4", "d"),
+		"5": row("5", "e"),
+	}}
+
+	got, err := mergeCompare(beforeTable, columns, afterTable, columns, nil)
+	if err != nil {
+		t.Fatalf("mergeCompare() error = %v", err)
+	}
+	var gotStrings []string
+	for _, rowObject := range got {
+		gotStrings = append(gotStrings, DiffStatusLabel(rowObject)+" "+rowObject.String())
+	}
+	want := []string{
+		"UPD BEFORE ([id:2][name:b])",
+		"UPD  AFTER ([id:2][name:B])",
+		"DELETED ([id:3][name:c])",
+		"INSERTED ([id:5][name:e])",
+	}
+	if len(gotStrings) != len(want) {
+		t.Fatalf("mergeCompare() = %v, want %v", gotStrings, want)
+	}
+	for i := range want {
+		if gotStrings[i] != want[i] {
+			t.Errorf("mergeCompare()[%d] = %v, want %v", i, gotStrings[i], want[i])
+		}
+	}
+	if !got[0].IsModifiedColumn(1) || got[0].IsModifiedColumn(0) {
+		t.Errorf("ModifiedColumnIndex = %v, want [1]", got[0].ModifiedColumnIndex)
+	}
+}
+
+func Test_compareKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []string
+		b    []string
+		want int
+	}{
+		{"Equal", []string{"1", "a"}, []string{"1", "a"}, 0},
+		{"Less", []string{"1", "a"}, []string{"1", "b"}, -1},
+		{"Greater", []string{"2"}, []string{"10"}, 1},
+		{"NoConcatenation", []string{"1", "23"}, []string{"12", "3"}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareKeys(tt.a, tt.b); got != tt.want {
+				t.Errorf("compareKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
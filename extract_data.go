@@ -1,135 +1,288 @@
 package dbdiff
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
-	"log"
-	"reflect"
+	"sort"
 	"strings"
 )
 
 type AllTableStore struct {
-	AllData            map[string]map[string]*RowObject
-	AllColumn          map[string][]string
-	TotalDataCount     uint64
+	AllData           map[string]map[string]*RowObject
+	AllColumn         map[string][]string
+	TotalDataCount    uint64
+	FetchedDataCount  uint64 // Number of rows actually read from the db(less than TotalDataCount on incremental collection)
+	SkippedTableCount int    // Number of tables not fetched because their checksum is not changed
+	// Tables failed to collect with Snapshot.ContinueOnError. They are not compared by ExtractChangedData().
+	FailedTables       map[string]*ErrCollectTable
 	alreadyCollectData bool
+	comparer           *ValueComparer
+	highWaterMarks     map[string]interface{}
+	checksums          map[string]string
 }
 
-func (ats *AllTableStore) CollectAllTableData(db DbHolder, config *Configuration, tablePks map[string][]string) error {
+// Collect data of all tables.
+//
+// Queries are canceled when ctx is done. Snapshot.Timeout and Snapshot.QueryTimeout of config limit the whole collection
+// and each query.
+func (ats *AllTableStore) CollectAllTableData(ctx context.Context, db DbHolder, config *Configuration, tablePks map[string][]string) error {
+	return ats.CollectIncrementalTableData(ctx, db, config, tablePks, nil)
+}
+
+// Collect data of all tables.
+//
+// For tables with TrackingColumn configured, only the rows updated since previous are fetched and merged into
+// the rows of previous. With ChecksumPrecheck, tables whose checksum is not changed since previous are not fetched.
+// If previous is nil, all rows are fetched.
+//
+// Errors of a table are returned as *ErrCollectTable. With Snapshot.ContinueOnError, the collection continues with
+// the other tables and the errors are recorded in FailedTables.
+func (ats *AllTableStore) CollectIncrementalTableData(ctx context.Context, db DbHolder, config *Configuration, tablePks map[string][]string, previous *AllTableStore) error {
 	if ats.alreadyCollectData {
 		return errors.New("already collected data")
 	}
-	var totalRecordCount uint64 = 0
 	var err error
+	ctx, cancel := withTimeout(ctx, config.Snapshot.Timeout)
+	defer cancel()
 
 	ats.AllColumn = map[string][]string{}
 	ats.AllData = map[string]map[string]*RowObject{}
+	ats.TotalDataCount = 0
+	ats.FetchedDataCount = 0
+	ats.highWaterMarks = map[string]interface{}{}
+	ats.checksums = map[string]string{}
+	ats.SkippedTableCount = 0
+	ats.FailedTables = map[string]*ErrCollectTable{}
+	if ats.comparer, err = config.ValueComparer(); err != nil {
+		return err
+	}
+	if err = ResolveTableFilters(ctx, db, config, tablePks); err != nil {
+		return err
+	}
 
-	schema := config.Db.Schema
-	const allDataQueryFormatStr = "SELECT * FROM %s"
-	const orderBy = " ORDER BY "
 	for tableName, pkColumns := range tablePks {
 		// TODO この中goroutine化するとテーブル数多い場合に早くなる？
-		query := fmt.Sprintf(allDataQueryFormatStr, schema+tableName)
-		if len(pkColumns) > 0 {
-			str := orderBy
-			for _, v := range pkColumns {
-				str += fmt.Sprintf("%s,", v)
+		if err = ats.collectTable(ctx, db, config, tableName, pkColumns, previous); err != nil {
+			collectErr := &ErrCollectTable{Table: tableName, Err: err}
+			// 中断やタイムアウトの場合は続けない
+			if !config.Snapshot.ContinueOnError || ctx.Err() != nil {
+				return collectErr
 			}
-			str = strings.TrimRight(str, ",")
-			query += str
+			delete(ats.AllData, tableName)
+			delete(ats.AllColumn, tableName)
+			delete(ats.highWaterMarks, tableName)
+			delete(ats.checksums, tableName)
+			ats.FailedTables[tableName] = collectErr
 		}
-		rows, err := db.Query(query)
-		if err != nil {
+	}
+
+	ats.alreadyCollectData = true
+	return nil
+}
+
+func (ats *AllTableStore) collectTable(ctx context.Context, db DbHolder, config *Configuration, tableName string, pkColumns []string, previous *AllTableStore) error {
+	var err error
+	sampled := config.isSampled(tableName)
+	if config.Snapshot.ChecksumPrecheck && !sampled && ats.reuseUnchangedTable(ctx, db, config, tableName, previous) {
+		return nil
+	}
+	trackingColumn := config.GetTableConfig(tableName).TrackingColumn
+	if trackingColumn != "" && !sampled {
+		if ats.highWaterMarks[tableName], err = getHighWaterMark(ctx, db, config, tableName, trackingColumn); err != nil {
 			return err
 		}
+		if previous != nil {
+			merged, err := ats.collectIncrementalTableRows(ctx, db, config, tableName, pkColumns, previous)
+			if err != nil || merged {
+				return err
+			}
+		}
+	}
 
-		var tableRows = map[string]*RowObject{}
+	var tableRows = map[string]*RowObject{}
+	columns, err := scanTable(ctx, db, config, tableName, pkColumns, func(rowObject *RowObject) error {
+		tableRows[rowObject.GetKey(pkColumns)] = rowObject
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ats.AllColumn[tableName] = columns
+	ats.AllData[tableName] = tableRows
+	ats.TotalDataCount += uint64(len(tableRows))
+	ats.FetchedDataCount += uint64(len(tableRows))
+	return nil
+}
 
-		columns, err := rows.Columns()
-		if err != nil {
-			rows.Close()
-			return err
+// SELECT query of the table ordered by pkColumns. where is combined with the filter of the table if not empty.
+func tableQuery(config *Configuration, tableName string, pkColumns []string, where string) string {
+	const allDataQueryFormatStr = "SELECT * FROM %s"
+	const orderBy = " ORDER BY "
+	query := fmt.Sprintf(allDataQueryFormatStr, tableSource(config, tableName, "")) + tableWhere(config, tableName, where)
+	if len(pkColumns) > 0 {
+		str := orderBy
+		for _, v := range pkColumns {
+			str += fmt.Sprintf("%s,", v)
 		}
+		str = strings.TrimRight(str, ",")
+		query += str
+	}
+	return query
+}
 
-		ats.AllColumn[tableName] = columns
+// Read rows of the query. The rows are mapped by the key of pkColumns.
+func collectTableRows(ctx context.Context, db DbHolder, config *Configuration, query string, pkColumns []string, args ...interface{}) ([]string, map[string]*RowObject, error) {
+	var tableRows = map[string]*RowObject{}
+	columns, err := scanTableRows(ctx, db, config, query, args, func(rowObject *RowObject) error {
+		tableRows[rowObject.GetKey(pkColumns)] = rowObject
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-		for rows.Next() {
-			totalRecordCount++
+	//for _, v := range tableRows {
+	//	fmt.Println(v)
+	//}
 
-			var r []*ColumnScan
-			for range columns {
-				// 全部文字列で取ってしまう TODO 乱暴？
-				// TODO　OracleではNullStringが使えないかも
-				var v sql.Scanner
-				v = new(sql.NullString)
-				var col = &ColumnScan{Value: v}
-				r = append(r, col)
-			}
-			var r2 []interface{}
-			for _, v := range r {
-				r2 = append(r2, v)
-			}
-			err = rows.Scan(r2...)
-			if err != nil {
-				rows.Close()
-				return err
-			}
+	return columns, tableRows, nil
+}
 
-			rowObject := &RowObject{ColScans: r, DiffStatus: DiffStatusInit, ModifiedColumnIndex: []uint8{}, ColumnNames: columns, IsBeforeData: false}
-			tableRows[rowObject.GetKey(pkColumns)] = rowObject
-		}
-		rows.Close()
+// Read rows of the query one by one without holding all of them.
+//
+// Values of binary columns are held as their summary, and long text values are truncated by the Snapshot settings
+// of config(see SummarizeBlob(), TruncateCellValue()).
+func scanTableRows(ctx context.Context, db DbHolder, config *Configuration, query string, args []interface{}, handler func(rowObject *RowObject) error) ([]string, error) {
+	return scanRows(ctx, db, config, query, args, nil, handler)
+}
 
-		//for _, v := range tableRows {
-		//	fmt.Println(v)
-		//}
+// Same as scanTableRows(), and driver values of the columns of keepRawIndexes are kept(see ColumnScan#RawValue()).
+func scanRows(ctx context.Context, db DbHolder, config *Configuration, query string, args []interface{}, keepRawIndexes []int, handler func(rowObject *RowObject) error) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		ats.AllData[tableName] = tableRows
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
 	}
+	cells := config.cellOptions()
+
+	for rows.Next() {
+		var r []*ColumnScan
+		for index := range columns {
+			// 全部文字列で取ってしまう TODO 乱暴？
+			// TODO　OracleではNullStringが使えないかも
+			var v sql.Scanner
+			v = new(sql.NullString)
+			var col = &ColumnScan{Value: v, cells: cells}
+			if index < len(columnTypes) {
+				typeName := strings.ToUpper(columnTypes[index].DatabaseTypeName())
+				col.binary = binaryTypeNames[typeName]
+				col.structure = structuredTypeNames[typeName]
+			}
+			r = append(r, col)
+		}
+		for _, index := range keepRawIndexes {
+			r[index].keepRaw = true
+		}
+		var r2 []interface{}
+		for _, v := range r {
+			r2 = append(r2, v)
+		}
+		err = rows.Scan(r2...)
+		if err != nil {
+			return nil, err
+		}
 
-	ats.TotalDataCount = totalRecordCount
-	ats.alreadyCollectData = true
-	return err
+		rowObject := &RowObject{ColScans: r, DiffStatus: DiffStatusInit, ModifiedColumnIndex: []uint8{}, ColumnNames: columns, IsBeforeData: false}
+		if err = handler(rowObject); err != nil {
+			return nil, err
+		}
+	}
+	return columns, rows.Err()
 }
 
 type ColumnScan struct {
 	Value sql.Scanner
+
+	keepRaw bool
+	raw     interface{}
+	cells   *cellOptions // nil holds the scanned values as they are
+	binary  bool         // binary column(see binaryTypeNames)
+	blob    []byte       // binary value kept for ExportBlobs()
+	// CompareTypeJSON or CompareTypeXML for JSON and XML columns(see structuredTypeNames)
+	structure string
 }
 
 func (rs *ColumnScan) String() string {
 	return fmt.Sprintf("[%s]", rs.GetValueString())
 }
 
+// Value as a string, "<NULL>" if NULL.
+//
+// Scanners other than *sql.NullString are formatted by their driver.Valuer if implemented(e.g. sql.NullInt64),
+// otherwise by fmt.
 func (rs *ColumnScan) GetValueString() string {
-	var s string
-	name := reflect.TypeOf(rs.Value).String()
-	v := reflect.ValueOf(rs.Value)
-	switch name {
-	// TODO もっとましなやり方は...
-	case "*sql.NullString":
-		if v.Elem().FieldByName("Valid").Bool() {
-			s = v.Elem().FieldByName("String").String()
-		} else {
-			s = "<NULL>"
+	switch v := rs.Value.(type) {
+	case *sql.NullString:
+		if !v.Valid {
+			return "<NULL>"
 		}
+		return v.String
+	case driver.Valuer:
+		value, err := v.Value()
+		if err != nil {
+			return fmt.Sprintf("<ERROR: %v>", err)
+		}
+		if value == nil {
+			return "<NULL>"
+		}
+		if b, ok := value.([]byte); ok {
+			return string(b)
+		}
+		return fmt.Sprint(value)
 	default:
-		log.Fatalf("[WARN] unknown type [%s]\n", name)
+		return fmt.Sprint(v)
 	}
-	return fmt.Sprintf("%s", s)
 }
 
 func (rs *ColumnScan) Scan(value interface{}) error {
+	if rs.keepRaw {
+		// ドライバのバッファを再利用される可能性があるのでコピーしておく
+		if b, ok := value.([]byte); ok {
+			value = append([]byte{}, b...)
+		}
+		rs.raw = value
+	}
+	if rs.cells != nil {
+		value = rs.cells.convert(rs, value)
+	}
 	return rs.Value.Scan(value)
 }
 
+// Driver value of the column, only if it was kept on scan. Used as a bind parameter.
+func (rs *ColumnScan) RawValue() interface{} {
+	return rs.raw
+}
+
 type RowObject struct {
 	DiffStatus          int8
 	ModifiedColumnIndex []uint8
 	ColumnNames         []string
 	ColScans            []*ColumnScan
 	IsBeforeData        bool
+	// Changed paths of the modified structured(JSON, XML) columns by the index(see ColumnPathChanges())
+	ModifiedPaths map[uint8][]PathChange
 }
 
 func (ro *RowObject) String() string {
@@ -146,6 +299,16 @@ func (ro *RowObject) String() string {
 	return builder.String()
 }
 
+// Whether the column at the specified index is modified
+func (ro *RowObject) IsModifiedColumn(index int) bool {
+	for _, v := range ro.ModifiedColumnIndex {
+		if int(v) == index {
+			return true
+		}
+	}
+	return false
+}
+
 func (ro *RowObject) GetKey(pkColumns []string) string {
 	var key = ""
 	for _, v := range pkColumns {
@@ -158,7 +321,29 @@ func (ro *RowObject) GetKey(pkColumns []string) string {
 	}
 	return key
 }
+
+// Readable key of the row like "id=1, code=A"
+func (ro *RowObject) KeyString(pkColumns []string) string {
+	var keys []string
+	for _, v := range pkColumns {
+		for index, v2 := range ro.ColumnNames {
+			if v2 == v {
+				keys = append(keys, v+"="+ro.ColScans[index].GetValueString())
+				break
+			}
+		}
+	}
+	return strings.Join(keys, ", ")
+}
+
+// Whether all values of the columns are the same. Indexes of the different columns are added to ModifiedColumnIndex
+// of both rows.
 func (ro *RowObject) EqualColumns(that *RowObject) bool {
+	return ro.EqualColumnsWith(that, nil)
+}
+
+// Same as EqualColumns(), but the values are compared by equal if not nil(see ValueComparer#ForTable()).
+func (ro *RowObject) EqualColumnsWith(that *RowObject, equal ColumnEqualFunc) bool {
 	if len(ro.ColScans) != len(that.ColScans) {
 		// 全カラムを変更扱いにしておく
 		for i := 0; i < len(ro.ColScans); i++ {
@@ -172,13 +357,30 @@ func (ro *RowObject) EqualColumns(that *RowObject) bool {
 
 	result := true
 	for index, thatColScan := range that.ColScans {
-		// 一致しなかったカラムのindexを保持
-		if ro.ColScans[index].GetValueString() != thatColScan.GetValueString() {
-			i := uint8(index)
-			ro.ModifiedColumnIndex = append(ro.ModifiedColumnIndex, i)
-			that.ModifiedColumnIndex = append(that.ModifiedColumnIndex, i)
-			result = false
+		a, b := ro.ColScans[index].GetValueString(), thatColScan.GetValueString()
+		if a == b {
+			continue
 		}
+		// JSON、XMLは変更されたパスも保持
+		if equal != nil && index < len(that.ColumnNames) {
+			kind := thatColScan.structure
+			if kind == "" {
+				kind = ro.ColScans[index].structure
+			}
+			ok, changes := equal(that.ColumnNames[index], kind, a, b)
+			if ok {
+				continue
+			}
+			if len(changes) > 0 {
+				ro.setColumnPathChanges(index, changes)
+				that.setColumnPathChanges(index, changes)
+			}
+		}
+		// 一致しなかったカラムのindexを保持
+		i := uint8(index)
+		ro.ModifiedColumnIndex = append(ro.ModifiedColumnIndex, i)
+		that.ModifiedColumnIndex = append(that.ModifiedColumnIndex, i)
+		result = false
 	}
 	return result
 }
@@ -189,18 +391,115 @@ const (
 	DiffStatusDel         int8 = 2 //: Delete,
 	DiffStatusMod         int8 = 3 //: Mod,
 	DiffStatusNotModified int8 = 4 //: NotModified
+	DiffStatusKeyChanged  int8 = 5 //: KeyChanged(see DetectKeyChanges())
 )
 
+// Number of changed rows in a table
+type ChangeSummary struct {
+	Inserted   int
+	Updated    int
+	Deleted    int
+	KeyChanged int
+}
+
+func (cs ChangeSummary) Total() int {
+	return cs.Inserted + cs.Updated + cs.Deleted + cs.KeyChanged
+}
+
+// Count changed rows. An updated row (before/after pair) is counted as one, as is a row whose key is changed.
+func SummarizeChanges(rows []*RowObject) ChangeSummary {
+	var cs ChangeSummary
+	for _, v := range rows {
+		switch v.DiffStatus {
+		case DiffStatusAdd:
+			cs.Inserted++
+		case DiffStatusDel:
+			cs.Deleted++
+		case DiffStatusMod:
+			if v.IsBeforeData {
+				cs.Updated++
+			}
+		case DiffStatusKeyChanged:
+			if v.IsBeforeData {
+				cs.KeyChanged++
+			}
+		}
+	}
+	return cs
+}
+
+// A changed row. Before is nil for an inserted row, After is nil for a deleted row.
+type RowChange struct {
+	Before *RowObject
+	After  *RowObject
+}
+
+// Changed row (After for inserted, otherwise Before)
+func (rc RowChange) Row() *RowObject {
+	if rc.Before != nil {
+		return rc.Before
+	}
+	return rc.After
+}
+
+func (rc RowChange) DiffStatus() int8 {
+	return rc.Row().DiffStatus
+}
+
+// Pair the rows returned by ExtractChangedData (before/after of an update are adjacent).
+// Rows whose key is changed(see DetectKeyChanges()) are paired in the same way.
+func GroupChanges(rows []*RowObject) []RowChange {
+	var changes []RowChange
+	for i := 0; i < len(rows); i++ {
+		row := rows[i]
+		switch row.DiffStatus {
+		case DiffStatusAdd:
+			changes = append(changes, RowChange{After: row})
+		case DiffStatusDel:
+			changes = append(changes, RowChange{Before: row})
+		case DiffStatusMod, DiffStatusKeyChanged:
+			change := RowChange{}
+			if row.IsBeforeData {
+				change.Before = row
+				if i+1 < len(rows) && rows[i+1].DiffStatus == row.DiffStatus && !rows[i+1].IsBeforeData {
+					change.After = rows[i+1]
+					i++
+				}
+			} else {
+				change.After = row
+			}
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// Table names of the changed data in alphabetical order
+func SortedTableNames(changedData map[string][]*RowObject) []string {
+	names := make([]string, 0, len(changedData))
+	for tableName := range changedData {
+		names = append(names, tableName)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // テーブルごとに、追加、変更（変更前後）、削除のデータだけをまとめたものを戻り値で返す
 // 呼ぶときは必ず変更前データを引数にし、メッソドレシーバは変更後データとすること
+// 値は変更後データを取得した設定の比較ルール(Configuration.Comparison)で比較する
 func (ats *AllTableStore) ExtractChangedData(beforeData *AllTableStore) map[string][]*RowObject {
 	var output = map[string][]*RowObject{}
 
 	for tableName, aTableData := range beforeData.AllData {
+		if _, failed := ats.FailedTables[tableName]; failed {
+			// 取得できなかったテーブルは比較しない
+			continue
+		}
 		var outputTableData []*RowObject
 
 		afterTableData := ats.AllData[tableName]
 		beforeTableData := aTableData
+		equal := ats.comparer.ForTable(tableName)
 
 		// key(Pk組み合わせ)でbefore側に存在するキーを保持。後段でafter側にのみあるデータを調べる為に使用
 		var scanedKeys = map[string]struct{}{}
@@ -216,7 +515,7 @@ func (ats *AllTableStore) ExtractChangedData(beforeData *AllTableStore) map[stri
 				outputTableData = append(outputTableData, beforeRowObject)
 				continue
 			}
-			if beforeRowObject.EqualColumns(afterRowObject) {
+			if beforeRowObject.EqualColumnsWith(afterRowObject, equal) {
 				// 一致する為変更なし
 				beforeRowObject.DiffStatus = DiffStatusNotModified
 				afterRowObject.DiffStatus = DiffStatusNotModified
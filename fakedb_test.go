@@ -0,0 +1,99 @@
+package dbdiff
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+)
+
+// Result of a query of fakeDB
+type fakeResult struct {
+	columns []string
+	types   []string // DatabaseTypeName of the columns, empty if not specified
+	rows    [][]interface{}
+}
+
+// Function answering the queries of fakeDB. Queries of ExecContext() are answered with nil results.
+type fakeQueryFunc func(query string, args []interface{}) (*fakeResult, error)
+
+// DbHolder answering queries by query, to test the SQL and the handling of the results without a database
+func openFakeDB(t *testing.T, query fakeQueryFunc) DbHolder {
+	t.Helper()
+	return NewDBManager(sql.OpenDB(&fakeConnector{query: query}))
+}
+
+type fakeConnector struct {
+	query fakeQueryFunc
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{query: c.query}, nil
+}
+func (c *fakeConnector) Driver() driver.Driver { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return nil, errors.New("use openFakeDB()") }
+
+type fakeConn struct {
+	query fakeQueryFunc
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result, err := c.query(query, namedValues(args))
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		result = &fakeResult{}
+	}
+	return &fakeRows{result: result}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, err := c.query(query, namedValues(args)); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func namedValues(args []driver.NamedValue) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+type fakeRows struct {
+	result *fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	for i, v := range r.result.rows[r.next] {
+		dest[i] = v
+	}
+	r.next++
+	return nil
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(index int) string {
+	if index < len(r.result.types) {
+		return r.result.types[index]
+	}
+	return ""
+}
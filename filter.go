@@ -0,0 +1,239 @@
+package dbdiff
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	filterParamPattern      = regexp.MustCompile(`(^|[^:]):([A-Za-z_][A-Za-z0-9_]*)`)
+	filterIdentifierPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+	filterNumberPattern     = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+)
+
+// Resolve the effective filter of each table.
+//
+// The global where(Snapshot.Where) is applied only to tables having any of the columns used in it, and is combined
+// with the where of the table by AND. Parameters like ":tenant" are replaced by the literals of Snapshot.Params.
+// Resolved filters are used by all collections with the configuration.
+func ResolveTableFilters(ctx context.Context, db DbHolder, config *Configuration, tablePks map[string][]string) error {
+	if config.filters == nil {
+		config.filters = map[string]string{}
+	}
+	for tableName := range tablePks {
+		if _, ok := config.filters[tableName]; ok {
+			continue
+		}
+		var conditions []string
+		if config.Snapshot.Where != "" {
+			columns, err := GetColumnNames(ctx, db, tableName, config.Db.Schema)
+			if err != nil {
+				return err
+			}
+			if usesAnyColumn(config.Snapshot.Where, columns) {
+				conditions = append(conditions, config.Snapshot.Where)
+			}
+		}
+		if where := config.GetTableConfig(tableName).Where; where != "" {
+			conditions = append(conditions, where)
+		}
+
+		var filter string
+		if len(conditions) == 1 {
+			filter = conditions[0]
+		} else if len(conditions) > 1 {
+			filter = "(" + strings.Join(conditions, ") AND (") + ")"
+		}
+		filter, err := bindFilterParams(filter, config.Snapshot.Params)
+		if err != nil {
+			return fmt.Errorf("where of %s: %v", tableName, err)
+		}
+		config.filters[tableName] = filter
+	}
+	return nil
+}
+
+// Effective filter of each table for reports, like "tenant_id = 42, sample 10%". Tables without filter are omitted.
+func (c *Configuration) EffectiveFilters() map[string]string {
+	filters := map[string]string{}
+	for tableName := range c.filters {
+		if description := c.FilterDescription(tableName); description != "" {
+			filters[tableName] = description
+		}
+	}
+	for tableName := range c.Tables {
+		if description := c.FilterDescription(tableName); description != "" {
+			filters[tableName] = description
+		}
+	}
+	return filters
+}
+
+// Effective filter of the table for reports. Empty if no filter.
+func (c *Configuration) FilterDescription(tableName string) string {
+	var descriptions []string
+	if filter := c.tableFilter(tableName); filter != "" {
+		descriptions = append(descriptions, filter)
+	}
+	if percent := c.samplePercent(tableName); percent > 0 {
+		descriptions = append(descriptions, fmt.Sprintf("sample %s%%", strconv.FormatFloat(percent, 'f', -1, 64)))
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// Where condition of the table. Before ResolveTableFilters(), only the where of the table is used.
+func (c *Configuration) tableFilter(tableName string) string {
+	if filter, ok := c.filters[tableName]; ok {
+		return filter
+	}
+	filter, err := bindFilterParams(c.GetTableConfig(tableName).Where, c.Snapshot.Params)
+	if err != nil {
+		return c.GetTableConfig(tableName).Where
+	}
+	return filter
+}
+
+func (c *Configuration) samplePercent(tableName string) float64 {
+	if percent := c.GetTableConfig(tableName).SamplePercent; percent > 0 {
+		return percent
+	}
+	return c.Snapshot.SamplePercent
+}
+
+// Whether the table is sampled. Incremental collection, checksum pre-check and chunked reads are not used for it.
+func (c *Configuration) isSampled(tableName string) bool {
+	percent := c.samplePercent(tableName)
+	return percent > 0 && percent < 100
+}
+
+// FROM clause of the table with TABLESAMPLE if sampled. alias is optional.
+func tableSource(config *Configuration, tableName string, alias string) string {
+	source := config.Db.Schema + tableName
+	if alias != "" {
+		source += " " + alias
+	}
+	if !config.isSampled(tableName) {
+		return source
+	}
+	percent := strconv.FormatFloat(config.samplePercent(tableName), 'f', -1, 64)
+	switch config.Db.DbType {
+	case "postgresql":
+		source += fmt.Sprintf(" TABLESAMPLE BERNOULLI (%s) REPEATABLE (%d)", percent, config.Snapshot.SampleSeed)
+	case "mssql":
+		source += fmt.Sprintf(" TABLESAMPLE (%s PERCENT) REPEATABLE (%d)", percent, config.Snapshot.SampleSeed)
+	}
+	return source
+}
+
+// WHERE clause combining where and the filter(and sampling condition for databases without TABLESAMPLE) of the table.
+// Empty if no condition.
+func tableWhere(config *Configuration, tableName string, where string) string {
+	var sampling string
+	if config.isSampled(tableName) && config.Db.DbType == "mysql" {
+		// TABLESAMPLEがないのでRAND()で代用する
+		sampling = fmt.Sprintf("RAND(%d) < %s", config.Snapshot.SampleSeed,
+			strconv.FormatFloat(config.samplePercent(tableName)/100, 'f', -1, 64))
+	}
+	return whereClause(config.tableFilter(tableName), sampling, where)
+}
+
+// WHERE clause combining non-empty conditions by AND. Empty if no condition.
+func whereClause(conditions ...string) string {
+	var nonEmpty []string
+	for _, condition := range conditions {
+		if condition != "" {
+			nonEmpty = append(nonEmpty, condition)
+		}
+	}
+	switch len(nonEmpty) {
+	case 0:
+		return ""
+	case 1:
+		return " WHERE " + nonEmpty[0]
+	default:
+		return " WHERE (" + strings.Join(nonEmpty, ") AND (") + ")"
+	}
+}
+
+// Whether the where uses any of the columns
+func usesAnyColumn(where string, columns []string) bool {
+	// 文字列リテラルとパラメータは除外する
+	stripped := filterParamPattern.ReplaceAllString(stripStringLiterals(where), "$1")
+	for _, identifier := range filterIdentifierPattern.FindAllString(stripped, -1) {
+		for _, col := range columns {
+			if strings.EqualFold(identifier, col) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stripStringLiterals(s string) string {
+	builder := strings.Builder{}
+	inLiteral := false
+	for _, r := range s {
+		if r == '\'' {
+			inLiteral = !inLiteral
+			continue
+		}
+		if !inLiteral {
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// Replace parameters like ":tenant" with SQL literals of params. Parameters in string literals are not replaced.
+func bindFilterParams(where string, params map[string]string) (string, error) {
+	var err error
+	builder := strings.Builder{}
+	segment := strings.Builder{}
+	inLiteral := false
+	flush := func() {
+		if inLiteral {
+			builder.WriteString(segment.String())
+		} else {
+			builder.WriteString(bindSegmentParams(segment.String(), params, &err))
+		}
+		segment.Reset()
+	}
+	for _, r := range where {
+		if r == '\'' {
+			// ''はリテラルを閉じてすぐ開くものとして扱えば、エスケープされた引用符も含めて読み飛ばせる
+			flush()
+			builder.WriteRune(r)
+			inLiteral = !inLiteral
+			continue
+		}
+		segment.WriteRune(r)
+	}
+	flush()
+	return builder.String(), err
+}
+
+// Replace parameters of a part of the where outside string literals. The first error is set to err.
+func bindSegmentParams(segment string, params map[string]string, err *error) string {
+	return filterParamPattern.ReplaceAllStringFunc(segment, func(match string) string {
+		submatches := filterParamPattern.FindStringSubmatch(match)
+		value, ok := params[submatches[2]]
+		if !ok {
+			if *err == nil {
+				*err = fmt.Errorf("parameter :%s is not specified", submatches[2])
+			}
+			return match
+		}
+		return submatches[1] + sqlLiteral(value)
+	})
+}
+
+// Numeric values as is, others as quoted strings
+func sqlLiteral(value string) string {
+	if filterNumberPattern.MatchString(value) {
+		return value
+	}
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
@@ -0,0 +1,112 @@
+package dbdiff
+
+import "testing"
+
+func Test_bindFilterParams(t *testing.T) {
+	params := map[string]string{"tenant": "42", "name": "O'Reilly"}
+	tests := []struct {
+		name    string
+		where   string
+		want    string
+		wantErr bool
+	}{
+		{"Number", "tenant_id = :tenant", "tenant_id = 42", false},
+		{"String", "name = :name", "name = 'O''Reilly'", false},
+		{"Multiple", "tenant_id = :tenant AND name <> :name", "tenant_id = 42 AND name <> 'O''Reilly'", false},
+		{"Cast", "created_at::date = '2020-01-01'", "created_at::date = '2020-01-01'", false},
+		{"Unknown", "user_id = :user", "user_id = :user", true},
+		{"ColonInLiteral", "note = 'a:b'", "note = 'a:b'", false},
+		{"ParamNameInLiteral", "note = 'x :tenant y' AND tenant_id = :tenant", "note = 'x :tenant y' AND tenant_id = 42", false},
+		{"EscapedQuote", "note = 'it''s :tenant' AND name = :name", "note = 'it''s :tenant' AND name = 'O''Reilly'", false},
+		{"LiteralCast", "'2020-01-01'::date < :tenant", "'2020-01-01'::date < 42", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bindFilterParams(tt.where, params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("bindFilterParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("bindFilterParams() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_usesAnyColumn(t *testing.T) {
+	columns := []string{"id", "tenant_id"}
+	tests := []struct {
+		name  string
+		where string
+		want  bool
+	}{
+		{"Column", "tenant_id = :tenant", true},
+		{"CaseInsensitive", "TENANT_ID = 1", true},
+		{"OtherColumn", "owner_id = :tenant", false},
+		{"Parameter", "owner_id = :id", false},
+		{"Literal", "owner_id = 'tenant_id'", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := usesAnyColumn(tt.where, columns); got != tt.want {
+				t.Errorf("usesAnyColumn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tableQuery_filter(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Configuration
+		where  string
+		want   string
+	}{
+		{
+			name:   "NoFilter",
+			config: Configuration{Db: Db{DbType: "postgresql"}},
+			want:   "SELECT * FROM users ORDER BY id",
+		},
+		{
+			name:   "Filter",
+			config: Configuration{Db: Db{DbType: "postgresql"}, filters: map[string]string{"users": "tenant_id = 42"}},
+			where:  "id > $1",
+			want:   "SELECT * FROM users WHERE (tenant_id = 42) AND (id > $1) ORDER BY id",
+		},
+		{
+			name:   "SamplePostgreSQL",
+			config: Configuration{Db: Db{DbType: "postgresql"}, Snapshot: Snapshot{SamplePercent: 10, SampleSeed: 1}},
+			want:   "SELECT * FROM users TABLESAMPLE BERNOULLI (10) REPEATABLE (1) ORDER BY id",
+		},
+		{
+			name:   "SampleMySQL",
+			config: Configuration{Db: Db{DbType: "mysql"}, Tables: map[string]TableConfig{"users": {Where: "tenant_id = 1", SamplePercent: 2.5}}},
+			want:   "SELECT * FROM users WHERE (tenant_id = 1) AND (RAND(0) < 0.025) ORDER BY id",
+		},
+		{
+			name:   "SampleAll",
+			config: Configuration{Db: Db{DbType: "mssql"}, Snapshot: Snapshot{SamplePercent: 100}},
+			want:   "SELECT * FROM users ORDER BY id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tableQuery(&tt.config, "users", []string{"id"}, tt.where); got != tt.want {
+				t.Errorf("tableQuery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfiguration_FilterDescription(t *testing.T) {
+	config := Configuration{
+		Snapshot: Snapshot{SamplePercent: 10, Params: map[string]string{"user": "alice"}},
+		Tables:   map[string]TableConfig{"orders": {Where: "user_name = :user"}},
+	}
+	if got, want := config.FilterDescription("orders"), "user_name = 'alice', sample 10%"; got != want {
+		t.Errorf("FilterDescription() = %v, want %v", got, want)
+	}
+	if got, want := config.FilterDescription("users"), "sample 10%"; got != want {
+		t.Errorf("FilterDescription() = %v, want %v", got, want)
+	}
+}
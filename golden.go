@@ -0,0 +1,265 @@
+package dbdiff
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Rule to replace volatile values(generated IDs, timestamps, ...) with a placeholder, so that golden files stay stable.
+//
+// If Placeholder contains "%d", each distinct value is numbered in order of appearance(e.g. "<ID%d>" gives
+// "<ID1>", "<ID2>", ...), so that references between rows are kept.
+type NormalizeRule struct {
+	Table       string // Pattern of table names(path.Match), all tables if empty
+	Column      string // Pattern of column names(path.Match), e.g. "*_at"
+	Pattern     string // Regular expression of the values to replace, all values except NULL if empty
+	Placeholder string // e.g. "<TIMESTAMP>", "<ID%d>"
+}
+
+// Options for WriteGolden
+type GoldenOptions struct {
+	// Write as JSON instead of text
+	JSON bool
+	// Rules applied to the values before writing
+	Rules []NormalizeRule
+}
+
+// Write changed data in a deterministic format for golden files.
+//
+// Tables are ordered by name and rows by key. The text format is the same as WriteTerminal() with all columns.
+func WriteGolden(w io.Writer, changedData map[string][]*RowObject, tablePks map[string][]string, opts GoldenOptions) error {
+	normalized, err := NormalizeChanges(changedData, tablePks, opts.Rules)
+	if err != nil {
+		return err
+	}
+	if !opts.JSON {
+		return WriteTerminal(w, normalized, tablePks, TerminalOptions{Verbose: true})
+	}
+
+	type goldenRow struct {
+		Status   string              `json:"status"`
+		Key      string              `json:"key"`
+		NewKey   string              `json:"new_key,omitempty"`
+		Values   map[string]string   `json:"values,omitempty"`
+		Before   map[string]string   `json:"before,omitempty"`
+		After    map[string]string   `json:"after,omitempty"`
+		Modified []string            `json:"modified,omitempty"`
+		Paths    map[string][]string `json:"paths,omitempty"`
+	}
+	type goldenTable struct {
+		Name       string      `json:"name"`
+		Inserted   int         `json:"inserted"`
+		Updated    int         `json:"updated"`
+		Deleted    int         `json:"deleted"`
+		KeyChanged int         `json:"key_changed,omitempty"`
+		Rows       []goldenRow `json:"rows"`
+	}
+	tables := []goldenTable{}
+	for _, tableName := range SortedTableNames(normalized) {
+		rows := normalized[tableName]
+		if len(rows) == 0 {
+			continue
+		}
+		summary := SummarizeChanges(rows)
+		table := goldenTable{Name: tableName, Inserted: summary.Inserted, Updated: summary.Updated, Deleted: summary.Deleted,
+			KeyChanged: summary.KeyChanged, Rows: []goldenRow{}}
+		for _, change := range GroupChanges(rows) {
+			row := goldenRow{Key: change.Row().KeyString(tablePks[tableName])}
+			switch change.DiffStatus() {
+			case DiffStatusAdd:
+				row.Status, row.Values = "INSERTED", rowValues(change.After)
+			case DiffStatusDel:
+				row.Status, row.Values = "DELETED", rowValues(change.Before)
+			default:
+				row.Status, row.Before, row.After = "UPDATED", rowValues(change.Before), rowValues(change.After)
+				if change.DiffStatus() == DiffStatusKeyChanged {
+					row.Status = "KEY CHANGED"
+					if change.After != nil {
+						row.NewKey = change.After.KeyString(tablePks[tableName])
+					}
+				}
+				for index, colName := range change.Row().ColumnNames {
+					if change.Row().IsModifiedColumn(index) {
+						row.Modified = append(row.Modified, colName)
+					}
+					for _, pathChange := range change.Row().ColumnPathChanges(index) {
+						if row.Paths == nil {
+							row.Paths = map[string][]string{}
+						}
+						row.Paths[colName] = append(row.Paths[colName], pathChange.String())
+					}
+				}
+			}
+			table.Rows = append(table.Rows, row)
+		}
+		tables = append(tables, table)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(map[string]interface{}{"tables": tables})
+}
+
+// Copy of changed data with the values replaced by the rules.
+//
+// Rows of each table are ordered by key(numerically if possible), which also decides the numbers of the placeholders.
+func NormalizeChanges(changedData map[string][]*RowObject, tablePks map[string][]string, rules []NormalizeRule) (map[string][]*RowObject, error) {
+	normalizer, err := newValueNormalizer(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	output := map[string][]*RowObject{}
+	for _, tableName := range SortedTableNames(changedData) {
+		changes := GroupChanges(changedData[tableName])
+		pkColumns := tablePks[tableName]
+		sort.SliceStable(changes, func(i, j int) bool {
+			return compareKeyValues(keyValues(changes[i].Row(), pkColumns), keyValues(changes[j].Row(), pkColumns)) < 0
+		})
+
+		var rows []*RowObject
+		for _, change := range changes {
+			for _, row := range []*RowObject{change.Before, change.After} {
+				if row != nil {
+					rows = append(rows, normalizer.normalizeRow(tableName, row))
+				}
+			}
+		}
+		output[tableName] = rows
+	}
+	return output, nil
+}
+
+type compiledNormalizeRule struct {
+	NormalizeRule
+	pattern *regexp.Regexp
+	numbers map[string]int
+}
+
+type valueNormalizer struct {
+	rules []*compiledNormalizeRule
+}
+
+func newValueNormalizer(rules []NormalizeRule) (*valueNormalizer, error) {
+	normalizer := &valueNormalizer{}
+	for _, rule := range rules {
+		compiled := &compiledNormalizeRule{NormalizeRule: rule, numbers: map[string]int{}}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern of normalize rule: %v", err)
+			}
+			compiled.pattern = pattern
+		}
+		for _, p := range []string{rule.Table, rule.Column} {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("invalid name pattern of normalize rule [%s]: %v", p, err)
+			}
+		}
+		normalizer.rules = append(normalizer.rules, compiled)
+	}
+	return normalizer, nil
+}
+
+func (vn *valueNormalizer) normalizeRow(tableName string, row *RowObject) *RowObject {
+	colScans := make([]*ColumnScan, len(row.ColScans))
+	for index, colScan := range row.ColScans {
+		colScans[index] = colScan
+		if index >= len(row.ColumnNames) {
+			continue
+		}
+		value := colScan.GetValueString()
+		if normalized, ok := vn.normalize(tableName, row.ColumnNames[index], value); ok {
+			colScans[index] = &ColumnScan{Value: &sql.NullString{String: normalized, Valid: true}}
+		}
+	}
+	return &RowObject{
+		DiffStatus:          row.DiffStatus,
+		ModifiedColumnIndex: append([]uint8{}, row.ModifiedColumnIndex...),
+		ColumnNames:         row.ColumnNames,
+		ColScans:            colScans,
+		IsBeforeData:        row.IsBeforeData,
+		ModifiedPaths:       row.ModifiedPaths,
+	}
+}
+
+// Value replaced by the first matching rule
+func (vn *valueNormalizer) normalize(tableName string, colName string, value string) (string, bool) {
+	if value == "<NULL>" {
+		return "", false
+	}
+	for _, rule := range vn.rules {
+		if !matchName(rule.Table, tableName) || !matchName(rule.Column, colName) {
+			continue
+		}
+		if rule.pattern != nil && !rule.pattern.MatchString(value) {
+			continue
+		}
+		if !strings.Contains(rule.Placeholder, "%d") {
+			return rule.Placeholder, true
+		}
+		number, ok := rule.numbers[value]
+		if !ok {
+			number = len(rule.numbers) + 1
+			rule.numbers[value] = number
+		}
+		return fmt.Sprintf(rule.Placeholder, number), true
+	}
+	return "", false
+}
+
+// Whether name matches the pattern case-insensitively. Empty pattern matches any name.
+func matchName(pattern string, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return matched
+}
+
+func rowValues(row *RowObject) map[string]string {
+	values := make(map[string]string, len(row.ColumnNames))
+	for index, colName := range row.ColumnNames {
+		values[colName] = row.ColScans[index].GetValueString()
+	}
+	return values
+}
+
+func keyValues(row *RowObject, pkColumns []string) []string {
+	var values []string
+	for _, pk := range pkColumns {
+		for index, colName := range row.ColumnNames {
+			if colName == pk {
+				values = append(values, row.ColScans[index].GetValueString())
+				break
+			}
+		}
+	}
+	return values
+}
+
+// Compare keys column by column, numerically if both values are numbers
+func compareKeyValues(a []string, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		x, errX := strconv.ParseFloat(a[i], 64)
+		y, errY := strconv.ParseFloat(b[i], 64)
+		if errX == nil && errY == nil && x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a[i], b[i])
+	}
+	return len(a) - len(b)
+}
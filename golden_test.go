@@ -0,0 +1,79 @@
+package dbdiff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteGolden(t *testing.T) {
+	columns := []string{"id", "user_id", "created_at"}
+	changedData := map[string][]*RowObject{
+		"orders": {
+			newTestRow(DiffStatusAdd, false, columns, []string{"10", "501", "2020-04-01 10:00:00"}),
+			newTestRow(DiffStatusAdd, false, columns, []string{"9", "502", "2020-04-01 10:00:01"}),
+			newTestRow(DiffStatusDel, true, columns, []string{"2", "501", "<NULL>"}),
+		},
+	}
+	tablePks := map[string][]string{"orders": {"id"}}
+	rules := []NormalizeRule{
+		{Column: "*_at", Placeholder: "<TIMESTAMP>"},
+		{Table: "orders", Column: "user_id", Placeholder: "<USER%d>"},
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		builder := strings.Builder{}
+		if err := WriteGolden(&builder, changedData, tablePks, GoldenOptions{JSON: true, Rules: rules}); err != nil {
+			t.Fatalf("WriteGolden() error = %v", err)
+		}
+		want := `{
+  "tables": [
+    {
+      "name": "orders",
+      "inserted": 2,
+      "updated": 0,
+      "deleted": 1,
+      "rows": [
+        {
+          "status": "DELETED",
+          "key": "id=2",
+          "values": {
+            "created_at": "<NULL>",
+            "id": "2",
+            "user_id": "<USER1>"
+          }
+        },
+        {
+          "status": "INSERTED",
+          "key": "id=9",
+          "values": {
+            "created_at": "<TIMESTAMP>",
+            "id": "9",
+            "user_id": "<USER2>"
+          }
+        },
+        {
+          "status": "INSERTED",
+          "key": "id=10",
+          "values": {
+            "created_at": "<TIMESTAMP>",
+            "id": "10",
+            "user_id": "<USER1>"
+          }
+        }
+      ]
+    }
+  ]
+}
+`
+		if got := builder.String(); got != want {
+			t.Errorf("WriteGolden() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("InvalidPattern", func(t *testing.T) {
+		err := WriteGolden(&strings.Builder{}, changedData, tablePks, GoldenOptions{Rules: []NormalizeRule{{Pattern: "("}}})
+		if err == nil {
+			t.Errorf("WriteGolden() error = nil, want error")
+		}
+	})
+}
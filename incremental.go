@@ -0,0 +1,139 @@
+package dbdiff
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Current max value of the tracking column. nil if the table is empty.
+func getHighWaterMark(ctx context.Context, db DbHolder, config *Configuration, tableName string, trackingColumn string) (interface{}, error) {
+	ctx, cancel := queryContext(ctx, config)
+	defer cancel()
+	var hwm interface{}
+	query := fmt.Sprintf("SELECT MAX(%s) FROM %s", trackingColumn, tableSource(config, tableName, "")) + tableWhere(config, tableName, "")
+	if err := db.QueryRowContext(ctx, query).Scan(&hwm); err != nil {
+		return nil, err
+	}
+	// ドライバのバッファを再利用される可能性があるのでコピーしておく
+	if b, ok := hwm.([]byte); ok {
+		hwm = append([]byte{}, b...)
+	}
+	return hwm, nil
+}
+
+// Fetch rows updated since previous and merge them into the rows of previous.
+//
+// Deleted rows are detected by a key-only scan. Returns false if the table can not be collected incrementally
+// (e.g. no high-water mark in previous, or rows which exist but are neither in previous nor updated), then the
+// caller must fetch all rows.
+func (ats *AllTableStore) collectIncrementalTableRows(ctx context.Context, db DbHolder, config *Configuration, tableName string, pkColumns []string, previous *AllTableStore) (bool, error) {
+	previousRows, ok := previous.AllData[tableName]
+	if !ok || len(pkColumns) == 0 {
+		return false, nil
+	}
+	previousHwm, ok := previous.highWaterMarks[tableName]
+	if !ok {
+		return false, nil
+	}
+
+	trackingColumn := config.GetTableConfig(tableName).TrackingColumn
+	var updatedRows map[string]*RowObject
+	var err error
+	queryCtx, cancel := queryContext(ctx, config)
+	defer cancel()
+	where, args := incrementalWhere(config, trackingColumn, previousHwm)
+	_, updatedRows, err = collectTableRows(queryCtx, db, config, tableQuery(config, tableName, pkColumns, where), pkColumns, args...)
+	if err != nil {
+		return false, err
+	}
+
+	keys, err := collectTableKeys(ctx, db, config, tableName, pkColumns)
+	if err != nil {
+		return false, err
+	}
+
+	tableRows, ok := mergeIncrementalRows(keys, updatedRows, previousRows)
+	if !ok {
+		return false, nil
+	}
+
+	ats.AllColumn[tableName] = previous.AllColumn[tableName]
+	ats.AllData[tableName] = tableRows
+	ats.TotalDataCount += uint64(len(tableRows))
+	ats.FetchedDataCount += uint64(len(updatedRows) + len(keys))
+	return true, nil
+}
+
+// Condition of the rows updated since the previous high-water mark(nil if the table was empty).
+//
+// Rows whose tracking value equals the high-water mark are fetched again, because rows committed later can have the same
+// value(coarse timestamps, concurrent transactions). They are deduplicated by the key in mergeIncrementalRows().
+func incrementalWhere(config *Configuration, trackingColumn string, previousHwm interface{}) (string, []interface{}) {
+	if previousHwm == nil {
+		// 前回は空テーブル
+		return trackingColumn + " IS NOT NULL", nil
+	}
+	return trackingColumn + " >= " + placeholder(config.Db.DbType, 1), []interface{}{previousHwm}
+}
+
+// Rows of keys taken from updatedRows, or from previousRows if not updated.
+// Returns false if a row is in neither of them(added without updating the tracking column).
+func mergeIncrementalRows(keys []string, updatedRows map[string]*RowObject, previousRows map[string]*RowObject) (map[string]*RowObject, bool) {
+	tableRows := make(map[string]*RowObject, len(keys))
+	for _, key := range keys {
+		if row, ok := updatedRows[key]; ok {
+			tableRows[key] = row
+			continue
+		}
+		row, ok := previousRows[key]
+		if !ok {
+			// 追跡カラムが更新されずに追加された行がある
+			return nil, false
+		}
+		tableRows[key] = row.copyForCollection()
+	}
+	return tableRows, true
+}
+
+// Keys of all rows in the table(same as RowObject#GetKey())
+func collectTableKeys(ctx context.Context, db DbHolder, config *Configuration, tableName string, pkColumns []string) ([]string, error) {
+	ctx, cancel := queryContext(ctx, config)
+	defer cancel()
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(pkColumns, ","), tableSource(config, tableName, "")) + tableWhere(config, tableName, "")
+	// 変更された行と同じキーになるよう、値はscanRows()と同じく要約・切り詰めする
+	var keys []string
+	_, err := scanRows(ctx, db, config, query, nil, nil, func(rowObject *RowObject) error {
+		keys = append(keys, rowObject.GetKey(pkColumns))
+		return nil
+	})
+	return keys, err
+}
+
+// Copy of the collected data with the diff status cleared, so that the same snapshot can be compared more than once.
+func (ats *AllTableStore) Copy() *AllTableStore {
+	copied := &AllTableStore{
+		AllData:            make(map[string]map[string]*RowObject, len(ats.AllData)),
+		AllColumn:          ats.AllColumn,
+		TotalDataCount:     ats.TotalDataCount,
+		FetchedDataCount:   ats.FetchedDataCount,
+		SkippedTableCount:  ats.SkippedTableCount,
+		FailedTables:       ats.FailedTables,
+		alreadyCollectData: ats.alreadyCollectData,
+		highWaterMarks:     ats.highWaterMarks,
+		checksums:          ats.checksums,
+	}
+	for tableName, tableRows := range ats.AllData {
+		copiedRows := make(map[string]*RowObject, len(tableRows))
+		for key, row := range tableRows {
+			copiedRows[key] = row.copyForCollection()
+		}
+		copied.AllData[tableName] = copiedRows
+	}
+	return copied
+}
+
+// Copy the row with the diff status cleared, so that the row can be compared again in another AllTableStore.
+func (ro *RowObject) copyForCollection() *RowObject {
+	return &RowObject{ColScans: ro.ColScans, DiffStatus: DiffStatusInit, ModifiedColumnIndex: []uint8{}, ColumnNames: ro.ColumnNames, IsBeforeData: false}
+}
@@ -0,0 +1,127 @@
+package dbdiff
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func Test_incrementalWhere(t *testing.T) {
+	tests := []struct {
+		name      string
+		dbType    string
+		hwm       interface{}
+		wantWhere string
+		wantArgs  []interface{}
+	}{
+		{"EmptyTable", "postgresql", nil, "updated_at IS NOT NULL", nil},
+		// 前回と同じ値の行も取り直す
+		{"PostgreSQL", "postgresql", "2020-01-01 00:00:00", "updated_at >= $1", []interface{}{"2020-01-01 00:00:00"}},
+		{"MySQL", "mysql", int64(10), "updated_at >= ?", []interface{}{int64(10)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Configuration{Db: Db{DbType: tt.dbType}}
+			gotWhere, gotArgs := incrementalWhere(config, "updated_at", tt.hwm)
+			if gotWhere != tt.wantWhere {
+				t.Errorf("incrementalWhere() where = %v, want %v", gotWhere, tt.wantWhere)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("incrementalWhere() args = %v, want %v", gotArgs, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func Test_mergeIncrementalRows(t *testing.T) {
+	columns := []string{"id", "name", "updated_at"}
+	previousRows := map[string]*RowObject{
+		"1": newTestRow(DiffStatusInit, false, columns, []string{"1", "alice", "10:00:00"}),
+		"2": newTestRow(DiffStatusInit, false, columns, []string{"2", "bob", "10:00:01"}),
+	}
+	tests := []struct {
+		name        string
+		keys        []string
+		updatedRows map[string]*RowObject
+		want        map[string]string
+		wantOk      bool
+	}{
+		{
+			// 前回の最大値と同じ値で後からコミットされた行(2の更新と3の追加)も取り込まれる
+			name: "EqualTrackingValue",
+			keys: []string{"1", "2", "3"},
+			updatedRows: map[string]*RowObject{
+				"2": newTestRow(DiffStatusInit, false, columns, []string{"2", "bobby", "10:00:01"}),
+				"3": newTestRow(DiffStatusInit, false, columns, []string{"3", "carol", "10:00:01"}),
+			},
+			want:   map[string]string{"1": "alice", "2": "bobby", "3": "carol"},
+			wantOk: true,
+		},
+		{
+			name:        "Deleted",
+			keys:        []string{"2"},
+			updatedRows: map[string]*RowObject{},
+			want:        map[string]string{"2": "bob"},
+			wantOk:      true,
+		},
+		{
+			name:        "AddedWithoutTrackingValue",
+			keys:        []string{"1", "2", "4"},
+			updatedRows: map[string]*RowObject{},
+			wantOk:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := mergeIncrementalRows(tt.keys, tt.updatedRows, previousRows)
+			if ok != tt.wantOk {
+				t.Fatalf("mergeIncrementalRows() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if !ok {
+				return
+			}
+			names := map[string]string{}
+			for key, row := range got {
+				names[key] = row.ColScans[1].GetValueString()
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("mergeIncrementalRows() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func Test_collectTableKeys(t *testing.T) {
+	// バイナリや長い文字列のキーも、変更された行と同じく要約・切り詰めたキーになる
+	binaryKey := []byte{0x89, 'P', 'N', 'G'}
+	longKey := strings.Repeat("k", 20)
+	db := openFakeDB(t, func(query string, args []interface{}) (*fakeResult, error) {
+		if strings.HasPrefix(query, "SELECT * FROM") {
+			return &fakeResult{columns: []string{"hash", "code", "name"}, types: []string{"BYTEA", "TEXT", "TEXT"},
+				rows: [][]interface{}{{binaryKey, longKey, "alice"}}}, nil
+		}
+		return &fakeResult{columns: []string{"hash", "code"}, types: []string{"BYTEA", "TEXT"},
+			rows: [][]interface{}{{binaryKey, longKey}}}, nil
+	})
+	config := &Configuration{Db: Db{DbType: "postgresql"}, Snapshot: Snapshot{MaxCellLength: 10}}
+	pkColumns := []string{"hash", "code"}
+
+	_, rows, err := collectTableRows(context.Background(), db, config, tableQuery(config, "files", pkColumns, ""), pkColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for key := range rows {
+		want = append(want, key)
+	}
+	sort.Strings(want)
+	got, err := collectTableKeys(context.Background(), db, config, "files", pkColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectTableKeys() = %v, want %v", got, want)
+	}
+}
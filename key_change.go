@@ -0,0 +1,261 @@
+package dbdiff
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Pairs of a deleted row and an inserted row compared for similarity. With more pairs in a table,
+// only the rows whose matching columns are all the same are detected.
+const maxKeyChangeComparisons = 1000000
+
+// Detection of the rows whose primary key is changed(see DetectKeyChanges())
+type KeyChangeConfig struct {
+	// Ratio of the matching columns whose values are equal, to regard a deleted row and an inserted row as the same row.
+	// 1 requires all the values to be equal. Disabled if 0.
+	Threshold float64 `yaml:"threshold"`
+	// Patterns of the column names(path.Match) used for matching. All columns except the primary key if empty.
+	Columns []string `yaml:"columns"`
+}
+
+func (kc *KeyChangeConfig) validate() error {
+	if kc.Threshold < 0 || kc.Threshold > 1 {
+		return errors.New("threshold of key_changes must be between 0 and 1")
+	}
+	for _, p := range kc.Columns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid column pattern of key_changes [%s]: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// Match deleted and inserted rows of the same table by the similarity of the values, and report the matched rows
+// as DiffStatusKeyChanged: the row with the old key(IsBeforeData) followed by the row with the new key.
+//
+// Tables without a primary key are matched by all columns, so that re-inserted rows are detected.
+// Values are compared by the comparison rules of config. Each row is matched at most once,
+// the most similar pairs first. changedData is not modified.
+func DetectKeyChanges(changedData map[string][]*RowObject, tablePks map[string][]string, config *Configuration) (map[string][]*RowObject, error) {
+	keyChanges := config.KeyChanges
+	if err := keyChanges.validate(); err != nil {
+		return nil, err
+	}
+	if keyChanges.Threshold == 0 {
+		return changedData, nil
+	}
+	comparer, err := config.ValueComparer()
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(map[string][]*RowObject, len(changedData))
+	for tableName, rows := range changedData {
+		patterns := keyChanges.Columns
+		if columns := config.GetTableConfig(tableName).KeyChangeColumns; len(columns) > 0 {
+			patterns = columns
+		}
+		matcher := &keyChangeMatcher{
+			pkColumns: tablePks[tableName],
+			patterns:  patterns,
+			threshold: keyChanges.Threshold,
+			equal:     comparer.ForTable(tableName),
+		}
+		output[tableName] = matcher.detect(rows)
+	}
+	return output, nil
+}
+
+type keyChangeMatcher struct {
+	pkColumns []string
+	patterns  []string
+	threshold float64
+	equal     ColumnEqualFunc
+}
+
+func (m *keyChangeMatcher) detect(rows []*RowObject) []*RowObject {
+	var deleted, inserted []*RowObject
+	for _, row := range rows {
+		switch row.DiffStatus {
+		case DiffStatusDel:
+			deleted = append(deleted, row)
+		case DiffStatusAdd:
+			inserted = append(inserted, row)
+		}
+	}
+	if len(deleted) == 0 || len(inserted) == 0 {
+		return rows
+	}
+	indexes := m.matchingColumnIndexes(deleted[0].ColumnNames)
+	if len(indexes) == 0 {
+		return rows
+	}
+	// mapの順序に依存しないよう、キー順に照合する
+	m.sortByKey(deleted)
+	m.sortByKey(inserted)
+
+	var pairs [][2]int
+	if len(deleted)*len(inserted) > maxKeyChangeComparisons {
+		pairs = m.matchSameValues(deleted, inserted, indexes)
+	} else {
+		pairs = m.matchSimilar(deleted, inserted, indexes)
+	}
+	if len(pairs) == 0 {
+		return rows
+	}
+
+	matched := map[*RowObject]struct{}{}
+	for _, pair := range pairs {
+		matched[deleted[pair[0]]] = struct{}{}
+		matched[inserted[pair[1]]] = struct{}{}
+	}
+	output := make([]*RowObject, 0, len(rows))
+	for _, row := range rows {
+		if _, ok := matched[row]; !ok {
+			output = append(output, row)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	for _, pair := range pairs {
+		// 比較結果の行はスナップショットの行でもあるので、コピーに変更されたカラムを持たせる
+		before := &RowObject{DiffStatus: DiffStatusKeyChanged, ModifiedColumnIndex: []uint8{},
+			ColumnNames: deleted[pair[0]].ColumnNames, ColScans: deleted[pair[0]].ColScans, IsBeforeData: true}
+		after := &RowObject{DiffStatus: DiffStatusKeyChanged, ModifiedColumnIndex: []uint8{},
+			ColumnNames: inserted[pair[1]].ColumnNames, ColScans: inserted[pair[1]].ColScans, IsBeforeData: false}
+		before.EqualColumnsWith(after, m.equal)
+		output = append(output, before, after)
+	}
+	return output
+}
+
+// Indexes of the columns used for matching. Columns of the primary key are excluded unless the table has no primary key.
+func (m *keyChangeMatcher) matchingColumnIndexes(columnNames []string) []int {
+	keyless := len(m.pkColumns) == 0 || len(m.pkColumns) >= len(columnNames)
+	var indexes []int
+	for index, colName := range columnNames {
+		if !keyless && containsColumn(m.pkColumns, colName) {
+			continue
+		}
+		if len(m.patterns) > 0 && !matchAnyName(m.patterns, colName) {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes
+}
+
+func containsColumn(columns []string, colName string) bool {
+	for _, column := range columns {
+		if strings.EqualFold(column, colName) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchName(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *keyChangeMatcher) sortByKey(rows []*RowObject) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return compareKeyValues(keyValues(rows[i], m.pkColumns), keyValues(rows[j], m.pkColumns)) < 0
+	})
+}
+
+// Pairs of the indexes of deleted and inserted rows whose similarity is not less than the threshold, the most similar first
+func (m *keyChangeMatcher) matchSimilar(deleted []*RowObject, inserted []*RowObject, indexes []int) [][2]int {
+	type candidate struct {
+		pair       [2]int
+		similarity float64
+	}
+	var candidates []candidate
+	for d, before := range deleted {
+		for i, after := range inserted {
+			if similarity, ok := m.similarity(before, after, indexes); ok {
+				candidates = append(candidates, candidate{pair: [2]int{d, i}, similarity: similarity})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].similarity > candidates[j].similarity })
+
+	usedDeleted := make([]bool, len(deleted))
+	usedInserted := make([]bool, len(inserted))
+	var pairs [][2]int
+	for _, c := range candidates {
+		if usedDeleted[c.pair[0]] || usedInserted[c.pair[1]] {
+			continue
+		}
+		usedDeleted[c.pair[0]], usedInserted[c.pair[1]] = true, true
+		pairs = append(pairs, c.pair)
+	}
+	return pairs
+}
+
+// Ratio of the equal values of the columns. ok is false if it is less than the threshold.
+func (m *keyChangeMatcher) similarity(before *RowObject, after *RowObject, indexes []int) (float64, bool) {
+	if len(before.ColScans) != len(after.ColScans) {
+		return 0, false
+	}
+	// しきい値に届かないことが確定したら打ち切る
+	allowed := float64(len(indexes)) * (1 - m.threshold)
+	different := 0
+	for _, index := range indexes {
+		if !m.equalValues(before.ColumnNames[index], before.ColScans[index], after.ColScans[index]) {
+			different++
+			if float64(different) > allowed+1e-9 {
+				return 0, false
+			}
+		}
+	}
+	return float64(len(indexes)-different) / float64(len(indexes)), true
+}
+
+func (m *keyChangeMatcher) equalValues(colName string, before *ColumnScan, after *ColumnScan) bool {
+	a, b := before.GetValueString(), after.GetValueString()
+	if a == b {
+		return true
+	}
+	kind := after.structure
+	if kind == "" {
+		kind = before.structure
+	}
+	equal, _ := m.equal(colName, kind, a, b)
+	return equal
+}
+
+// Pairs of the indexes of deleted and inserted rows whose values of the columns are all the same
+func (m *keyChangeMatcher) matchSameValues(deleted []*RowObject, inserted []*RowObject, indexes []int) [][2]int {
+	values := func(row *RowObject) string {
+		var b strings.Builder
+		for _, index := range indexes {
+			if index < len(row.ColScans) {
+				b.WriteString(row.ColScans[index].GetValueString())
+			}
+			b.WriteByte(0)
+		}
+		return b.String()
+	}
+	insertedByValues := map[string][]int{}
+	for i, row := range inserted {
+		key := values(row)
+		insertedByValues[key] = append(insertedByValues[key], i)
+	}
+	var pairs [][2]int
+	for d, row := range deleted {
+		key := values(row)
+		if candidates := insertedByValues[key]; len(candidates) > 0 {
+			pairs = append(pairs, [2]int{d, candidates[0]})
+			insertedByValues[key] = candidates[1:]
+		}
+	}
+	return pairs
+}
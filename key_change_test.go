@@ -0,0 +1,157 @@
+package dbdiff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectKeyChanges(t *testing.T) {
+	columns := []string{"id", "name", "email", "updated_at"}
+	logColumns := []string{"message", "level"}
+	tablePks := map[string][]string{"users": {"id"}, "logs": logColumns}
+	users := func(rows ...*RowObject) map[string][]*RowObject {
+		return map[string][]*RowObject{"users": rows}
+	}
+
+	tests := []struct {
+		name        string
+		config      Configuration
+		changedData map[string][]*RowObject
+		want        string
+	}{
+		{
+			name:   "KeyChanged",
+			config: Configuration{KeyChanges: KeyChangeConfig{Threshold: 0.6}},
+			changedData: users(
+				newTestRow(DiffStatusDel, true, columns, []string{"1", "alice", "a@example.com", "t1"}),
+				newTestRow(DiffStatusAdd, false, columns, []string{"7", "alice", "a@example.com", "t2"}),
+				newTestRow(DiffStatusAdd, false, columns, []string{"8", "bob", "b@example.com", "t2"}),
+			),
+			want: `=== users (1 inserted, 0 updated, 0 deleted, 1 key changed) ===
+~ id=1 -> id=7 (KEY CHANGED)
+    id: 1 -> 7
+    updated_at: t1 -> t2
++ id=8
+`,
+		},
+		{
+			name:   "BelowThreshold",
+			config: Configuration{KeyChanges: KeyChangeConfig{Threshold: 0.9}},
+			changedData: users(
+				newTestRow(DiffStatusDel, true, columns, []string{"1", "alice", "a@example.com", "t1"}),
+				newTestRow(DiffStatusAdd, false, columns, []string{"7", "alice", "a@example.com", "t2"}),
+			),
+			want: `=== users (1 inserted, 0 updated, 1 deleted) ===
+- id=1
++ id=7
+`,
+		},
+		{
+			name:   "Columns",
+			config: Configuration{KeyChanges: KeyChangeConfig{Threshold: 1, Columns: []string{"name", "EMAIL"}}},
+			changedData: users(
+				newTestRow(DiffStatusDel, true, columns, []string{"1", "alice", "a@example.com", "t1"}),
+				newTestRow(DiffStatusAdd, false, columns, []string{"7", "alice", "a@example.com", "t2"}),
+			),
+			want: `=== users (0 inserted, 0 updated, 0 deleted, 1 key changed) ===
+~ id=1 -> id=7 (KEY CHANGED)
+    id: 1 -> 7
+    updated_at: t1 -> t2
+`,
+		},
+		{
+			name: "TableColumns",
+			config: Configuration{KeyChanges: KeyChangeConfig{Threshold: 1},
+				Tables: map[string]TableConfig{"users": {KeyChangeColumns: []string{"email"}}}},
+			changedData: users(
+				newTestRow(DiffStatusDel, true, columns, []string{"1", "alice", "a@example.com", "t1"}),
+				newTestRow(DiffStatusAdd, false, columns, []string{"7", "Alice", "a@example.com", "t2"}),
+			),
+			want: `=== users (0 inserted, 0 updated, 0 deleted, 1 key changed) ===
+~ id=1 -> id=7 (KEY CHANGED)
+    id: 1 -> 7
+    name: alice -> Alice
+    updated_at: t1 -> t2
+`,
+		},
+		{
+			name:   "MostSimilarFirst",
+			config: Configuration{KeyChanges: KeyChangeConfig{Threshold: 0.3}},
+			changedData: users(
+				newTestRow(DiffStatusDel, true, columns, []string{"1", "alice", "x@example.com", "t1"}),
+				newTestRow(DiffStatusDel, true, columns, []string{"2", "alice", "a@example.com", "t1"}),
+				newTestRow(DiffStatusAdd, false, columns, []string{"7", "alice", "a@example.com", "t2"}),
+			),
+			want: `=== users (0 inserted, 0 updated, 1 deleted, 1 key changed) ===
+- id=1
+~ id=2 -> id=7 (KEY CHANGED)
+    id: 2 -> 7
+    updated_at: t1 -> t2
+`,
+		},
+		{
+			name: "ComparisonRules",
+			config: Configuration{KeyChanges: KeyChangeConfig{Threshold: 1},
+				Comparison: ComparisonConfig{Rules: []CompareRule{{Column: "updated_at", IgnoreCase: true}}}},
+			changedData: users(
+				newTestRow(DiffStatusDel, true, columns, []string{"1", "alice", "a@example.com", "t1"}),
+				newTestRow(DiffStatusAdd, false, columns, []string{"7", "alice", "a@example.com", "T1"}),
+			),
+			want: `=== users (0 inserted, 0 updated, 0 deleted, 1 key changed) ===
+~ id=1 -> id=7 (KEY CHANGED)
+    id: 1 -> 7
+`,
+		},
+		{
+			name:   "Keyless",
+			config: Configuration{KeyChanges: KeyChangeConfig{Threshold: 0.5}},
+			changedData: map[string][]*RowObject{"logs": {
+				newTestRow(DiffStatusDel, true, logColumns, []string{"started", "info"}),
+				newTestRow(DiffStatusAdd, false, logColumns, []string{"started", "warn"}),
+			}},
+			want: `=== logs (0 inserted, 0 updated, 0 deleted, 1 key changed) ===
+~ message=started, level=info -> message=started, level=warn (KEY CHANGED)
+    level: info -> warn
+`,
+		},
+		{
+			name:   "Disabled",
+			config: Configuration{},
+			changedData: users(
+				newTestRow(DiffStatusDel, true, columns, []string{"1", "alice", "a@example.com", "t1"}),
+				newTestRow(DiffStatusAdd, false, columns, []string{"7", "alice", "a@example.com", "t1"}),
+			),
+			want: `=== users (1 inserted, 0 updated, 1 deleted) ===
+- id=1
++ id=7
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectKeyChanges(tt.changedData, tablePks, &tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			builder := strings.Builder{}
+			if err := WriteTerminal(&builder, got, tablePks, TerminalOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if gotText := strings.TrimSuffix(builder.String(), "\n"); gotText != tt.want {
+				t.Errorf("DetectKeyChanges() =\n%v\nwant\n%v", gotText, tt.want)
+			}
+			for _, rows := range tt.changedData {
+				for _, row := range rows {
+					if row.DiffStatus == DiffStatusKeyChanged || len(row.ModifiedColumnIndex) > 0 {
+						t.Errorf("DetectKeyChanges() modified the input row %v", row)
+					}
+				}
+			}
+		})
+	}
+
+	config := &Configuration{KeyChanges: KeyChangeConfig{Threshold: 1.5}}
+	if _, err := DetectKeyChanges(map[string][]*RowObject{}, tablePks, config); err == nil {
+		t.Error("DetectKeyChanges() error = nil, want error of the threshold")
+	}
+}
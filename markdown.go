@@ -0,0 +1,198 @@
+package dbdiff
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultMarkdownMaxCellLength = 40    // default max length(runes) of a cell value
+	DefaultMarkdownMaxSize       = 60000 // default max size(bytes) of the output. GitHub comments are limited to 65536 chars.
+)
+
+// Options for WriteMarkdown
+type MarkdownOptions struct {
+	// Values longer than this are truncated. 0 means DefaultMarkdownMaxCellLength, negative means no limit.
+	MaxCellLength int
+	// Rows that do not fit in this size are omitted. 0 means DefaultMarkdownMaxSize, negative means no limit.
+	MaxSize int
+	// Effective filter of each table(see Configuration#EffectiveFilters()), shown under the table name
+	Filters map[string]string
+}
+
+// Write changed data as GitHub-flavored Markdown tables.
+//
+// Tables are written in alphabetical order and the rows in key order(same as WriteTerminal()). The rows before/after
+// an update are adjacent and the modified cells are bold. Rows that do not fit are omitted and counted as changes,
+// so that an update is counted once.
+// Modified JSON and XML cells of the rows after an update show the changed paths instead of the values.
+func WriteMarkdown(w io.Writer, changedData map[string][]*RowObject, tablePks map[string][]string, opts MarkdownOptions) error {
+	maxCellLength := opts.MaxCellLength
+	if maxCellLength == 0 {
+		maxCellLength = DefaultMarkdownMaxCellLength
+	}
+	maxSize := opts.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultMarkdownMaxSize
+	}
+
+	builder := strings.Builder{}
+	omitted := 0
+	changedTableCount := 0
+	for _, tableName := range SortedTableNames(changedData) {
+		rows := changedData[tableName]
+		if len(rows) == 0 {
+			continue
+		}
+		changedTableCount++
+		// 更新前後の行は1件と数え、ターミナルと同じくキー順に出す
+		pkColumns := tablePks[tableName]
+		changes := GroupChanges(rows)
+		sort.Stable(newRowChangesByKey(changes, pkColumns))
+		if omitted > 0 {
+			omitted += len(changes)
+			continue
+		}
+
+		summary := SummarizeChanges(rows)
+		header := fmt.Sprintf("### %s\n\n%d inserted, %d updated, %d deleted",
+			escapeMarkdownCell(tableName, -1), summary.Inserted, summary.Updated, summary.Deleted)
+		if summary.KeyChanged > 0 {
+			header += fmt.Sprintf(", %d key changed", summary.KeyChanged)
+		}
+		header += "\n\n"
+		if filter := opts.Filters[tableName]; filter != "" {
+			header += "Filter: " + escapeMarkdownCell(filter, -1) + "\n\n"
+		}
+		header += "| (diff) |"
+		separator := "|---|"
+		for _, colName := range rows[0].ColumnNames {
+			header += " " + escapeMarkdownCell(colName, maxCellLength) + " |"
+			separator += "---|"
+		}
+		header += "\n" + separator + "\n"
+		// 1件も行を出せないならテーブルごと省略する
+		if !fitsMarkdownSize(&builder, header+markdownChange(changes[0], maxCellLength), maxSize) {
+			omitted += len(changes)
+			continue
+		}
+		builder.WriteString(header)
+
+		for i, change := range changes {
+			lines := markdownChange(change, maxCellLength)
+			if !fitsMarkdownSize(&builder, lines, maxSize) {
+				omitted += len(changes) - i
+				break
+			}
+			builder.WriteString(lines)
+		}
+		builder.WriteString("\n")
+	}
+
+	if changedTableCount == 0 {
+		builder.WriteString("No differences.\n")
+	}
+	if omitted > 0 {
+		builder.WriteString(fmt.Sprintf("_%d more rows omitted_\n", omitted))
+	}
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+// Reserve space for the "omitted" footer
+const markdownFooterReserve = 64
+
+func fitsMarkdownSize(builder *strings.Builder, s string, maxSize int) bool {
+	return maxSize < 0 || builder.Len()+len(s)+markdownFooterReserve <= maxSize
+}
+
+// markdownChange returns the table rows of change. Before and after rows are not split.
+func markdownChange(change RowChange, maxCellLength int) string {
+	var lines string
+	for _, row := range []*RowObject{change.Before, change.After} {
+		if row != nil {
+			lines += markdownRow(row, maxCellLength)
+		}
+	}
+	return lines
+}
+
+func markdownRow(row *RowObject, maxCellLength int) string {
+	builder := strings.Builder{}
+	builder.WriteString("| ")
+	builder.WriteString(DiffStatusLabel(row))
+	builder.WriteString(" |")
+	for index, col := range row.ColScans {
+		value := escapeMarkdownCell(col.GetValueString(), maxCellLength)
+		if changes := row.ColumnPathChanges(index); changes != nil && !row.IsBeforeData {
+			// JSON、XMLは更新後の行に変更されたパスを出す
+			var paths []string
+			for _, change := range changes {
+				paths = append(paths, escapeMarkdownCell(change.String(), maxCellLength))
+			}
+			value = strings.Join(paths, "<br>")
+		}
+		builder.WriteString(" ")
+		if isPairedStatus(row.DiffStatus) && row.IsModifiedColumn(index) && value != "" {
+			builder.WriteString("**" + value + "**")
+		} else {
+			builder.WriteString(value)
+		}
+		builder.WriteString(" |")
+	}
+	builder.WriteString("\n")
+	return builder.String()
+}
+
+// Label of the row used in the result
+func DiffStatusLabel(row *RowObject) string {
+	switch row.DiffStatus {
+	case DiffStatusAdd:
+		return "INSERTED"
+	case DiffStatusDel:
+		return "DELETED"
+	case DiffStatusMod:
+		if row.IsBeforeData {
+			return "UPD BEFORE"
+		}
+		return "UPD  AFTER"
+	case DiffStatusKeyChanged:
+		if row.IsBeforeData {
+			return "KEY BEFORE"
+		}
+		return "KEY  AFTER"
+	case DiffStatusNotModified:
+		return "NOT MODIFIED"
+	}
+	return ""
+}
+
+// Whether the rows of the status are before/after pairs(updated rows and rows whose key is changed)
+func isPairedStatus(diffStatus int8) bool {
+	return diffStatus == DiffStatusMod || diffStatus == DiffStatusKeyChanged
+}
+
+func escapeMarkdownCell(s string, maxLength int) string {
+	if maxLength > 0 {
+		r := []rune(s)
+		if len(r) > maxLength {
+			s = string(r[:maxLength]) + "…"
+		}
+	}
+	replacer := strings.NewReplacer(
+		"\\", "\\\\",
+		"|", "\\|",
+		"*", "\\*",
+		"_", "\\_",
+		"`", "\\`",
+		"<", "&lt;",
+		">", "&gt;",
+		"\r\n", "<br>",
+		"\n", "<br>",
+		"\r", "<br>",
+	)
+	return replacer.Replace(s)
+}
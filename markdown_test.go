@@ -0,0 +1,111 @@
+package dbdiff
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func newTestRow(diffStatus int8, isBeforeData bool, columnNames []string, values []string, modified ...uint8) *RowObject {
+	var colScans []*ColumnScan
+	for _, v := range values {
+		var ns = &sql.NullString{}
+		if v != "<NULL>" {
+			ns.String = v
+			ns.Valid = true
+		}
+		colScans = append(colScans, &ColumnScan{Value: ns})
+	}
+	if modified == nil {
+		modified = []uint8{}
+	}
+	return &RowObject{DiffStatus: diffStatus, ModifiedColumnIndex: modified, ColumnNames: columnNames, ColScans: colScans, IsBeforeData: isBeforeData}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	columns := []string{"id", "name"}
+	changedData := map[string][]*RowObject{
+		// mapから取り出した行の順序はキー順とは限らない
+		"users": {
+			newTestRow(DiffStatusAdd, false, columns, []string{"2", "<NULL>"}),
+			newTestRow(DiffStatusMod, true, columns, []string{"1", "alice"}, 1),
+			newTestRow(DiffStatusMod, false, columns, []string{"1", "a|b"}, 1),
+		},
+		"audit": {
+			newTestRow(DiffStatusDel, true, []string{"id"}, []string{"9"}),
+		},
+		"unchanged": nil,
+	}
+
+	tests := []struct {
+		name string
+		opts MarkdownOptions
+		want string
+	}{
+		{
+			name: "Normal",
+			opts: MarkdownOptions{},
+			want: "### audit\n\n0 inserted, 0 updated, 1 deleted\n\n" +
+				"| (diff) | id |\n|---|---|\n" +
+				"| DELETED | 9 |\n\n" +
+				"### users\n\n1 inserted, 1 updated, 0 deleted\n\n" +
+				"| (diff) | id | name |\n|---|---|---|\n" +
+				"| UPD BEFORE | 1 | **alice** |\n" +
+				"| UPD  AFTER | 1 | **a\\|b** |\n" +
+				"| INSERTED | 2 | &lt;NULL&gt; |\n\n",
+		},
+		{
+			name: "Truncate",
+			opts: MarkdownOptions{MaxCellLength: 3, MaxSize: -1},
+			want: "### audit\n\n0 inserted, 0 updated, 1 deleted\n\n" +
+				"| (diff) | id |\n|---|---|\n" +
+				"| DELETED | 9 |\n\n" +
+				"### users\n\n1 inserted, 1 updated, 0 deleted\n\n" +
+				"| (diff) | id | nam… |\n|---|---|---|\n" +
+				"| UPD BEFORE | 1 | **ali…** |\n" +
+				"| UPD  AFTER | 1 | **a\\|b** |\n" +
+				"| INSERTED | 2 | &lt;NU… |\n\n",
+		},
+		{
+			name: "Omitted",
+			opts: MarkdownOptions{MaxSize: 200},
+			want: "### audit\n\n0 inserted, 0 updated, 1 deleted\n\n" +
+				"| (diff) | id |\n|---|---|\n" +
+				"| DELETED | 9 |\n\n" +
+				"_2 more rows omitted_\n",
+		},
+		{
+			// 最初の変更が収まらなければ、見出しも出さずにテーブルごと省略する
+			name: "OmittedUpdate",
+			opts: MarkdownOptions{MaxSize: 250},
+			want: "### audit\n\n0 inserted, 0 updated, 1 deleted\n\n" +
+				"| (diff) | id |\n|---|---|\n" +
+				"| DELETED | 9 |\n\n" +
+				"_2 more rows omitted_\n",
+		},
+		{
+			name: "OmittedInsert",
+			opts: MarkdownOptions{MaxSize: 300},
+			want: "### audit\n\n0 inserted, 0 updated, 1 deleted\n\n" +
+				"| (diff) | id |\n|---|---|\n" +
+				"| DELETED | 9 |\n\n" +
+				"### users\n\n1 inserted, 1 updated, 0 deleted\n\n" +
+				"| (diff) | id | name |\n|---|---|---|\n" +
+				"| UPD BEFORE | 1 | **alice** |\n" +
+				"| UPD  AFTER | 1 | **a\\|b** |\n" +
+				"\n_1 more rows omitted_\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &strings.Builder{}
+			if err := WriteMarkdown(builder, changedData, map[string][]string{"users": {"id"}, "audit": {"id"}}, tt.opts); err != nil {
+				t.Errorf("WriteMarkdown() error = %v", err)
+				return
+			}
+			if got := builder.String(); got != tt.want {
+				t.Errorf("WriteMarkdown() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
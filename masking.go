@@ -0,0 +1,224 @@
+package dbdiff
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Masking strategies
+const (
+	MaskRedact  = "redact"  // Replace the whole value with "<MASKED>"
+	MaskPartial = "partial" // Keep only the last 4 characters, e.g. "************1234"
+	MaskHash    = "hash"    // Deterministic hash like "hash:3f9a0c2e81b4d7a6", equal values give equal hashes
+	MaskFake    = "fake"    // Deterministic fake keeping the format(letters, digits and the other characters)
+)
+
+const maskedValue = "<MASKED>"
+
+// Number of characters kept by MaskPartial
+const maskPartialLength = 4
+
+// Masking of personal information in the reports
+type MaskingConfig struct {
+	// Key of the hash and the fake values. Without it, hashes of guessable values(e.g. phone numbers) can be reversed.
+	Salt string `yaml:"salt"`
+	// Rules applied in order, the first matching rule is used
+	Rules []MaskRule `yaml:"rules"`
+}
+
+// Rule to mask the values of the columns
+type MaskRule struct {
+	Table    string `yaml:"table"`    // Pattern of table names(path.Match), all tables if empty
+	Column   string `yaml:"column"`   // Pattern of column names(path.Match), e.g. "*email*"
+	Strategy string `yaml:"strategy"` // redact, partial, hash or fake
+}
+
+func (rule *MaskRule) validate() error {
+	switch rule.Strategy {
+	case MaskRedact, MaskPartial, MaskHash, MaskFake:
+	default:
+		return fmt.Errorf("masking strategy [%s] is invalid (redact|partial|hash|fake)", rule.Strategy)
+	}
+	if rule.Column == "" {
+		return fmt.Errorf("column of masking rule is required")
+	}
+	for _, p := range []string{rule.Table, rule.Column} {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid name pattern of masking rule [%s]: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// Masking of the configuration: masking of each table(tables.<name>.masking) precedes the global rules.
+func (c *Configuration) EffectiveMasking() MaskingConfig {
+	masking := MaskingConfig{Salt: c.Masking.Salt}
+	for _, tableName := range sortedTableConfigNames(c.Tables) {
+		columns := c.Tables[tableName].Masking
+		names := make([]string, 0, len(columns))
+		for column := range columns {
+			names = append(names, column)
+		}
+		sort.Strings(names)
+		for _, column := range names {
+			masking.Rules = append(masking.Rules, MaskRule{Table: tableName, Column: column, Strategy: columns[column]})
+		}
+	}
+	masking.Rules = append(masking.Rules, c.Masking.Rules...)
+	return masking
+}
+
+// Copy of changed data with the values masked by the rules. NULL is not masked.
+//
+// Apply it to the result of the comparison before writing, so that the comparison itself uses the real values.
+// Modified columns are kept even if the masked values are the same.
+func MaskChanges(changedData map[string][]*RowObject, masking MaskingConfig) (map[string][]*RowObject, error) {
+	for i := range masking.Rules {
+		if err := masking.Rules[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	if len(masking.Rules) == 0 {
+		return changedData, nil
+	}
+
+	output := make(map[string][]*RowObject, len(changedData))
+	for tableName, rows := range changedData {
+		if rows == nil {
+			output[tableName] = nil
+			continue
+		}
+		var strategies []string
+		masked := make([]*RowObject, len(rows))
+		for i, row := range rows {
+			// 同じテーブルの行はカラムも同じなので、ルールの照合は1回だけにする
+			if i == 0 || len(strategies) != len(row.ColumnNames) {
+				strategies = masking.columnStrategies(tableName, row.ColumnNames)
+			}
+			masked[i] = masking.maskRow(row, strategies)
+		}
+		output[tableName] = masked
+	}
+	return output, nil
+}
+
+// Strategy of each column, empty if not masked
+func (m *MaskingConfig) columnStrategies(tableName string, columnNames []string) []string {
+	strategies := make([]string, len(columnNames))
+	for index, colName := range columnNames {
+		for _, rule := range m.Rules {
+			if matchName(rule.Table, tableName) && matchName(rule.Column, colName) {
+				strategies[index] = rule.Strategy
+				break
+			}
+		}
+	}
+	return strategies
+}
+
+func (m *MaskingConfig) maskRow(row *RowObject, strategies []string) *RowObject {
+	masked := &RowObject{
+		DiffStatus:          row.DiffStatus,
+		ModifiedColumnIndex: append([]uint8{}, row.ModifiedColumnIndex...),
+		ColumnNames:         row.ColumnNames,
+		ColScans:            make([]*ColumnScan, len(row.ColScans)),
+		IsBeforeData:        row.IsBeforeData,
+	}
+	for index, colScan := range row.ColScans {
+		masked.ColScans[index] = colScan
+		changes := row.ColumnPathChanges(index)
+		if index >= len(strategies) || strategies[index] == "" {
+			if changes != nil {
+				masked.setColumnPathChanges(index, changes)
+			}
+			continue
+		}
+		// JSON、XMLの変更されたパスの値もマスクする
+		if changes != nil {
+			maskedChanges := make([]PathChange, len(changes))
+			for i, change := range changes {
+				maskedChanges[i] = PathChange{Path: change.Path, Before: m.maskPathValue(strategies[index], change.Before),
+					After: m.maskPathValue(strategies[index], change.After)}
+			}
+			masked.setColumnPathChanges(index, maskedChanges)
+		}
+		value := colScan.GetValueString()
+		if value == "<NULL>" {
+			continue
+		}
+		masked.ColScans[index] = &ColumnScan{Value: &sql.NullString{String: m.mask(strategies[index], value), Valid: true}}
+	}
+	return masked
+}
+
+// Masked value of a path, empty(added or removed) is kept
+func (m *MaskingConfig) maskPathValue(strategy string, value string) string {
+	if value == "" {
+		return ""
+	}
+	return m.mask(strategy, value)
+}
+
+func (m *MaskingConfig) mask(strategy string, value string) string {
+	switch strategy {
+	case MaskPartial:
+		runes := []rune(value)
+		keep := maskPartialLength
+		if len(runes) <= keep {
+			keep = 0
+		}
+		return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:])
+	case MaskHash:
+		return "hash:" + hex.EncodeToString(m.digest(value, 0)[:8])
+	case MaskFake:
+		return m.fake(value)
+	default:
+		return maskedValue
+	}
+}
+
+// Same value with each letter and digit replaced by a pseudo random one of the same kind
+func (m *MaskingConfig) fake(value string) string {
+	var buf strings.Builder
+	var random []byte
+	for i, r := range []rune(value) {
+		// 1文字あたり2バイト使い、足りなくなったらハッシュを継ぎ足す
+		if len(random) < 2 {
+			random = m.digest(value, uint64(i)+1)
+		}
+		n := int(binary.BigEndian.Uint16(random))
+		random = random[2:]
+		switch {
+		case r >= '0' && r <= '9':
+			buf.WriteRune(rune('0' + n%10))
+		case r >= 'a' && r <= 'z':
+			buf.WriteRune(rune('a' + n%26))
+		case r >= 'A' && r <= 'Z':
+			buf.WriteRune(rune('A' + n%26))
+		case unicode.IsLetter(r):
+			// ASCII以外の文字(漢字、かななど)は伏せ字にする
+			buf.WriteRune('*')
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// HMAC-SHA256 of the value keyed by the salt
+func (m *MaskingConfig) digest(value string, counter uint64) []byte {
+	mac := hmac.New(sha256.New, []byte(m.Salt))
+	var c [8]byte
+	binary.BigEndian.PutUint64(c[:], counter)
+	mac.Write(c[:])
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,99 @@
+package dbdiff
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestMaskingConfig_mask(t *testing.T) {
+	masking := &MaskingConfig{Salt: "salt"}
+	tests := []struct {
+		name     string
+		strategy string
+		value    string
+		want     string
+	}{
+		{"Redact", MaskRedact, "alice@example.com", "<MASKED>"},
+		{"Partial", MaskPartial, "4111-1111-1111-1234", "***************1234"},
+		{"PartialShort", MaskPartial, "1234", "****"},
+		{"PartialMultibyte", MaskPartial, "東京都千代田区", "***千代田区"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := masking.mask(tt.strategy, tt.value); got != tt.want {
+				t.Errorf("mask() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Hash", func(t *testing.T) {
+		got := masking.mask(MaskHash, "alice@example.com")
+		if !regexp.MustCompile(`^hash:[0-9a-f]{16}$`).MatchString(got) {
+			t.Errorf("mask() = %v, want hash:<16 hex digits>", got)
+		}
+		if again := masking.mask(MaskHash, "alice@example.com"); again != got {
+			t.Errorf("mask() = %v, want the same hash %v", again, got)
+		}
+		if other := (&MaskingConfig{Salt: "other"}).mask(MaskHash, "alice@example.com"); other == got {
+			t.Errorf("mask() with another salt = %v, want a different hash", other)
+		}
+	})
+	t.Run("Fake", func(t *testing.T) {
+		got := masking.mask(MaskFake, "Alice.Smith@example.com 090-1234-5678")
+		if !regexp.MustCompile(`^[A-Z][a-z]{4}\.[A-Z][a-z]{4}@[a-z]{7}\.[a-z]{3} \d{3}-\d{4}-\d{4}$`).MatchString(got) {
+			t.Errorf("mask() = %v, want the same format", got)
+		}
+		if got == "Alice.Smith@example.com 090-1234-5678" || masking.mask(MaskFake, "Alice.Smith@example.com 090-1234-5678") != got {
+			t.Errorf("mask() = %v, want a deterministic fake", got)
+		}
+	})
+}
+
+func TestMaskChanges(t *testing.T) {
+	columns := []string{"id", "email", "phone"}
+	changedData := map[string][]*RowObject{
+		"users": {
+			newTestRow(DiffStatusMod, true, columns, []string{"1", "alice@example.com", "<NULL>"}, 1),
+			newTestRow(DiffStatusMod, false, columns, []string{"1", "bob@example.com", "<NULL>"}, 1),
+		},
+		"orders": {
+			newTestRow(DiffStatusAdd, false, columns, []string{"2", "alice@example.com", "0312345678"}),
+		},
+	}
+	config := &Configuration{
+		Masking: MaskingConfig{Rules: []MaskRule{{Column: "*email*", Strategy: MaskHash}, {Column: "phone", Strategy: MaskRedact}}},
+		Tables:  map[string]TableConfig{"users": {Masking: map[string]string{"email": MaskRedact}}},
+	}
+
+	got, err := MaskChanges(changedData, config.EffectiveMasking())
+	if err != nil {
+		t.Fatalf("MaskChanges() error = %v", err)
+	}
+	values := func(row *RowObject) []string {
+		var values []string
+		for _, colScan := range row.ColScans {
+			values = append(values, colScan.GetValueString())
+		}
+		return values
+	}
+	// テーブルごとの設定が優先され、NULLはマスクしない
+	if want := []string{"1", "<MASKED>", "<NULL>"}; !reflect.DeepEqual(values(got["users"][0]), want) {
+		t.Errorf("users[0] = %v, want %v", values(got["users"][0]), want)
+	}
+	if !got["users"][1].IsModifiedColumn(1) {
+		t.Errorf("users[1] modified columns = %v, want email to be kept modified", got["users"][1].ModifiedColumnIndex)
+	}
+	hashed := config.Masking.mask(MaskHash, "alice@example.com")
+	if want := []string{"2", hashed, "<MASKED>"}; !reflect.DeepEqual(values(got["orders"][0]), want) {
+		t.Errorf("orders[0] = %v, want %v", values(got["orders"][0]), want)
+	}
+	// 元のデータは変更しない
+	if value := changedData["users"][0].ColScans[1].GetValueString(); value != "alice@example.com" {
+		t.Errorf("original value = %v, want alice@example.com", value)
+	}
+
+	if _, err := MaskChanges(changedData, MaskingConfig{Rules: []MaskRule{{Column: "email", Strategy: "shuffle"}}}); err == nil {
+		t.Errorf("MaskChanges() with invalid strategy error = nil, want error")
+	}
+}
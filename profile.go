@@ -0,0 +1,100 @@
+package dbdiff
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strings"
+)
+
+// Document of the profile: "defaults" merged with the profile in "profiles", or with the top level settings if profile is empty.
+//
+//	defaults:
+//	  db: {type: postgresql, port: 5432}
+//	profiles:
+//	  local:
+//	    db: {host: localhost}
+func selectProfile(document *yaml.Node, profile string) (*yaml.Node, error) {
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		if profile != "" {
+			return nil, fmt.Errorf("profile [%s] is not defined", profile)
+		}
+		return document, nil
+	}
+	root := document.Content[0]
+
+	var defaults, profiles *yaml.Node
+	topLevel := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: root.Line, Column: root.Column}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		switch root.Content[i].Value {
+		case "defaults":
+			defaults = root.Content[i+1]
+		case "profiles":
+			profiles = root.Content[i+1]
+		default:
+			topLevel.Content = append(topLevel.Content, root.Content[i], root.Content[i+1])
+		}
+	}
+
+	selected := topLevel
+	if profile != "" {
+		selected = mappingValue(profiles, profile)
+		if selected == nil {
+			return nil, fmt.Errorf("profile [%s] is not defined (profiles: %s)", profile, strings.Join(profileNames(profiles), ", "))
+		}
+	}
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{mergeNodes(defaults, selected)}}, nil
+}
+
+// Merge mappings recursively, values of override take precedence. Other values(sequences, scalars) are replaced.
+func mergeNodes(base *yaml.Node, override *yaml.Node) *yaml.Node {
+	if base == nil {
+		return override
+	}
+	if override.Kind == yaml.ScalarNode && override.Tag == "!!null" {
+		return base
+	}
+	if base.Kind != yaml.MappingNode || override.Kind != yaml.MappingNode {
+		return override
+	}
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: override.Line, Column: override.Column}
+	merged.Content = append(merged.Content, base.Content...)
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		key, value := override.Content[i], override.Content[i+1]
+		replaced := false
+		for j := 0; j+1 < len(merged.Content); j += 2 {
+			if merged.Content[j].Value == key.Value {
+				merged.Content[j+1] = mergeNodes(merged.Content[j+1], value)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged.Content = append(merged.Content, key, value)
+		}
+	}
+	return merged
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func profileNames(profiles *yaml.Node) []string {
+	var names []string
+	if profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			names = append(names, profiles.Content[i].Value)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
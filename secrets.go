@@ -0,0 +1,297 @@
+package dbdiff
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Source which supplied a configuration value, e.g. {"db.password", "password_file /run/secrets/db"}.
+// The value itself is not kept, so that it can be reported without printing secrets.
+type ValueSource struct {
+	Key    string
+	Source string
+}
+
+// "${NAME}", "${NAME:-default}" or "$${"(escaped "${")
+var envVarPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// Replace environment variables in all scalar values of the yaml document, and record the variables used for each value.
+// Variables which are not set are recorded in problems.
+func interpolateEnv(node *yaml.Node, key string, sources *[]ValueSource, problems *[]ConfigProblem) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for index, child := range node.Content {
+			childKey := key
+			if node.Kind == yaml.SequenceNode {
+				childKey = fmt.Sprintf("%s[%d]", key, index)
+			}
+			interpolateEnv(child, childKey, sources, problems)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			childKey := node.Content[i].Value
+			if key != "" {
+				childKey = key + "." + childKey
+			}
+			interpolateEnv(node.Content[i+1], childKey, sources, problems)
+		}
+	case yaml.ScalarNode:
+		var names []string
+		value := envVarPattern.ReplaceAllStringFunc(node.Value, func(s string) string {
+			if s == "$${" {
+				return "${"
+			}
+			match := envVarPattern.FindStringSubmatch(s)
+			names = append(names, match[1])
+			v, ok := os.LookupEnv(match[1])
+			if strings.Contains(s, ":-") && v == "" {
+				return match[2]
+			}
+			if !ok {
+				*problems = append(*problems, ConfigProblem{Line: node.Line,
+					Message: fmt.Sprintf("environment variable [%s] used in %s is not set", match[1], key)})
+			}
+			return v
+		})
+		if value != node.Value {
+			node.Value = value
+			// 置換後の値で型(数値、真偽値など)を判定し直す
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+		if len(names) > 0 {
+			*sources = append(*sources, ValueSource{Key: key, Source: "environment variable " + strings.Join(names, ", ")})
+		}
+	}
+}
+
+// Resolve db.password from password_file, password_command or a credentials file, and record the source.
+// Relative paths are resolved from baseDir(the directory of the configuration file).
+func resolveCredentials(db *Db, baseDir string, sources *[]ValueSource) error {
+	specified := 0
+	for _, v := range []string{db.Password, db.PasswordFile, db.PasswordCommand} {
+		if v != "" {
+			specified++
+		}
+	}
+	if specified > 1 {
+		return errors.New("only one of password, password_file and password_command can be specified")
+	}
+
+	switch {
+	case db.PasswordFile != "":
+		fileName := resolvePath(baseDir, db.PasswordFile)
+		buf, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			return fmt.Errorf("can not read password_file: %v", err)
+		}
+		db.Password = strings.TrimRight(string(buf), "\r\n")
+		*sources = append(*sources, ValueSource{Key: "db.password", Source: "password_file " + fileName})
+	case db.PasswordCommand != "":
+		password, err := runPasswordCommand(db.PasswordCommand)
+		if err != nil {
+			return err
+		}
+		db.Password = password
+		*sources = append(*sources, ValueSource{Key: "db.password", Source: "password_command"})
+	case db.Password == "":
+		fileName := resolvePath(baseDir, db.CredentialsFile)
+		// dsn, urlはパスワードを含むことがあるので、明示されたときだけ読む
+		if fileName == "" && db.DSN == "" && db.URL == "" {
+			fileName = defaultCredentialsFile(db.DbType)
+		}
+		if fileName == "" {
+			return nil
+		}
+		buf, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			if db.CredentialsFile == "" && os.IsNotExist(err) {
+				return nil
+			}
+			return fmt.Errorf("can not read credentials_file: %v", err)
+		}
+		var user, password string
+		if isIniFormat(buf) {
+			user, password = parseMyCnf(buf, db.User)
+		} else {
+			user, password = parsePgpass(buf, db)
+		}
+		if db.User == "" && user != "" {
+			db.User = user
+			*sources = append(*sources, ValueSource{Key: "db.user", Source: "credentials file " + fileName})
+		}
+		if password != "" {
+			db.Password = password
+			*sources = append(*sources, ValueSource{Key: "db.password", Source: "credentials file " + fileName})
+		}
+	}
+	return nil
+}
+
+func resolvePath(baseDir string, fileName string) string {
+	if fileName == "" || filepath.IsAbs(fileName) {
+		return fileName
+	}
+	return filepath.Join(baseDir, fileName)
+}
+
+// Run the command by the shell and use the first line of its output as the password
+func runPasswordCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	// パスフレーズの入力などのため、標準エラーと標準入力はそのまま渡す
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		// 出力はパスワードを含むことがあるので、エラーに含めない
+		return "", fmt.Errorf("password_command failed: %v", err)
+	}
+	return strings.TrimRight(strings.SplitN(string(output), "\n", 2)[0], "\r"), nil
+}
+
+// ~/.pgpass(or PGPASSFILE) for PostgreSQL, ~/.my.cnf for MySQL
+func defaultCredentialsFile(dbType string) string {
+	home, err := os.UserHomeDir()
+	switch dbType {
+	case "postgresql":
+		if fileName := os.Getenv("PGPASSFILE"); fileName != "" {
+			return fileName
+		}
+		if err == nil {
+			return filepath.Join(home, ".pgpass")
+		}
+	case "mysql":
+		if err == nil {
+			return filepath.Join(home, ".my.cnf")
+		}
+	}
+	return ""
+}
+
+// Whether the file is in INI format like my.cnf, not .pgpass format
+func isIniFormat(buf []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		return strings.HasPrefix(line, "[")
+	}
+	return false
+}
+
+// User and password of the first entry matching host:port:database:username. "*" matches any value.
+// If db.User is empty, any user matches.
+func parsePgpass(buf []byte, db *Db) (string, string) {
+	host, port := db.Host, db.Port
+	if host == "" {
+		host = "localhost"
+	}
+	if port == "" {
+		port = "5432"
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitPgpassLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		matched := true
+		for i, v := range []string{host, port, db.Name, db.User} {
+			if fields[i] != "*" && v != "" && fields[i] != v {
+				matched = false
+			}
+		}
+		if !matched {
+			continue
+		}
+		user := fields[3]
+		if user == "*" {
+			user = ""
+		}
+		return user, fields[4]
+	}
+	return "", ""
+}
+
+// Split by ":", "\:" and "\\" are escaped characters
+func splitPgpassLine(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case line[i] == ':':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(fields, field.String())
+}
+
+// User and password of [client] section of my.cnf.
+// The password is not used if the user in the file is different from user.
+func parseMyCnf(buf []byte, user string) (string, string) {
+	var fileUser, password string
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != "client" {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "user":
+			fileUser = value
+		case "password":
+			password = value
+		}
+	}
+	if user != "" && fileUser != "" && fileUser != user {
+		return "", ""
+	}
+	return fileUser, password
+}
@@ -0,0 +1,423 @@
+package dbdiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change of a value inside a JSON or XML column
+type PathChange struct {
+	Path   string // e.g. "$.settings.theme", "/config/item[2]/@name"
+	Before string // JSON(or XML) of the value, empty if added
+	After  string // JSON(or XML) of the value, empty if removed
+}
+
+// `$.settings.theme: "dark" -> "light"`, "(none)" for added or removed values
+func (pc PathChange) String() string {
+	before, after := pc.Before, pc.After
+	if before == "" {
+		before = "(none)"
+	}
+	if after == "" {
+		after = "(none)"
+	}
+	return pc.Path + ": " + before + " -> " + after
+}
+
+// Changes of the column at the index found by EqualColumnsWith(), nil if the column is not structured or not modified
+func (ro *RowObject) ColumnPathChanges(index int) []PathChange {
+	if index < 0 || index > 255 {
+		return nil
+	}
+	return ro.ModifiedPaths[uint8(index)]
+}
+
+func (ro *RowObject) setColumnPathChanges(index int, changes []PathChange) {
+	if ro.ModifiedPaths == nil {
+		ro.ModifiedPaths = map[uint8][]PathChange{}
+	}
+	ro.ModifiedPaths[uint8(index)] = changes
+}
+
+// Differ of structured values. leafEqual compares scalar values(JSON numbers and strings, XML texts and attributes).
+type structureDiffer struct {
+	ignoreArrayOrder bool
+	leafEqual        func(a string, b string, number bool) bool
+}
+
+// Changes between JSON documents. ok is false if any of them is not JSON.
+func (d *structureDiffer) diffJSON(a string, b string) (changes []PathChange, ok bool) {
+	x, errX := parseJSON(a)
+	y, errY := parseJSON(b)
+	if errX != nil || errY != nil {
+		return nil, false
+	}
+	d.diffJSONValues("$", x, y, &changes)
+	return changes, true
+}
+
+func parseJSON(s string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("extra data after JSON value")
+	}
+	return v, nil
+}
+
+func (d *structureDiffer) diffJSONValues(path string, x interface{}, y interface{}, changes *[]PathChange) {
+	switch xv := x.(type) {
+	case map[string]interface{}:
+		if yv, ok := y.(map[string]interface{}); ok {
+			// オブジェクトのキーの順序は無視する
+			for _, key := range unionKeys(xv, yv) {
+				childPath := jsonChildPath(path, key)
+				xc, inX := xv[key]
+				yc, inY := yv[key]
+				switch {
+				case !inX:
+					*changes = append(*changes, PathChange{Path: childPath, After: marshalJSON(yc)})
+				case !inY:
+					*changes = append(*changes, PathChange{Path: childPath, Before: marshalJSON(xc)})
+				default:
+					d.diffJSONValues(childPath, xc, yc, changes)
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if yv, ok := y.([]interface{}); ok {
+			d.diffJSONArrays(path, xv, yv, changes)
+			return
+		}
+	case json.Number:
+		if yv, ok := y.(json.Number); ok {
+			if !d.leafEqual(string(xv), string(yv), true) {
+				*changes = append(*changes, PathChange{Path: path, Before: string(xv), After: string(yv)})
+			}
+			return
+		}
+	case string:
+		if yv, ok := y.(string); ok {
+			if !d.leafEqual(xv, yv, false) {
+				*changes = append(*changes, PathChange{Path: path, Before: marshalJSON(xv), After: marshalJSON(yv)})
+			}
+			return
+		}
+	}
+	if before, after := marshalJSON(x), marshalJSON(y); before != after {
+		*changes = append(*changes, PathChange{Path: path, Before: before, After: after})
+	}
+}
+
+func (d *structureDiffer) diffJSONArrays(path string, x []interface{}, y []interface{}, changes *[]PathChange) {
+	if !d.ignoreArrayOrder {
+		for i := 0; i < len(x) || i < len(y); i++ {
+			childPath := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(x):
+				*changes = append(*changes, PathChange{Path: childPath, After: marshalJSON(y[i])})
+			case i >= len(y):
+				*changes = append(*changes, PathChange{Path: childPath, Before: marshalJSON(x[i])})
+			default:
+				d.diffJSONValues(childPath, x[i], y[i], changes)
+			}
+		}
+		return
+	}
+
+	// 順序を無視する場合は、同じ要素を対応付けて残りを削除と追加にする
+	matched := make([]bool, len(y))
+	for i, xe := range x {
+		found := false
+		for j, ye := range y {
+			if matched[j] {
+				continue
+			}
+			var elementChanges []PathChange
+			d.diffJSONValues("", xe, ye, &elementChanges)
+			if len(elementChanges) == 0 {
+				matched[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			*changes = append(*changes, PathChange{Path: path + "[" + strconv.Itoa(i) + "]", Before: marshalJSON(xe)})
+		}
+	}
+	for j, ye := range y {
+		if !matched[j] {
+			*changes = append(*changes, PathChange{Path: path + "[" + strconv.Itoa(j) + "]", After: marshalJSON(ye)})
+		}
+	}
+}
+
+func unionKeys(x map[string]interface{}, y map[string]interface{}) []string {
+	keys := make([]string, 0, len(x)+len(y))
+	for key := range x {
+		keys = append(keys, key)
+	}
+	for key := range y {
+		if _, ok := x[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// "$.name", or "$['first name']" if the key is not an identifier
+func jsonChildPath(path string, key string) string {
+	if key != "" && strings.IndexFunc(key, func(r rune) bool {
+		return !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	}) < 0 && !(key[0] >= '0' && key[0] <= '9') {
+		return path + "." + key
+	}
+	return path + "['" + strings.Replace(key, "'", `\'`, -1) + "']"
+}
+
+// Canonical form of a JSON(kind is CompareTypeJSON) or XML(CompareTypeXML) value: JSON with the keys sorted and XML
+// with the attributes sorted, both without insignificant whitespace. The value as it is if it can not be parsed.
+// Values equal by the structural comparison without rules have the same canonical form, except numbers like 1.0 and 1.
+func canonicalStructure(kind string, value string) string {
+	switch kind {
+	case CompareTypeJSON:
+		if v, err := parseJSON(value); err == nil {
+			return marshalJSON(v)
+		}
+	case CompareTypeXML:
+		if node, err := parseXML(value); err == nil {
+			return node.String()
+		}
+	}
+	return value
+}
+
+// Compact JSON with the keys sorted
+func marshalJSON(v interface{}) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// Element of an XML document. Comments and processing instructions are ignored.
+type xmlNode struct {
+	name     string
+	attrs    map[string]string
+	text     string // trimmed text content
+	children []*xmlNode
+}
+
+// Changes between XML documents. ok is false if any of them is not XML.
+func (d *structureDiffer) diffXML(a string, b string) (changes []PathChange, ok bool) {
+	x, errX := parseXML(a)
+	y, errY := parseXML(b)
+	if errX != nil || errY != nil {
+		return nil, false
+	}
+	if x.name != y.name {
+		return []PathChange{{Path: "/", Before: x.String(), After: y.String()}}, true
+	}
+	d.diffXMLNodes("/"+x.name, x, y, &changes)
+	return changes, true
+}
+
+func parseXML(s string) (*xmlNode, error) {
+	decoder := xml.NewDecoder(strings.NewReader(s))
+	var root *xmlNode
+	var stack []*xmlNode
+	var texts []*strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if root != nil && len(stack) == 0 {
+				return nil, errors.New("multiple root elements")
+			}
+			node := &xmlNode{name: t.Name.Local, attrs: map[string]string{}}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+					continue
+				}
+				node.attrs[attr.Name.Local] = attr.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else {
+				root = node
+			}
+			stack = append(stack, node)
+			texts = append(texts, &strings.Builder{})
+		case xml.EndElement:
+			stack[len(stack)-1].text = strings.TrimSpace(texts[len(texts)-1].String())
+			stack = stack[:len(stack)-1]
+			texts = texts[:len(texts)-1]
+		case xml.CharData:
+			if len(texts) > 0 {
+				texts[len(texts)-1].Write(t)
+			} else if len(bytes.TrimSpace(t)) > 0 {
+				return nil, errors.New("text outside of the root element")
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New("no root element")
+	}
+	return root, nil
+}
+
+func (d *structureDiffer) diffXMLNodes(path string, x *xmlNode, y *xmlNode, changes *[]PathChange) {
+	names := make([]string, 0, len(x.attrs)+len(y.attrs))
+	for name := range x.attrs {
+		names = append(names, name)
+	}
+	for name := range y.attrs {
+		if _, ok := x.attrs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		xa, inX := x.attrs[name]
+		ya, inY := y.attrs[name]
+		switch {
+		case !inX:
+			*changes = append(*changes, PathChange{Path: path + "/@" + name, After: strconv.Quote(ya)})
+		case !inY:
+			*changes = append(*changes, PathChange{Path: path + "/@" + name, Before: strconv.Quote(xa)})
+		case !d.leafEqual(xa, ya, false):
+			*changes = append(*changes, PathChange{Path: path + "/@" + name, Before: strconv.Quote(xa), After: strconv.Quote(ya)})
+		}
+	}
+	if !d.leafEqual(x.text, y.text, false) {
+		*changes = append(*changes, PathChange{Path: path + "/text()", Before: strconv.Quote(x.text), After: strconv.Quote(y.text)})
+	}
+
+	// 子要素は名前ごとに、出現順(または順序を無視して)対応付ける
+	xChildren, xNames := groupXMLChildren(x.children)
+	yChildren, yNames := groupXMLChildren(y.children)
+	for _, name := range yNames {
+		if _, ok := xChildren[name]; !ok {
+			xNames = append(xNames, name)
+		}
+	}
+	for _, name := range xNames {
+		xs, ys := xChildren[name], yChildren[name]
+		childPath := func(i int) string {
+			if len(xs) <= 1 && len(ys) <= 1 {
+				return path + "/" + name
+			}
+			return path + "/" + name + "[" + strconv.Itoa(i+1) + "]"
+		}
+		if d.ignoreArrayOrder {
+			xs, ys = d.removeEqualXMLNodes(xs, ys)
+		}
+		for i := 0; i < len(xs) || i < len(ys); i++ {
+			switch {
+			case i >= len(xs):
+				*changes = append(*changes, PathChange{Path: childPath(i), After: ys[i].String()})
+			case i >= len(ys):
+				*changes = append(*changes, PathChange{Path: childPath(i), Before: xs[i].String()})
+			default:
+				d.diffXMLNodes(childPath(i), xs[i], ys[i], changes)
+			}
+		}
+	}
+}
+
+// Nodes which have no equal node in the other list
+func (d *structureDiffer) removeEqualXMLNodes(xs []*xmlNode, ys []*xmlNode) ([]*xmlNode, []*xmlNode) {
+	matched := make([]bool, len(ys))
+	var restX []*xmlNode
+	for _, xn := range xs {
+		found := false
+		for j, yn := range ys {
+			if matched[j] {
+				continue
+			}
+			var nodeChanges []PathChange
+			d.diffXMLNodes("", xn, yn, &nodeChanges)
+			if len(nodeChanges) == 0 {
+				matched[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			restX = append(restX, xn)
+		}
+	}
+	var restY []*xmlNode
+	for j, yn := range ys {
+		if !matched[j] {
+			restY = append(restY, yn)
+		}
+	}
+	return restX, restY
+}
+
+func groupXMLChildren(children []*xmlNode) (map[string][]*xmlNode, []string) {
+	groups := map[string][]*xmlNode{}
+	var names []string
+	for _, child := range children {
+		if _, ok := groups[child.name]; !ok {
+			names = append(names, child.name)
+		}
+		groups[child.name] = append(groups[child.name], child)
+	}
+	return groups, names
+}
+
+// XML of the node with the attributes sorted
+func (n *xmlNode) String() string {
+	var buf bytes.Buffer
+	n.write(&buf)
+	return buf.String()
+}
+
+func (n *xmlNode) write(buf *bytes.Buffer) {
+	buf.WriteString("<" + n.name)
+	names := make([]string, 0, len(n.attrs))
+	for name := range n.attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		buf.WriteString(" " + name + `="`)
+		_ = xml.EscapeText(buf, []byte(n.attrs[name]))
+		buf.WriteString(`"`)
+	}
+	if n.text == "" && len(n.children) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteString(">")
+	_ = xml.EscapeText(buf, []byte(n.text))
+	for _, child := range n.children {
+		child.write(buf)
+	}
+	buf.WriteString("</" + n.name + ">")
+}
@@ -0,0 +1,121 @@
+package dbdiff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_structureDiffer_diffJSON(t *testing.T) {
+	rule := &compiledCompareRule{}
+	tests := []struct {
+		name             string
+		ignoreArrayOrder bool
+		a                string
+		b                string
+		want             []string
+	}{
+		{"KeyOrder", false, `{"a": 1, "b": {"c": "x"}}`, `{"b": {"c": "x"}, "a": 1.0}`, nil},
+		{"Nested", false, `{"settings": {"theme": "dark", "lang": "ja"}}`, `{"settings": {"theme": "light", "lang": "ja"}}`,
+			[]string{`$.settings.theme: "dark" -> "light"`}},
+		{"AddedRemoved", false, `{"a": 1, "b": [1, 2]}`, `{"b": [1], "first name": null}`,
+			[]string{`$.a: 1 -> (none)`, `$.b[1]: 2 -> (none)`, `$['first name']: (none) -> null`}},
+		{"TypeChanged", false, `{"a": "1"}`, `{"a": 1}`, []string{`$.a: "1" -> 1`}},
+		{"ArrayOrder", false, `[1, 2, 3]`, `[3, 2, 1]`, []string{`$[0]: 1 -> 3`, `$[2]: 3 -> 1`}},
+		{"IgnoreArrayOrder", true, `[1, {"x": 2}, 3]`, `[3, {"x": 2}, 1]`, nil},
+		{"IgnoreArrayOrderChanged", true, `["a", "b"]`, `["b", "c"]`, []string{`$[0]: "a" -> (none)`, `$[1]: (none) -> "c"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			differ := &structureDiffer{ignoreArrayOrder: tt.ignoreArrayOrder, leafEqual: rule.equalLeaves}
+			changes, ok := differ.diffJSON(tt.a, tt.b)
+			if !ok {
+				t.Fatalf("diffJSON() ok = false")
+			}
+			var got []string
+			for _, change := range changes {
+				got = append(got, change.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffJSON() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := (&structureDiffer{leafEqual: rule.equalLeaves}).diffJSON(`{"a": 1}`, `{"a": 1} x`); ok {
+		t.Errorf("diffJSON() of invalid JSON ok = true, want false")
+	}
+}
+
+func Test_structureDiffer_diffXML(t *testing.T) {
+	rule := &compiledCompareRule{CompareRule: CompareRule{TrimSpace: true}}
+	tests := []struct {
+		name             string
+		ignoreArrayOrder bool
+		a                string
+		b                string
+		want             []string
+	}{
+		{"AttributeOrder", false, `<?xml version="1.0"?><a x="1" y="2"><b>text</b><!-- c --></a>`, `<a y="2" x="1">
+  <b> text </b>
+</a>`, nil},
+		{"Changed", false, `<config><theme>dark</theme><item id="1"/><item id="2"/></config>`,
+			`<config><theme>light</theme><item id="1"/><item id="3"/><new/></config>`,
+			[]string{`/config/theme/text(): "dark" -> "light"`, `/config/item[2]/@id: "2" -> "3"`, `/config/new: (none) -> <new/>`}},
+		{"IgnoreArrayOrder", true, `<l><i>1</i><i>2</i></l>`, `<l><i>2</i><i>1</i></l>`, nil},
+		{"Root", false, `<a/>`, `<b x="&amp;"/>`, []string{`/: <a/> -> <b x="&amp;"/>`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			differ := &structureDiffer{ignoreArrayOrder: tt.ignoreArrayOrder, leafEqual: rule.equalLeaves}
+			changes, ok := differ.diffXML(tt.a, tt.b)
+			if !ok {
+				t.Fatalf("diffXML() ok = false")
+			}
+			var got []string
+			for _, change := range changes {
+				got = append(got, change.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffXML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteTerminal_PathChanges(t *testing.T) {
+	comparer, err := NewValueComparer(ComparisonConfig{Rules: []CompareRule{{Column: "settings", Type: CompareTypeJSON}}})
+	if err != nil {
+		t.Fatalf("NewValueComparer() error = %v", err)
+	}
+	columns := []string{"id", "settings"}
+	before := newTestRow(DiffStatusMod, true, columns, []string{"1", `{"theme": "dark", "lang": "ja"}`})
+	after := newTestRow(DiffStatusMod, false, columns, []string{"1", `{"lang": "ja", "theme": "light"}`})
+	if before.EqualColumnsWith(after, comparer.ForTable("users")) {
+		t.Fatalf("EqualColumnsWith() = true, want false")
+	}
+
+	builder := strings.Builder{}
+	err = WriteTerminal(&builder, map[string][]*RowObject{"users": {before, after}}, map[string][]string{"users": {"id"}}, TerminalOptions{})
+	if err != nil {
+		t.Fatalf("WriteTerminal() error = %v", err)
+	}
+	want := `=== users (0 inserted, 1 updated, 0 deleted) ===
+~ id=1
+    settings:
+      $.theme: "dark" -> "light"
+
+`
+	if got := builder.String(); got != want {
+		t.Errorf("WriteTerminal() = %q, want %q", got, want)
+	}
+
+	// マスクしたカラムはパスの値もマスクする
+	masked, err := MaskChanges(map[string][]*RowObject{"users": {before, after}}, MaskingConfig{Rules: []MaskRule{{Column: "settings", Strategy: MaskRedact}}})
+	if err != nil {
+		t.Fatalf("MaskChanges() error = %v", err)
+	}
+	if got := masked["users"][1].ColumnPathChanges(1); len(got) != 1 || got[0].String() != "$.theme: <MASKED> -> <MASKED>" {
+		t.Errorf("ColumnPathChanges() = %v, want masked values", got)
+	}
+}
@@ -0,0 +1,183 @@
+package dbdiff
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ANSI escape sequences
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// Options for WriteTerminal
+type TerminalOptions struct {
+	// Use ANSI colors
+	Color bool
+	// Show all columns of the changed rows, not only the modified columns
+	Verbose bool
+	// Effective filter of each table(see Configuration#EffectiveFilters()), shown under the table header
+	Filters map[string]string
+}
+
+// Whether the file is a terminal(character device).
+//
+// Always false if NO_COLOR environment variable is set.
+func IsColorTerminal(f *os.File) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// Write changed data in a compact, unified-diff like style.
+//
+//	=== users (1 inserted, 1 updated, 0 deleted) ===
+//	+ id=2
+//	~ id=1
+//	    name: alice -> bob
+//	    settings:
+//	      $.theme: "dark" -> "light"
+//
+// Rows are sorted by key. tablePks is used to show the key of each row.
+func WriteTerminal(w io.Writer, changedData map[string][]*RowObject, tablePks map[string][]string, opts TerminalOptions) error {
+	color := func(code string, s string) string {
+		if !opts.Color {
+			return s
+		}
+		return code + s + ansiReset
+	}
+
+	builder := strings.Builder{}
+	changedTableCount := 0
+	for _, tableName := range SortedTableNames(changedData) {
+		rows := changedData[tableName]
+		if len(rows) == 0 {
+			continue
+		}
+		changedTableCount++
+
+		summary := SummarizeChanges(rows)
+		counts := fmt.Sprintf("%d inserted, %d updated, %d deleted", summary.Inserted, summary.Updated, summary.Deleted)
+		if summary.KeyChanged > 0 {
+			counts += fmt.Sprintf(", %d key changed", summary.KeyChanged)
+		}
+		builder.WriteString(color(ansiBold+ansiCyan, fmt.Sprintf("=== %s (%s) ===", tableName, counts)))
+		builder.WriteString("\n")
+		if filter := opts.Filters[tableName]; filter != "" {
+			builder.WriteString(color(ansiCyan, "filter: "+filter))
+			builder.WriteString("\n")
+		}
+
+		pkColumns := tablePks[tableName]
+		changes := GroupChanges(rows)
+		byKey := newRowChangesByKey(changes, pkColumns)
+		// キーが同じ行(正規化されたキーなど)は元の順序を保つ
+		sort.Stable(byKey)
+		keys := byKey.keys
+
+		for i, change := range changes {
+			switch change.DiffStatus() {
+			case DiffStatusAdd:
+				builder.WriteString(color(ansiGreen, "+ "+keys[i]))
+				builder.WriteString("\n")
+				if opts.Verbose {
+					writeTerminalColumns(&builder, change.After, func(s string) string { return color(ansiGreen, s) })
+				}
+			case DiffStatusDel:
+				builder.WriteString(color(ansiRed, "- "+keys[i]))
+				builder.WriteString("\n")
+				if opts.Verbose {
+					writeTerminalColumns(&builder, change.Before, func(s string) string { return color(ansiRed, s) })
+				}
+			case DiffStatusMod, DiffStatusKeyChanged:
+				label := "~ " + keys[i]
+				if change.DiffStatus() == DiffStatusKeyChanged && change.After != nil {
+					label += " -> " + change.After.KeyString(pkColumns) + " (KEY CHANGED)"
+				}
+				builder.WriteString(color(ansiYellow, label))
+				builder.WriteString("\n")
+				if change.Before == nil || change.After == nil {
+					writeTerminalColumns(&builder, change.Row(), func(s string) string { return s })
+					continue
+				}
+				for index, colName := range change.Before.ColumnNames {
+					modified := change.Before.IsModifiedColumn(index)
+					if !modified && !opts.Verbose {
+						continue
+					}
+					before := change.Before.ColScans[index].GetValueString()
+					if !modified {
+						builder.WriteString(fmt.Sprintf("    %s: %s\n", colName, before))
+						continue
+					}
+					if changes := change.Before.ColumnPathChanges(index); changes != nil {
+						// JSON、XMLは値全体ではなく変更されたパスを出す
+						builder.WriteString(fmt.Sprintf("    %s:\n", color(ansiBold, colName)))
+						for _, pathChange := range changes {
+							builder.WriteString(fmt.Sprintf("      %s\n", color(ansiYellow, pathChange.String())))
+						}
+						continue
+					}
+					after := "<MISSING>"
+					if index < len(change.After.ColScans) {
+						after = change.After.ColScans[index].GetValueString()
+					}
+					builder.WriteString(fmt.Sprintf("    %s: %s -> %s\n", color(ansiBold, colName), color(ansiRed, before), color(ansiGreen, after)))
+				}
+			}
+		}
+		builder.WriteString("\n")
+	}
+	if changedTableCount == 0 {
+		builder.WriteString("No differences.\n")
+	}
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func writeTerminalColumns(builder *strings.Builder, row *RowObject, color func(string) string) {
+	for index, colName := range row.ColumnNames {
+		builder.WriteString(color(fmt.Sprintf("    %s: %s", colName, row.ColScans[index].GetValueString())))
+		builder.WriteString("\n")
+	}
+}
+
+// Sorts changes by key in the same order as the golden files(numerically if possible)
+type rowChangesByKey struct {
+	changes []RowChange
+	keys    []string   // 表示用のキー
+	values  [][]string // 比較用のキーの値
+}
+
+func newRowChangesByKey(changes []RowChange, pkColumns []string) *rowChangesByKey {
+	r := &rowChangesByKey{changes: changes, keys: make([]string, len(changes)), values: make([][]string, len(changes))}
+	for i, change := range changes {
+		r.keys[i] = change.Row().KeyString(pkColumns)
+		r.values[i] = keyValues(change.Row(), pkColumns)
+	}
+	return r
+}
+
+func (r *rowChangesByKey) Len() int { return len(r.changes) }
+func (r *rowChangesByKey) Less(i, j int) bool {
+	return compareKeyValues(r.values[i], r.values[j]) < 0
+}
+func (r *rowChangesByKey) Swap(i, j int) {
+	r.changes[i], r.changes[j] = r.changes[j], r.changes[i]
+	r.keys[i], r.keys[j] = r.keys[j], r.keys[i]
+	r.values[i], r.values[j] = r.values[j], r.values[i]
+}
@@ -0,0 +1,74 @@
+package dbdiff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTerminal(t *testing.T) {
+	columns := []string{"id", "name", "age"}
+	changedData := map[string][]*RowObject{
+		"users": {
+			// 文字列順ではid=10がid=2より前になる
+			newTestRow(DiffStatusAdd, false, columns, []string{"10", "carol", "30"}),
+			newTestRow(DiffStatusMod, true, columns, []string{"1", "alice", "20"}, 1),
+			newTestRow(DiffStatusMod, false, columns, []string{"1", "bob", "20"}, 1),
+			newTestRow(DiffStatusDel, true, columns, []string{"2", "dave", "<NULL>"}),
+		},
+	}
+	tablePks := map[string][]string{"users": {"id"}}
+
+	tests := []struct {
+		name string
+		opts TerminalOptions
+		want string
+	}{
+		{
+			name: "Compact",
+			opts: TerminalOptions{},
+			want: "=== users (1 inserted, 1 updated, 1 deleted) ===\n" +
+				"~ id=1\n" +
+				"    name: alice -> bob\n" +
+				"- id=2\n" +
+				"+ id=10\n\n",
+		},
+		{
+			name: "Verbose",
+			opts: TerminalOptions{Verbose: true},
+			want: "=== users (1 inserted, 1 updated, 1 deleted) ===\n" +
+				"~ id=1\n" +
+				"    id: 1\n" +
+				"    name: alice -> bob\n" +
+				"    age: 20\n" +
+				"- id=2\n" +
+				"    id: 2\n" +
+				"    name: dave\n" +
+				"    age: <NULL>\n" +
+				"+ id=10\n" +
+				"    id: 10\n" +
+				"    name: carol\n" +
+				"    age: 30\n\n",
+		},
+		{
+			name: "Color",
+			opts: TerminalOptions{Color: true},
+			want: "\x1b[1m\x1b[36m=== users (1 inserted, 1 updated, 1 deleted) ===\x1b[0m\n" +
+				"\x1b[33m~ id=1\x1b[0m\n" +
+				"    \x1b[1mname\x1b[0m: \x1b[31malice\x1b[0m -> \x1b[32mbob\x1b[0m\n" +
+				"\x1b[31m- id=2\x1b[0m\n" +
+				"\x1b[32m+ id=10\x1b[0m\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &strings.Builder{}
+			if err := WriteTerminal(builder, changedData, tablePks, tt.opts); err != nil {
+				t.Errorf("WriteTerminal() error = %v", err)
+				return
+			}
+			if got := builder.String(); got != tt.want {
+				t.Errorf("WriteTerminal() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package dbdiff
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/stdlib"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// TLS modes
+const (
+	TLSModeDisable    = "disable"     // Do not use TLS
+	TLSModeRequire    = "require"     // Use TLS without verifying the server certificate
+	TLSModeVerifyCA   = "verify-ca"   // Verify the server certificate is signed by a trusted CA
+	TLSModeVerifyFull = "verify-full" // Verify the server certificate and the host name
+)
+
+// TLS configuration of the database connection
+type TLSConfig struct {
+	// disable, require, verify-ca or verify-full. The default of the driver if empty.
+	Mode string `yaml:"mode"`
+	// PEM file of the CA certificates to verify the server certificate. The system CAs if empty.
+	CAFile string `yaml:"ca_file"`
+	// PEM files of the client certificate and its key
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// Host name to verify the server certificate, the host of the connection if empty
+	ServerName string `yaml:"server_name"`
+	// Do not verify the server certificate. For development only.
+	SkipVerify bool `yaml:"skip_verify"`
+}
+
+// Check the mode and the certificate files, and resolve relative paths from baseDir(the directory of the configuration file)
+func (t *TLSConfig) validate(dbType string, baseDir string) error {
+	switch t.Mode {
+	case "", TLSModeDisable, TLSModeRequire, TLSModeVerifyCA, TLSModeVerifyFull:
+	default:
+		return fmt.Errorf("tls.mode [%s] is invalid (disable|require|verify-ca|verify-full)", t.Mode)
+	}
+	if t.Mode == "" || t.Mode == TLSModeDisable {
+		if t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" || t.ServerName != "" || t.SkipVerify {
+			return errors.New("tls.mode is required to use tls settings")
+		}
+		return nil
+	}
+	if t.SkipVerify && (t.Mode == TLSModeVerifyCA || t.Mode == TLSModeVerifyFull) {
+		return fmt.Errorf("tls.skip_verify can not be used with tls.mode %s", t.Mode)
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("both tls.cert_file and tls.key_file are required to use a client certificate")
+	}
+	if t.CertFile != "" && dbType == "mssql" {
+		return errors.New("client certificates are not supported by the MS SQL Server driver")
+	}
+	t.CAFile = resolvePath(baseDir, t.CAFile)
+	t.CertFile = resolvePath(baseDir, t.CertFile)
+	t.KeyFile = resolvePath(baseDir, t.KeyFile)
+
+	if t.CAFile != "" {
+		if _, err := loadCertPool(t.CAFile); err != nil {
+			return err
+		}
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return fmt.Errorf("can not load tls.cert_file %s and tls.key_file %s: %v", t.CertFile, t.KeyFile, err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("can not parse tls.cert_file %s: %v", t.CertFile, err)
+		}
+		if now := time.Now(); now.After(leaf.NotAfter) || now.Before(leaf.NotBefore) {
+			return fmt.Errorf("tls.cert_file %s is not valid now (valid from %v to %v)", t.CertFile, leaf.NotBefore, leaf.NotAfter)
+		}
+	}
+	if t.SkipVerify {
+		log.Printf("tls.skip_verify is enabled, the server certificate is not verified. Use it for development only.\n")
+	}
+	return nil
+}
+
+func (t *TLSConfig) enabled() bool {
+	return t.Mode != "" && t.Mode != TLSModeDisable
+}
+
+// crypto/tls configuration to connect to host
+func (t *TLSConfig) clientConfig(host string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: t.ServerName}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	if t.CAFile != "" {
+		pool, err := loadCertPool(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can not load tls.cert_file %s and tls.key_file %s: %v", t.CertFile, t.KeyFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	switch {
+	case t.SkipVerify || t.Mode == TLSModeRequire:
+		config.InsecureSkipVerify = true
+	case t.Mode == TLSModeVerifyCA:
+		// 証明書チェーンだけを検証し、ホスト名は検証しない
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyCertificateChain(rawCerts, config.RootCAs)
+		}
+	}
+	return config, nil
+}
+
+func verifyCertificateChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errors.New("tls: no server certificate")
+	}
+	intermediates := x509.NewCertPool()
+	var leaf *x509.Certificate
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			leaf = cert
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	return err
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	buf, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("can not read tls.ca_file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, fmt.Errorf("tls.ca_file %s does not contain any PEM certificate", caFile)
+	}
+	return pool, nil
+}
+
+// Parameters of the connection string for the TLS configuration
+func tlsParams(dbConfig *Db) map[string]string {
+	t := &dbConfig.TLS
+	if t.Mode == "" {
+		return nil
+	}
+	switch dbConfig.DbType {
+	case "postgresql":
+		// pgxのverify-caはホスト名も検証するので、verify-caはDriverConfigで設定する(openPostgreSQL()を参照)
+		params := map[string]string{"sslmode": t.Mode}
+		if t.SkipVerify {
+			params["sslmode"] = TLSModeRequire
+		}
+		if t.CAFile != "" {
+			params["sslrootcert"] = t.CAFile
+		}
+		if t.CertFile != "" {
+			params["sslcert"] = t.CertFile
+			params["sslkey"] = t.KeyFile
+		}
+		return params
+	case "mssql":
+		if !t.enabled() {
+			return map[string]string{"encrypt": "disable"}
+		}
+		params := map[string]string{"encrypt": "true", "TrustServerCertificate": "false"}
+		if t.SkipVerify || t.Mode == TLSModeRequire {
+			params["TrustServerCertificate"] = "true"
+		}
+		if t.CAFile != "" {
+			params["certificate"] = t.CAFile
+		}
+		if t.ServerName != "" {
+			params["hostNameInCertificate"] = t.ServerName
+		}
+		return params
+	}
+	return nil
+}
+
+var mysqlTLSConfigCount int64
+
+// Register the TLS configuration to go-sql-driver/mysql and return the value of "tls" parameter
+func registerMySQLTLSConfig(t *TLSConfig, addr string) (string, error) {
+	if !t.enabled() {
+		return "false", nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	config, err := t.clientConfig(host)
+	if err != nil {
+		return "", err
+	}
+	name := "dbdiff" + strconv.FormatInt(atomic.AddInt64(&mysqlTLSConfigCount, 1), 10)
+	if err := mysql.RegisterTLSConfig(name, config); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Connection string of pgx using the TLS configuration of crypto/tls, for the settings not supported by the parameters
+// of pgx(verify-ca without verifying the host name, server_name, skip_verify).
+func postgreSQLDriverConfig(t *TLSConfig, connStr string) (string, error) {
+	if !t.enabled() || (t.Mode != TLSModeVerifyCA && t.ServerName == "" && !t.SkipVerify) {
+		return connStr, nil
+	}
+	connConfig, err := pgx.ParseConnectionString(connStr)
+	if err != nil {
+		return "", err
+	}
+	if connConfig.TLSConfig, err = t.clientConfig(connConfig.Host); err != nil {
+		return "", err
+	}
+	connConfig.UseFallbackTLS = false
+	connConfig.FallbackTLSConfig = nil
+	driverConfig := &stdlib.DriverConfig{ConnConfig: connConfig}
+	stdlib.RegisterDriverConfig(driverConfig)
+	// 接続文字列のTLS設定はDriverConfigの設定を上書きするので、TLSを指定しない接続文字列を渡す
+	return driverConfig.ConnectionString("sslmode=disable"), nil
+}
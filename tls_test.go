@@ -0,0 +1,249 @@
+package dbdiff
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/go-sql-driver/mysql"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// PEM files of a CA, a server certificate of db.example.com and a client certificate signed by the CA
+type testCertificates struct {
+	dir        string
+	caFile     string
+	serverCert tls.Certificate
+	clientCert string
+	clientKey  string
+	caPool     *x509.CertPool
+}
+
+func newTestCertificates(t *testing.T) *testCertificates {
+	dir, err := ioutil.TempDir("", "dbdiff_tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := &testCertificates{dir: dir, caPool: x509.NewCertPool()}
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dbdiff test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	certs.caPool.AddCert(caCert)
+	certs.caFile = certs.writePEM(t, "ca.pem", "CERTIFICATE", caDER)
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			DNSNames:     []string{name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der, key
+	}
+	serverDER, serverKey := issue(2, "db.example.com", x509.ExtKeyUsageServerAuth)
+	certs.serverCert = tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+	clientDER, clientKey := issue(3, "user1", x509.ExtKeyUsageClientAuth)
+	certs.clientCert = certs.writePEM(t, "client.pem", "CERTIFICATE", clientDER)
+	keyDER, _ := x509.MarshalECPrivateKey(clientKey)
+	certs.clientKey = certs.writePEM(t, "client-key.pem", "EC PRIVATE KEY", keyDER)
+	return certs
+}
+
+func (c *testCertificates) writePEM(t *testing.T, name string, blockType string, der []byte) string {
+	fileName := filepath.Join(c.dir, name)
+	if err := ioutil.WriteFile(fileName, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// Local TLS server requiring a client certificate, which writes "ok" after the handshake
+func (c *testCertificates) startServer(t *testing.T) net.Listener {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{c.serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    c.caPool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if conn.(*tls.Conn).Handshake() == nil {
+					conn.Write([]byte("ok"))
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestTLSConfig_clientConfig(t *testing.T) {
+	certs := newTestCertificates(t)
+	defer os.RemoveAll(certs.dir)
+	listener := certs.startServer(t)
+	defer listener.Close()
+
+	tests := []struct {
+		name    string
+		config  TLSConfig
+		wantErr bool
+	}{
+		{"VerifyFull", TLSConfig{Mode: TLSModeVerifyFull, CAFile: certs.caFile, ServerName: "db.example.com"}, false},
+		{"VerifyFullWrongName", TLSConfig{Mode: TLSModeVerifyFull, CAFile: certs.caFile, ServerName: "other.example.com"}, true},
+		{"VerifyFullUnknownCA", TLSConfig{Mode: TLSModeVerifyFull, ServerName: "db.example.com"}, true},
+		{"VerifyCA", TLSConfig{Mode: TLSModeVerifyCA, CAFile: certs.caFile, ServerName: "other.example.com"}, false},
+		{"VerifyCAUnknownCA", TLSConfig{Mode: TLSModeVerifyCA}, true},
+		{"Require", TLSConfig{Mode: TLSModeRequire}, false},
+		{"SkipVerify", TLSConfig{Mode: TLSModeRequire, SkipVerify: true}, false},
+		{"NoClientCertificate", TLSConfig{Mode: TLSModeRequire}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name != "NoClientCertificate" {
+				tt.config.CertFile, tt.config.KeyFile = certs.clientCert, certs.clientKey
+			}
+			config, err := tt.config.clientConfig("127.0.0.1")
+			if err != nil {
+				t.Fatalf("clientConfig() error = %v", err)
+			}
+			conn, err := tls.Dial("tcp", listener.Addr().String(), config)
+			if err == nil {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				// TLS 1.3ではクライアント証明書のエラーは読み込み時に分かる
+				_, err = io.ReadFull(conn, make([]byte, 2))
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("handshake error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSConfig_validate(t *testing.T) {
+	certs := newTestCertificates(t)
+	defer os.RemoveAll(certs.dir)
+	notPEM := filepath.Join(certs.dir, "not.pem")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		dbType  string
+		config  TLSConfig
+		wantErr bool
+	}{
+		{"Disabled", "postgresql", TLSConfig{}, false},
+		{"Valid", "postgresql", TLSConfig{Mode: TLSModeVerifyFull, CAFile: "ca.pem", CertFile: "client.pem", KeyFile: "client-key.pem"}, false},
+		{"InvalidMode", "postgresql", TLSConfig{Mode: "verify"}, true},
+		{"NoMode", "postgresql", TLSConfig{CAFile: "ca.pem"}, true},
+		{"CANotFound", "postgresql", TLSConfig{Mode: TLSModeVerifyCA, CAFile: "notfound.pem"}, true},
+		{"CANotPEM", "postgresql", TLSConfig{Mode: TLSModeVerifyCA, CAFile: notPEM}, true},
+		{"CertWithoutKey", "mysql", TLSConfig{Mode: TLSModeRequire, CertFile: "client.pem"}, true},
+		{"KeyMismatch", "mysql", TLSConfig{Mode: TLSModeRequire, CertFile: "ca.pem", KeyFile: "client-key.pem"}, true},
+		{"SkipVerifyWithVerify", "mysql", TLSConfig{Mode: TLSModeVerifyFull, SkipVerify: true}, true},
+		{"MSSQLClientCertificate", "mssql", TLSConfig{Mode: TLSModeRequire, CertFile: "client.pem", KeyFile: "client-key.pem"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(tt.dbType, certs.dir); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_tlsParams(t *testing.T) {
+	tests := []struct {
+		name string
+		db   Db
+		want map[string]string
+	}{
+		{"NotConfigured", Db{DbType: "postgresql"}, nil},
+		{"PostgreSQL", Db{DbType: "postgresql", TLS: TLSConfig{Mode: TLSModeVerifyFull, CAFile: "/ca.pem", CertFile: "/c.pem", KeyFile: "/k.pem"}},
+			map[string]string{"sslmode": "verify-full", "sslrootcert": "/ca.pem", "sslcert": "/c.pem", "sslkey": "/k.pem"}},
+		{"PostgreSQLSkipVerify", Db{DbType: "postgresql", TLS: TLSConfig{Mode: TLSModeRequire, SkipVerify: true}},
+			map[string]string{"sslmode": "require"}},
+		{"MSSQL", Db{DbType: "mssql", TLS: TLSConfig{Mode: TLSModeVerifyFull, CAFile: "/ca.pem", ServerName: "db.example.com"}},
+			map[string]string{"encrypt": "true", "TrustServerCertificate": "false", "certificate": "/ca.pem", "hostNameInCertificate": "db.example.com"}},
+		{"MSSQLDisable", Db{DbType: "mssql", TLS: TLSConfig{Mode: TLSModeDisable}}, map[string]string{"encrypt": "disable"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tlsParams(&tt.db); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tlsParams() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_connectionString_MySQLTLS(t *testing.T) {
+	_, connStr, err := connectionString(&Db{DbType: "mysql", Host: "db", Port: "3306", Name: "x", TLS: TLSConfig{Mode: TLSModeRequire}})
+	if err != nil {
+		t.Fatalf("connectionString() error = %v", err)
+	}
+	config, err := mysql.ParseDSN(connStr)
+	if err != nil {
+		t.Fatalf("ParseDSN(%v) error = %v", connStr, err)
+	}
+	if !strings.HasPrefix(config.TLSConfig, "dbdiff") {
+		t.Errorf("TLSConfig = %v, want a registered configuration", config.TLSConfig)
+	}
+}
+
+func Test_postgreSQLDriverConfig(t *testing.T) {
+	certs := newTestCertificates(t)
+	defer os.RemoveAll(certs.dir)
+	connStr := "postgresql://user1@db:5432/x?sslmode=verify-full&sslrootcert=" + certs.caFile
+
+	// pgxのパラメータで設定できるものはそのまま
+	got, err := postgreSQLDriverConfig(&TLSConfig{Mode: TLSModeVerifyFull, CAFile: certs.caFile}, connStr)
+	if err != nil || got != connStr {
+		t.Errorf("postgreSQLDriverConfig() = %q, %v, want %q", got, err, connStr)
+	}
+	got, err = postgreSQLDriverConfig(&TLSConfig{Mode: TLSModeVerifyFull, CAFile: certs.caFile, ServerName: "db.example.com"}, connStr)
+	if err != nil || got == connStr || !strings.HasSuffix(got, "sslmode=disable") {
+		t.Errorf("postgreSQLDriverConfig() = %q, %v, want a connection string of DriverConfig", got, err)
+	}
+}
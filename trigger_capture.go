@@ -0,0 +1,430 @@
+package dbdiff
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	ChangeLogTableName = "dbdiff_change_log" // Name of the table that triggers write changed rows into
+	TriggerNamePrefix  = "dbdiff_trg_"       // Prefix of the names of installed triggers
+)
+
+const (
+	triggerFunctionName     = "dbdiff_capture"
+	triggerNameMaxLength    = 63 // PostgreSQL: 63, MySQL: 64, SQL Server: 128
+	changeLogQueryFormatStr = "SELECT seq, table_name, old_data, new_data FROM %s WHERE seq > %s ORDER BY seq"
+	// Seqs skipped by the change log are re-read until this time passes, and then regarded as rolled back.
+	changeLogGapTimeout = 5 * time.Minute
+	// Gaps wider than this(e.g. IDENTITY of SQL Server jumps by 1000 after a restart) are not re-read
+	changeLogMaxGap = 10000
+)
+
+// Change capture with temporary audit triggers.
+//
+// Instead of reading entire tables, triggers installed on the tables write the changed rows(as JSON) into
+// ChangeLogTableName, and Collect() builds before/after data only for the changed rows.
+// Values are represented as JSON values of the database, so they may differ from the snapshot mode.
+type TriggerCapture struct {
+	db       DbHolder
+	config   *Configuration
+	tablePks map[string][]string
+	columns  map[string][]string
+
+	installedTables []string
+	logCreated      bool
+	functionCreated bool
+	cursor          changeLogCursor
+}
+
+// tablePks is the target tables and their primary keys(see GetPksOfTables)
+func NewTriggerCapture(db DbHolder, config *Configuration, tablePks map[string][]string) *TriggerCapture {
+	captureTablePks := map[string][]string{}
+	for tableName, pkColumns := range tablePks {
+		if strings.EqualFold(tableName, ChangeLogTableName) {
+			continue
+		}
+		captureTablePks[tableName] = pkColumns
+	}
+	return &TriggerCapture{db: db, config: config, tablePks: captureTablePks, columns: map[string][]string{}}
+}
+
+// Create the change log table and install triggers on the target tables.
+//
+// If it fails, already installed objects are uninstalled. Statements are canceled when ctx is done, and each of them is
+// limited by Snapshot.QueryTimeout.
+func (tc *TriggerCapture) Install(ctx context.Context) error {
+	err := tc.install(ctx)
+	if err != nil {
+		if uninstallErr := tc.Uninstall(); uninstallErr != nil {
+			return fmt.Errorf("%v (uninstall also failed: %v)", err, uninstallErr)
+		}
+	}
+	return err
+}
+
+func (tc *TriggerCapture) install(ctx context.Context) error {
+	schema := tc.config.Db.Schema
+	dbType := tc.config.Db.DbType
+
+	var ddl string
+	switch dbType {
+	case "postgresql":
+		ddl = "CREATE TABLE %s (seq BIGSERIAL PRIMARY KEY, table_name VARCHAR(255) NOT NULL, old_data TEXT, new_data TEXT)"
+	case "mysql":
+		ddl = "CREATE TABLE %s (seq BIGINT AUTO_INCREMENT PRIMARY KEY, table_name VARCHAR(255) NOT NULL, old_data LONGTEXT, new_data LONGTEXT)"
+	case "mssql":
+		ddl = "CREATE TABLE %s (seq BIGINT IDENTITY(1,1) PRIMARY KEY, table_name NVARCHAR(255) NOT NULL, old_data NVARCHAR(MAX), new_data NVARCHAR(MAX))"
+	default:
+		return &ErrUnsupportedDialect{DbType: dbType}
+	}
+	if err := tc.exec(ctx, fmt.Sprintf(ddl, schema+ChangeLogTableName)); err != nil {
+		return err
+	}
+	tc.logCreated = true
+
+	if dbType == "postgresql" {
+		function := `
+		CREATE OR REPLACE FUNCTION %[1]s%[2]s() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'INSERT' THEN
+				INSERT INTO %[1]s%[3]s(table_name, new_data) VALUES (TG_TABLE_NAME, row_to_json(NEW)::text);
+			ELSIF TG_OP = 'UPDATE' THEN
+				INSERT INTO %[1]s%[3]s(table_name, old_data, new_data) VALUES (TG_TABLE_NAME, row_to_json(OLD)::text, row_to_json(NEW)::text);
+			ELSE
+				INSERT INTO %[1]s%[3]s(table_name, old_data) VALUES (TG_TABLE_NAME, row_to_json(OLD)::text);
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`
+		if err := tc.exec(ctx, fmt.Sprintf(function, schema, triggerFunctionName, ChangeLogTableName)); err != nil {
+			return err
+		}
+		tc.functionCreated = true
+	}
+
+	for tableName := range tc.tablePks {
+		columns, err := tc.columnNames(ctx, tableName)
+		if err != nil {
+			return err
+		}
+		tc.columns[tableName] = columns
+
+		// 途中で失敗してもUninstall()で削除されるよう先に登録しておく
+		tc.installedTables = append(tc.installedTables, tableName)
+		for _, stmt := range tc.createTriggerStatements(tableName, columns) {
+			if err := tc.exec(ctx, stmt); err != nil {
+				return fmt.Errorf("can not install trigger on %s: %w", tableName, err)
+			}
+		}
+	}
+
+	// 以降の変更のみを対象にする
+	queryCtx, cancel := queryContext(ctx, tc.config)
+	defer cancel()
+	return tc.db.QueryRowContext(queryCtx, "SELECT COALESCE(MAX(seq), 0) FROM "+schema+ChangeLogTableName).Scan(&tc.cursor.lastSeq)
+}
+
+// Execute a statement limited by Snapshot.QueryTimeout
+func (tc *TriggerCapture) exec(ctx context.Context, stmt string) error {
+	ctx, cancel := queryContext(ctx, tc.config)
+	defer cancel()
+	_, err := tc.db.ExecContext(ctx, stmt)
+	return err
+}
+
+func (tc *TriggerCapture) columnNames(ctx context.Context, tableName string) ([]string, error) {
+	ctx, cancel := queryContext(ctx, tc.config)
+	defer cancel()
+	return GetColumnNames(ctx, tc.db, tableName, tc.config.Db.Schema)
+}
+
+func (tc *TriggerCapture) createTriggerStatements(tableName string, columns []string) []string {
+	schema := tc.config.Db.Schema
+	logTable := schema + ChangeLogTableName
+	switch tc.config.Db.DbType {
+	case "postgresql":
+		return []string{fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE PROCEDURE %s()",
+			triggerName(tableName, ""), schema+tableName, schema+triggerFunctionName)}
+	case "mysql":
+		jsonObject := func(ref string) string {
+			var args []string
+			for _, col := range columns {
+				// 予約語や空白を含むカラム名もあるので引用する
+				args = append(args, fmt.Sprintf("'%s', %s.`%s`", strings.Replace(col, "'", "''", -1), ref, strings.Replace(col, "`", "``", -1)))
+			}
+			return "JSON_OBJECT(" + strings.Join(args, ", ") + ")"
+		}
+		const format = "CREATE TRIGGER %s AFTER %s ON %s FOR EACH ROW INSERT INTO %s(table_name, old_data, new_data) VALUES ('%s', %s, %s)"
+		return []string{
+			fmt.Sprintf(format, triggerName(tableName, "i"), "INSERT", schema+tableName, logTable, tableName, "NULL", jsonObject("NEW")),
+			fmt.Sprintf(format, triggerName(tableName, "u"), "UPDATE", schema+tableName, logTable, tableName, jsonObject("OLD"), jsonObject("NEW")),
+			fmt.Sprintf(format, triggerName(tableName, "d"), "DELETE", schema+tableName, logTable, tableName, jsonObject("OLD"), "NULL"),
+		}
+	case "mssql":
+		// 更新は削除(deleted)→追加(inserted)の順で記録され、Collect()でキーごとにまとめる
+		return []string{fmt.Sprintf(`
+		CREATE TRIGGER %[1]s%[2]s ON %[3]s AFTER INSERT, UPDATE, DELETE AS
+		BEGIN
+			SET NOCOUNT ON;
+			INSERT INTO %[4]s(table_name, old_data)
+				SELECT '%[5]s', (SELECT d.* FOR JSON PATH, WITHOUT_ARRAY_WRAPPER, INCLUDE_NULL_VALUES) FROM deleted d;
+			INSERT INTO %[4]s(table_name, new_data)
+				SELECT '%[5]s', (SELECT i.* FOR JSON PATH, WITHOUT_ARRAY_WRAPPER, INCLUDE_NULL_VALUES) FROM inserted i;
+		END`, schema, triggerName(tableName, ""), schema+tableName, logTable, tableName)}
+	}
+	return nil
+}
+
+func (tc *TriggerCapture) dropTriggerStatements(tableName string) []string {
+	schema := tc.config.Db.Schema
+	switch tc.config.Db.DbType {
+	case "postgresql":
+		return []string{fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", triggerName(tableName, ""), schema+tableName)}
+	case "mysql":
+		var stmts []string
+		for _, suffix := range []string{"i", "u", "d"} {
+			stmts = append(stmts, fmt.Sprintf("DROP TRIGGER IF EXISTS %s", schema+triggerName(tableName, suffix)))
+		}
+		return stmts
+	case "mssql":
+		return []string{fmt.Sprintf("IF OBJECT_ID('%[1]s', 'TR') IS NOT NULL DROP TRIGGER %[1]s", schema+triggerName(tableName, ""))}
+	}
+	return nil
+}
+
+// Name of the trigger for the table. suffix distinguishes triggers for each operation.
+func triggerName(tableName string, suffix string) string {
+	if suffix != "" {
+		suffix = "_" + suffix
+	}
+	name := TriggerNamePrefix + tableName
+	if len(name)+len(suffix) > triggerNameMaxLength {
+		name = name[:triggerNameMaxLength-len(suffix)]
+	}
+	return name + suffix
+}
+
+// Drop the installed triggers and the change log table.
+//
+// It tries to drop all objects even if some of them fail, and returns the first error.
+func (tc *TriggerCapture) Uninstall() error {
+	var firstErr error
+	exec := func(stmt string) {
+		if _, err := tc.db.Exec(stmt); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	schema := tc.config.Db.Schema
+	for _, tableName := range tc.installedTables {
+		for _, stmt := range tc.dropTriggerStatements(tableName) {
+			exec(stmt)
+		}
+	}
+	tc.installedTables = nil
+	if tc.functionCreated {
+		exec(fmt.Sprintf("DROP FUNCTION IF EXISTS %s()", schema+triggerFunctionName))
+		tc.functionCreated = false
+	}
+	if tc.logCreated {
+		exec("DROP TABLE " + schema + ChangeLogTableName)
+		tc.logCreated = false
+	}
+	return firstErr
+}
+
+// Collect changes since the previous Collect()(or Install()).
+//
+// before holds the first state and after holds the last state of each changed row, so
+// after.ExtractChangedData(before) gives the same result as the snapshot mode. The query is canceled when ctx is done.
+func (tc *TriggerCapture) Collect(ctx context.Context) (before *AllTableStore, after *AllTableStore, err error) {
+	ctx, cancel := queryContext(ctx, tc.config)
+	defer cancel()
+	schema := tc.config.Db.Schema
+	query := fmt.Sprintf(changeLogQueryFormatStr, schema+ChangeLogTableName, placeholder(tc.config.Db.DbType, 1))
+	rows, err := tc.db.QueryContext(ctx, query, tc.cursor.fromSeq())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var entries []changeLogEntry
+	for rows.Next() {
+		var entry changeLogEntry
+		if err = rows.Scan(&entry.seq, &entry.tableName, &entry.oldData, &entry.newData); err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	cursor, entries := tc.cursor.advance(entries, time.Now())
+	before, after, err = mergeChangeLog(entries, tc.tablePks, tc.columns)
+	if err != nil {
+		return nil, nil, err
+	}
+	tc.cursor = cursor
+	return before, after, nil
+}
+
+// Position of the change log read by Collect().
+//
+// seq is assigned when a row is inserted, but the row is visible when the transaction commits. So a transaction can
+// commit a smaller seq after a larger seq is read, and the skipped seqs(gaps) are re-read by the next Collect()
+// until they appear or changeLogGapTimeout passes(e.g. the transaction was rolled back).
+type changeLogCursor struct {
+	lastSeq int64               // the largest seq read
+	gaps    map[int64]time.Time // seqs less than lastSeq not read yet, and when they were found
+}
+
+// Entries whose seq is greater than this are to be read
+func (c changeLogCursor) fromSeq() int64 {
+	from := c.lastSeq
+	for seq := range c.gaps {
+		if seq-1 < from {
+			from = seq - 1
+		}
+	}
+	return from
+}
+
+// Cursor after reading entries(in order of seq, from fromSeq()), and the entries not read before.
+// The receiver is not modified, so that the cursor is advanced only if the entries are processed.
+func (c changeLogCursor) advance(entries []changeLogEntry, now time.Time) (changeLogCursor, []changeLogEntry) {
+	next := changeLogCursor{lastSeq: c.lastSeq, gaps: map[int64]time.Time{}}
+	for seq, found := range c.gaps {
+		if now.Sub(found) < changeLogGapTimeout {
+			next.gaps[seq] = found
+		}
+	}
+
+	var unread []changeLogEntry
+	for _, entry := range entries {
+		if entry.seq <= next.lastSeq {
+			// 読み込み済みのseqは除き、空いていたseqだけを加える
+			if _, ok := next.gaps[entry.seq]; ok {
+				delete(next.gaps, entry.seq)
+				unread = append(unread, entry)
+			}
+			continue
+		}
+		if entry.seq-next.lastSeq-1 <= changeLogMaxGap {
+			for seq := next.lastSeq + 1; seq < entry.seq; seq++ {
+				next.gaps[seq] = now
+			}
+		}
+		next.lastSeq = entry.seq
+		unread = append(unread, entry)
+	}
+	return next, unread
+}
+
+// A row of the change log table
+type changeLogEntry struct {
+	seq       int64
+	tableName string
+	oldData   sql.NullString // row before the change, NULL for an insert
+	newData   sql.NullString // row after the change, NULL for a delete
+}
+
+// Merge the change log(in order of seq) into the first state(before) and the last state(after) of each changed row.
+//
+// A row inserted and then deleted is in neither of them, and a chain of updates has the first before and the last after.
+// An update of SQL Server is logged as a delete followed by an insert of the same key, which is merged in the same way.
+// Entries of the tables not in tablePks are ignored.
+func mergeChangeLog(entries []changeLogEntry, tablePks map[string][]string, columns map[string][]string) (before *AllTableStore, after *AllTableStore, err error) {
+	before = &AllTableStore{AllData: map[string]map[string]*RowObject{}, AllColumn: map[string][]string{}, alreadyCollectData: true}
+	after = &AllTableStore{AllData: map[string]map[string]*RowObject{}, AllColumn: map[string][]string{}, alreadyCollectData: true}
+	// キーごとに最初に現れた時点で既に存在していたか
+	seen := map[string]map[string]struct{}{}
+
+	for _, entry := range entries {
+		tableName := entry.tableName
+		pkColumns, ok := tablePks[tableName]
+		if !ok {
+			continue
+		}
+		tableColumns := columns[tableName]
+		if _, ok := before.AllData[tableName]; !ok {
+			before.AllData[tableName] = map[string]*RowObject{}
+			after.AllData[tableName] = map[string]*RowObject{}
+			before.AllColumn[tableName] = tableColumns
+			after.AllColumn[tableName] = tableColumns
+			seen[tableName] = map[string]struct{}{}
+		}
+
+		if entry.oldData.Valid {
+			row, err := rowObjectFromJSON(entry.oldData.String, tableColumns)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid change log [seq:%d]: %v", entry.seq, err)
+			}
+			key := row.GetKey(pkColumns)
+			if _, ok := seen[tableName][key]; !ok {
+				seen[tableName][key] = struct{}{}
+				before.AllData[tableName][key] = row
+			}
+			delete(after.AllData[tableName], key)
+		}
+		if entry.newData.Valid {
+			row, err := rowObjectFromJSON(entry.newData.String, tableColumns)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid change log [seq:%d]: %v", entry.seq, err)
+			}
+			key := row.GetKey(pkColumns)
+			// 最初に追加として現れたキーは変更前には存在しない
+			seen[tableName][key] = struct{}{}
+			after.AllData[tableName][key] = row
+		}
+	}
+
+	for tableName := range before.AllData {
+		before.TotalDataCount += uint64(len(before.AllData[tableName]))
+		after.TotalDataCount += uint64(len(after.AllData[tableName]))
+	}
+	return before, after, nil
+}
+
+// Build RowObject from a JSON object, values are ordered by columns.
+func rowObjectFromJSON(data string, columns []string) (*RowObject, error) {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+
+	var colScans []*ColumnScan
+	for _, col := range columns {
+		ns := &sql.NullString{}
+		if v, ok := values[col]; ok && v != nil {
+			ns.Valid = true
+			switch value := v.(type) {
+			case string:
+				ns.String = value
+			case json.Number:
+				ns.String = value.String()
+			case bool:
+				ns.String = fmt.Sprintf("%t", value)
+			default:
+				// json/jsonbカラムなど
+				buf := &bytes.Buffer{}
+				encoder := json.NewEncoder(buf)
+				encoder.SetEscapeHTML(false)
+				if err := encoder.Encode(value); err != nil {
+					return nil, err
+				}
+				ns.String = strings.TrimRight(buf.String(), "\n")
+			}
+		}
+		colScans = append(colScans, &ColumnScan{Value: ns})
+	}
+	return &RowObject{ColScans: colScans, DiffStatus: DiffStatusInit, ModifiedColumnIndex: []uint8{}, ColumnNames: columns, IsBeforeData: false}, nil
+}
@@ -0,0 +1,204 @@
+package dbdiff
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_rowObjectFromJSON(t *testing.T) {
+	columns := []string{"id", "name", "price", "active", "deleted_at", "settings"}
+	row, err := rowObjectFromJSON(`{"settings": {"theme": "<dark>"}, "id": 1, "name": "alice", "price": 10.50, "active": true, "deleted_at": null}`, columns)
+	if err != nil {
+		t.Fatalf("rowObjectFromJSON() error = %v", err)
+	}
+	want := "([id:1][name:alice][price:10.50][active:true][deleted_at:<NULL>][settings:{\"theme\":\"<dark>\"}])"
+	if got := row.String(); got != want {
+		t.Errorf("rowObjectFromJSON() = %v, want %v", got, want)
+	}
+
+	if _, err := rowObjectFromJSON(`[1, 2]`, columns); err == nil {
+		t.Errorf("rowObjectFromJSON() error = nil, want error")
+	}
+}
+
+func Test_triggerName(t *testing.T) {
+	tests := []struct {
+		name      string
+		tableName string
+		suffix    string
+		want      string
+	}{
+		{"NoSuffix", "users", "", "dbdiff_trg_users"},
+		{"Suffix", "users", "i", "dbdiff_trg_users_i"},
+		{"Long", strings.Repeat("t", 60), "u", "dbdiff_trg_" + strings.Repeat("t", 50) + "_u"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := triggerName(tt.tableName, tt.suffix); got != tt.want {
+				t.Errorf("triggerName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_mergeChangeLog(t *testing.T) {
+	tablePks := map[string][]string{"users": {"id"}}
+	columns := map[string][]string{"users": {"id", "name"}}
+	entry := func(oldData string, newData string) changeLogEntry {
+		e := changeLogEntry{tableName: "users"}
+		e.oldData.String, e.oldData.Valid = oldData, oldData != ""
+		e.newData.String, e.newData.Valid = newData, newData != ""
+		return e
+	}
+	const (
+		alice  = `{"id": 1, "name": "alice"}`
+		alice2 = `{"id": 1, "name": "alice2"}`
+		alice3 = `{"id": 1, "name": "alice3"}`
+		bob    = `{"id": 2, "name": "bob"}`
+	)
+	tests := []struct {
+		name       string
+		entries    []changeLogEntry
+		wantBefore []string
+		wantAfter  []string
+	}{
+		{"Insert", []changeLogEntry{entry("", alice)}, nil, []string{alice}},
+		{"Delete", []changeLogEntry{entry(alice, "")}, []string{alice}, nil},
+		{"InsertThenDelete", []changeLogEntry{entry("", bob), entry(bob, "")}, nil, nil},
+		{"UpdateChain", []changeLogEntry{entry(alice, alice2), entry(alice2, alice3)}, []string{alice}, []string{alice3}},
+		{"DeleteThenInsert", []changeLogEntry{entry(alice, ""), entry("", alice2)}, []string{alice}, []string{alice2}},
+		// SQL Serverの更新は削除と追加の組で記録される
+		{"MssqlUpdates", []changeLogEntry{entry(alice, ""), entry("", alice2), entry(alice2, ""), entry("", alice3)},
+			[]string{alice}, []string{alice3}},
+		{"InsertThenUpdate", []changeLogEntry{entry("", bob), entry(bob, `{"id": 2, "name": "bobby"}`)},
+			nil, []string{`{"id": 2, "name": "bobby"}`}},
+		{"OtherTable", []changeLogEntry{{tableName: "dbdiff_change_log"}}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after, err := mergeChangeLog(tt.entries, tablePks, columns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, side := range []struct {
+				name  string
+				store *AllTableStore
+				want  []string
+			}{{"before", before, tt.wantBefore}, {"after", after, tt.wantAfter}} {
+				want := map[string]string{}
+				for _, data := range side.want {
+					row, err := rowObjectFromJSON(data, columns["users"])
+					if err != nil {
+						t.Fatal(err)
+					}
+					want[row.GetKey(tablePks["users"])] = row.String()
+				}
+				got := map[string]string{}
+				for key, row := range side.store.AllData["users"] {
+					got[key] = row.String()
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("mergeChangeLog() %s = %v, want %v", side.name, got, want)
+				}
+				if side.store.TotalDataCount != uint64(len(want)) {
+					t.Errorf("mergeChangeLog() %s TotalDataCount = %d, want %d", side.name, side.store.TotalDataCount, len(want))
+				}
+			}
+		})
+	}
+
+	if _, _, err := mergeChangeLog([]changeLogEntry{entry("[1]", "")}, tablePks, columns); err == nil {
+		t.Error("mergeChangeLog() error = nil, want error of the invalid change log")
+	}
+}
+
+func Test_changeLogCursor(t *testing.T) {
+	tablePks := map[string][]string{"users": {"id"}}
+	columns := map[string][]string{"users": {"id", "name"}}
+	entry := func(seq int64, newData string) changeLogEntry {
+		e := changeLogEntry{seq: seq, tableName: "users"}
+		e.newData.String, e.newData.Valid = newData, true
+		return e
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 各Collect()で読まれる変更ログ(fromSeq()より大きいseq)
+	type collect struct {
+		at       time.Duration
+		log      []changeLogEntry
+		wantFrom int64
+		wantIds  []string
+	}
+	tests := []struct {
+		name     string
+		collects []collect
+	}{
+		{
+			// seq 5のトランザクションがseq 6より後にコミットされる
+			name: "OutOfOrderCommit",
+			collects: []collect{
+				{0, []changeLogEntry{entry(4, `{"id": 4}`), entry(6, `{"id": 6}`)}, 3, []string{"4", "6"}},
+				{time.Second, []changeLogEntry{entry(5, `{"id": 5}`), entry(6, `{"id": 6}`), entry(7, `{"id": 7}`)}, 4, []string{"5", "7"}},
+				{2 * time.Second, nil, 7, nil},
+			},
+		},
+		{
+			// ロールバックされたseqはタイムアウト後に読まない
+			name: "RolledBack",
+			collects: []collect{
+				{0, []changeLogEntry{entry(4, `{"id": 4}`), entry(6, `{"id": 6}`)}, 3, []string{"4", "6"}},
+				{time.Second, []changeLogEntry{entry(6, `{"id": 6}`)}, 4, nil},
+				{changeLogGapTimeout, []changeLogEntry{entry(6, `{"id": 6}`)}, 4, nil},
+				{changeLogGapTimeout + time.Second, nil, 6, nil},
+			},
+		},
+		{
+			name: "WideGap",
+			collects: []collect{
+				{0, []changeLogEntry{entry(4, `{"id": 4}`), entry(changeLogMaxGap+10, `{"id": 9}`)}, 3, []string{"4", "9"}},
+				{time.Second, nil, changeLogMaxGap + 10, nil},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := changeLogCursor{lastSeq: 3}
+			for i, c := range tt.collects {
+				if got := cursor.fromSeq(); got != c.wantFrom {
+					t.Fatalf("collect %d: fromSeq() = %d, want %d", i, got, c.wantFrom)
+				}
+				var entries []changeLogEntry
+				cursor, entries = cursor.advance(c.log, start.Add(c.at))
+				_, after, err := mergeChangeLog(entries, tablePks, columns)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var ids []string
+				for key := range after.AllData["users"] {
+					ids = append(ids, key)
+				}
+				sort.Strings(ids)
+				var want []string
+				for _, id := range c.wantIds {
+					row, _ := rowObjectFromJSON(`{"id": `+id+`}`, columns["users"])
+					want = append(want, row.GetKey(tablePks["users"]))
+				}
+				sort.Strings(want)
+				if !reflect.DeepEqual(ids, want) {
+					t.Errorf("collect %d: changed keys = %v, want %v", i, ids, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTriggerCapture_createTriggerStatements_MySQL(t *testing.T) {
+	tc := &TriggerCapture{config: &Configuration{Db: Db{DbType: "mysql"}}}
+	stmts := tc.createTriggerStatements("orders", []string{"id", "order", "unit price", "a`b"})
+	want := "JSON_OBJECT('id', NEW.`id`, 'order', NEW.`order`, 'unit price', NEW.`unit price`, 'a`b', NEW.`a``b`)"
+	if len(stmts) != 3 || !strings.Contains(stmts[0], want) {
+		t.Errorf("createTriggerStatements() = %v, want %v", stmts, want)
+	}
+}
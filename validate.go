@@ -0,0 +1,442 @@
+package dbdiff
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Problem of a configuration file found by ValidateConfigurationFile()
+type ConfigProblem struct {
+	Line    int // 0 if unknown
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+func problemsError(problems []ConfigProblem) error {
+	messages := make([]string, len(problems))
+	for i, problem := range problems {
+		messages[i] = problem.String()
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// Layout of a configuration file
+type configurationFile struct {
+	Configuration `yaml:",inline"`
+	Defaults      Configuration            `yaml:"defaults"`
+	Profiles      map[string]Configuration `yaml:"profiles"`
+}
+
+// Check the configuration file strictly: unknown keys, types of values, required settings of each dbtype, filters and
+// referenced files of the top level settings and all profiles. The problems are ordered by line.
+//
+// Unlike LoadConfiguration(), password_command is not executed.
+func ValidateConfigurationFile(configFilePath string) ([]ConfigProblem, error) {
+	buf, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	document := &yaml.Node{}
+	if err = yaml.Unmarshal(buf, document); err != nil {
+		return yamlErrorProblems(err), nil
+	}
+	if len(document.Content) == 0 {
+		return []ConfigProblem{{Message: "configuration is empty"}}, nil
+	}
+
+	problems := checkUnknownKeys(document)
+	root := document.Content[0]
+	profiles := profileNames(mappingValue(root, "profiles"))
+	// プロファイルだけのファイルではトップレベルの設定は使われない
+	if len(profiles) == 0 || mappingValue(root, "db") != nil {
+		profiles = append([]string{""}, profiles...)
+	}
+	// defaultsの問題はプロファイルごとに見つかるので、同じ行の同じ問題は最初の1つだけにする
+	seen := map[string]struct{}{}
+	for _, profile := range profiles {
+		for _, problem := range validateProfile(document, profile, filepath.Dir(configFilePath)) {
+			key := strconv.Itoa(problem.Line) + ":" + problem.Message
+			if _, ok := seen[key]; ok && problem.Line != 0 {
+				continue
+			}
+			seen[key] = struct{}{}
+			if profile != "" {
+				problem.Message = "profile " + profile + ": " + problem.Message
+			}
+			problems = append(problems, problem)
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Line < problems[j].Line
+	})
+	return problems, nil
+}
+
+func validateProfile(document *yaml.Node, profile string, baseDir string) []ConfigProblem {
+	merged, err := selectProfile(document, profile)
+	if err != nil {
+		return []ConfigProblem{{Message: err.Error()}}
+	}
+	var problems []ConfigProblem
+	config := &Configuration{}
+	interpolateEnv(merged, "", &config.sources, &problems)
+	if err := merged.Decode(config); err != nil {
+		return append(problems, yamlErrorProblems(err)...)
+	}
+	root := merged.Content[0]
+	lineOf := func(path ...string) int {
+		return nodeLine(root, path...)
+	}
+	add := func(line int, format string, args ...interface{}) {
+		problems = append(problems, ConfigProblem{Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// 接続設定
+	db := &config.Db
+	if db.DbType == "" && db.URL != "" {
+		db.DbType = dbTypeOfURL(db.URL)
+	}
+	switch db.DbType {
+	case "postgresql", "mysql", "mssql":
+	case "":
+		add(lineOf("db"), "db.type is required (postgresql|mysql|mssql)")
+	default:
+		add(lineOf("db", "type"), "db.type [%s] is not supported (postgresql|mysql|mssql)", db.DbType)
+	}
+	if db.DSN != "" && db.URL != "" {
+		add(lineOf("db", "url"), "only one of db.dsn and db.url can be specified")
+	}
+	passwords := 0
+	for _, v := range []string{db.Password, db.PasswordFile, db.PasswordCommand} {
+		if v != "" {
+			passwords++
+		}
+	}
+	if passwords > 1 {
+		add(lineOf("db"), "only one of db.password, db.password_file and db.password_command can be specified")
+	}
+	if db.DSN == "" && db.URL == "" {
+		required := []string{"host", "name"}
+		if db.DbType == "mssql" {
+			required = []string{"host"}
+		}
+		// パスワードがなければuserも資格情報ファイルから読み込める
+		if passwords > 0 {
+			required = append(required, "user")
+		}
+		values := map[string]string{"host": db.Host, "user": db.User, "name": db.Name}
+		for _, key := range required {
+			if values[key] == "" {
+				add(lineOf("db"), "db.%s is required", key)
+			}
+		}
+	}
+	if db.Port != "" {
+		if port, err := strconv.Atoi(db.Port); err != nil || port <= 0 || port > 65535 {
+			add(lineOf("db", "port"), "db.port [%s] is not a valid port number", db.Port)
+		}
+	}
+	if db.DSN != "" || db.URL != "" {
+		if _, _, err := connectionString(db); err != nil {
+			add(lineOf("db", "dsn")+lineOf("db", "url"), "invalid connection string: %v", err)
+		}
+	}
+
+	// 参照するファイル(password_commandは実行しない)
+	for key, fileName := range map[string]string{"password_file": db.PasswordFile, "credentials_file": db.CredentialsFile} {
+		if fileName != "" {
+			if _, err := os.Stat(resolvePath(baseDir, fileName)); err != nil {
+				add(lineOf("db", key), "db.%s: %v", key, err)
+			}
+		}
+	}
+	if err := db.TLS.validate(db.DbType, baseDir); err != nil {
+		add(lineOf("db", "tls"), "%v", err)
+	}
+
+	// スナップショットとフィルタ
+	snapshot := &config.Snapshot
+	if snapshot.ChunkSize < 0 {
+		add(lineOf("snapshot", "chunk_size"), "snapshot.chunk_size must not be negative")
+	}
+	if snapshot.MaxCellLength < 0 {
+		add(lineOf("snapshot", "max_cell_length"), "snapshot.max_cell_length must not be negative")
+	}
+	if snapshot.BlobPreviewBytes < 0 {
+		add(lineOf("snapshot", "blob_preview_bytes"), "snapshot.blob_preview_bytes must not be negative")
+	}
+	if snapshot.QueryTimeout < 0 || snapshot.Timeout < 0 {
+		add(lineOf("snapshot"), "snapshot timeouts must not be negative")
+	}
+	checkSamplePercent := func(line int, key string, percent float64) {
+		if percent < 0 || percent > 100 {
+			add(line, "%s must be between 0 and 100", key)
+		}
+	}
+	checkWhere := func(line int, key string, where string) {
+		if err := checkFilterSyntax(where, snapshot.Params); err != nil {
+			add(line, "%s: %v", key, err)
+		}
+	}
+	checkSamplePercent(lineOf("snapshot", "sample_percent"), "snapshot.sample_percent", snapshot.SamplePercent)
+	checkWhere(lineOf("snapshot", "where"), "snapshot.where", snapshot.Where)
+	for _, tableName := range sortedTableConfigNames(config.Tables) {
+		table := config.Tables[tableName]
+		checkSamplePercent(lineOf("tables", tableName, "sample_percent"), "tables."+tableName+".sample_percent", table.SamplePercent)
+		checkWhere(lineOf("tables", tableName, "where"), "tables."+tableName+".where", table.Where)
+		for column, strategy := range table.Masking {
+			rule := MaskRule{Table: tableName, Column: column, Strategy: strategy}
+			if err := rule.validate(); err != nil {
+				add(lineOf("tables", tableName, "masking", column), "tables.%s.masking: %v", tableName, err)
+			}
+		}
+	}
+
+	// マスキング、比較ルール、キー変更の検出
+	for index, rule := range config.Masking.Rules {
+		if err := rule.validate(); err != nil {
+			add(lineOf("masking", "rules", strconv.Itoa(index)), "masking.rules[%d]: %v", index, err)
+		}
+	}
+	for index, rule := range config.Comparison.Rules {
+		if _, err := compileCompareRule(rule); err != nil {
+			add(lineOf("comparison", "rules", strconv.Itoa(index)), "comparison.rules[%d]: %v", index, err)
+		}
+	}
+	if err := config.KeyChanges.validate(); err != nil {
+		add(lineOf("key_changes"), "%v", err)
+	}
+	for _, tableName := range sortedTableConfigNames(config.Tables) {
+		keyChanges := KeyChangeConfig{Columns: config.Tables[tableName].KeyChangeColumns}
+		if err := keyChanges.validate(); err != nil {
+			add(lineOf("tables", tableName, "key_change_columns"), "tables.%s.key_change_columns: %v", tableName, err)
+		}
+	}
+	return problems
+}
+
+func sortedTableConfigNames(tables map[string]TableConfig) []string {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check parameters, quotes and parentheses of a filter condition
+func checkFilterSyntax(where string, params map[string]string) error {
+	if where == "" {
+		return nil
+	}
+	if strings.Count(where, "'")%2 != 0 {
+		return errors.New("unterminated string literal")
+	}
+	depth := 0
+	for _, r := range stripStringLiterals(where) {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ';':
+			return errors.New("';' is not allowed")
+		}
+		if depth < 0 {
+			break
+		}
+	}
+	if depth != 0 {
+		return errors.New("unbalanced parentheses")
+	}
+	_, err := bindFilterParams(where, params)
+	return err
+}
+
+// Keys which are not fields of the configuration, with the line numbers
+func checkUnknownKeys(document *yaml.Node) []ConfigProblem {
+	var problems []ConfigProblem
+	checkKeys(document, reflect.TypeOf(configurationFile{}), "", &problems)
+	return problems
+}
+
+func checkKeys(node *yaml.Node, t reflect.Type, path string, problems *[]ConfigProblem) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			checkKeys(child, t, path, problems)
+		}
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice {
+			for index, child := range node.Content {
+				checkKeys(child, t.Elem(), fmt.Sprintf("%s[%d]", path, index), problems)
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			childPath := key.Value
+			if path != "" {
+				childPath = path + "." + key.Value
+			}
+			switch t.Kind() {
+			case reflect.Map:
+				checkKeys(value, t.Elem(), childPath, problems)
+			case reflect.Struct:
+				fields := yamlFields(t)
+				fieldType, ok := fields[key.Value]
+				if !ok {
+					location := "top level"
+					if path != "" {
+						location = path
+					}
+					message := fmt.Sprintf("unknown key [%s] in %s", key.Value, location)
+					if suggestion := similarKey(key.Value, fields); suggestion != "" {
+						message += fmt.Sprintf(" (did you mean %s?)", suggestion)
+					}
+					*problems = append(*problems, ConfigProblem{Line: key.Line, Message: message})
+					continue
+				}
+				checkKeys(value, fieldType, childPath, problems)
+			}
+		}
+	}
+}
+
+// Types of the fields of the struct by the yaml keys
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		if field.PkgPath != "" || tag[0] == "-" {
+			continue
+		}
+		if len(tag) > 1 && tag[1] == "inline" {
+			for name, fieldType := range yamlFields(field.Type) {
+				fields[name] = fieldType
+			}
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// Known key within edit distance 2 of key, empty if none
+func similarKey(key string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", 3
+	for name := range fields {
+		if d := editDistance(key, name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a string, b string) int {
+	var beforePrevious []int
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+			// 隣り合う文字の入れ替え(tpye -> type)は1とする
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = minInt(current[j], beforePrevious[j-2]+1)
+			}
+		}
+		beforePrevious, previous = previous, current
+	}
+	return previous[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Line of the node at the path of keys(or indexes of sequences), or of the nearest existing parent
+func nodeLine(mapping *yaml.Node, path ...string) int {
+	line := mapping.Line
+	node := mapping
+	for _, key := range path {
+		if node != nil && node.Kind == yaml.SequenceNode {
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node.Content) {
+				break
+			}
+			node = node.Content[index]
+			line = node.Line
+			continue
+		}
+		if node == nil || node.Kind != yaml.MappingNode {
+			break
+		}
+		found := false
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				line = node.Content[i].Line
+				node = node.Content[i+1]
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return line
+}
+
+var yamlErrorLinePattern = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// Problems of the errors of yaml.v3, which have line numbers in the messages
+func yamlErrorProblems(err error) []ConfigProblem {
+	messages := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	}
+	problems := make([]ConfigProblem, 0, len(messages))
+	for _, message := range messages {
+		if submatches := yamlErrorLinePattern.FindStringSubmatch(message); submatches != nil {
+			line, _ := strconv.Atoi(submatches[1])
+			problems = append(problems, ConfigProblem{Line: line, Message: submatches[2]})
+		} else {
+			problems = append(problems, ConfigProblem{Message: message})
+		}
+	}
+	return problems
+}
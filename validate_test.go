@@ -0,0 +1,58 @@
+package dbdiff
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidateConfigurationFile(t *testing.T) {
+	problems, err := ValidateConfigurationFile(TestConfigPrefix + "test_config_validate.yaml")
+	if err != nil {
+		t.Fatalf("ValidateConfigurationFile() error = %v", err)
+	}
+	var got []string
+	for _, problem := range problems {
+		got = append(got, problem.String())
+	}
+	want := []string{
+		"line 1: db.type is required (postgresql|mysql|mssql)",
+		"line 1: db.host is required",
+		"line 2: unknown key [tpye] in db (did you mean type?)",
+		"line 13: tables.orders.where: unbalanced parentheses",
+		"line 14: tables.orders.sample_percent must be between 0 and 100",
+		"line 21: profile broken: db.password_file: stat testdata/configuration/notfound.txt: no such file or directory",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ValidateConfigurationFile() = %q, want %q", got, want)
+	}
+}
+
+func TestConfigurationTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbdiff_template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, dbType := range []string{"postgresql", "mysql", "mssql"} {
+		t.Run(dbType, func(t *testing.T) {
+			template, err := ConfigurationTemplate(dbType)
+			if err != nil {
+				t.Fatalf("ConfigurationTemplate() error = %v", err)
+			}
+			fileName := filepath.Join(dir, dbType+".yaml")
+			if err := ioutil.WriteFile(fileName, []byte(template), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if problems, err := ValidateConfigurationFile(fileName); err != nil || len(problems) > 0 {
+				t.Errorf("ValidateConfigurationFile() = %v, %v, want no problems", problems, err)
+			}
+		})
+	}
+	if _, err := ConfigurationTemplate("oracle"); err == nil {
+		t.Errorf("ConfigurationTemplate(oracle) error = nil, want error")
+	}
+}